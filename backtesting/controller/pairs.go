@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"math"
+	"time"
+
+	"github.com/gulll/deepmarket/backtesting/domain"
+	"github.com/gulll/deepmarket/backtesting/engine"
+)
+
+// defaultHedgeWindow is the hedge ratio window when PairSpec.HedgeWindow is unset.
+const defaultHedgeWindow = 60
+
+// HedgeWindow is the hedge ratio window of p: its HedgeWindow, or the default
+// when unset. The first window-1 bars have no hedge ratio to enter on.
+func HedgeWindow(p domain.PairSpec) int {
+	if p.HedgeWindow <= 0 {
+		return defaultHedgeWindow
+	}
+	return p.HedgeWindow
+}
+
+// pairTrade is an open pair position. Stops, targets and the trailing stop
+// act on its combined return over the gross entry value, tracked as a long
+// Trade on an index that starts at 100.
+type pairTrade struct {
+	entryTime      time.Time
+	dirA, dirB     int
+	qtyA, qtyB     int
+	entryA, entryB float64
+	track          *Trade
+}
+
+func (pt *pairTrade) pnl(a, b float64) (pnlA, pnlB float64) {
+	return float64(pt.dirA*pt.qtyA) * (a - pt.entryA), float64(pt.dirB*pt.qtyB) * (b - pt.entryB)
+}
+
+func (pt *pairTrade) index(a, b float64) float64 {
+	pa, pb := pt.pnl(a, b)
+	gross := float64(pt.qtyA)*pt.entryA + float64(pt.qtyB)*pt.entryB
+	return 100 * (1 + (pa+pb)/gross)
+}
+
+func (pt *pairTrade) close(req domain.BacktestReq, exitTime time.Time, a, b float64, reason string) domain.TradeLog {
+	pa, pb := pt.pnl(a, b)
+	dir := map[int]string{1: "long", -1: "short"}
+	return domain.TradeLog{
+		Direction:   dir[pt.dirA],
+		EntryTime:   pt.entryTime,
+		EntryPrice:  pt.entryA,
+		ExitTime:    exitTime,
+		ExitPrice:   a,
+		ExitReason:  reason,
+		Qty:         pt.qtyA,
+		PnL:         pa + pb,
+		HoldingBars: int(exitTime.Sub(pt.entryTime).Minutes()),
+		Legs: []domain.TradeLeg{
+			{Symbol: req.Symbol, Direction: dir[pt.dirA], Qty: pt.qtyA, EntryPrice: pt.entryA, ExitPrice: a, PnL: pa},
+			{Symbol: req.Pair.Symbol, Direction: dir[pt.dirB], Qty: pt.qtyB, EntryPrice: pt.entryB, ExitPrice: b, PnL: pb},
+		},
+	}
+}
+
+// RunPairBacktest simulates req.Pair: both legs open and close together on
+// the bars of ohlc (the first leg), with the second leg filled at
+// pairCloses. The second leg is sized beta-neutral, quantity times the
+// rolling OLS hedge ratio at entry, and on the opposite side unless the
+// ratio is negative. Trades report the combined PnL of both legs. As in
+// RunBacktest, no trade is entered on the first start bars.
+func RunPairBacktest(req domain.BacktestReq, rt *engine.Runtime, entryPlan *engine.Plan, exitPlan *engine.Plan,
+	ohlc []domain.Candle, pairCloses []float64, start int) ([]domain.TradeLog, []bool, []float64, error) {
+
+	entrySer, exitSeries, warm, err := execSignals(rt, entryPlan, exitPlan, len(ohlc), start)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	exitChecker := newExitChecker(req)
+	dir := entryDirection(req)
+
+	window := HedgeWindow(*req.Pair)
+	closes := make([]float64, len(ohlc))
+	for i, bar := range ohlc {
+		closes[i] = bar.Close
+	}
+	hedge := engine.HedgeRatio(closes, pairCloses, window)
+
+	var trades []domain.TradeLog
+	var active *pairTrade
+	equity := []float64{}
+	capital := float64(req.Capital)
+
+	for i, bar := range ohlc {
+		a, b := bar.Close, pairCloses[i]
+
+		if active != nil && !math.IsNaN(b) {
+			exit, reason := exitChecker.CheckExit(active.track, active.index(a, b), bar.Time, i)
+			if !exit && i < len(exitSeries) && exitSeries[i] {
+				exit, reason = true, "ExitCondition"
+			}
+			if !exit {
+				exit, reason = exitChecker.CheckIntradayExit(bar.Time)
+			}
+			if exit {
+				log := active.close(req, bar.Time, a, b, reason)
+				trades = append(trades, log)
+				capital += log.PnL
+				active = nil
+			}
+		}
+
+		if active == nil && i >= warm && i < len(entrySer) && entrySer[i] &&
+			exitChecker.AllowEntry(bar.Time) && !math.IsNaN(b) && !math.IsNaN(hedge[i]) {
+			qtyB := int(math.Round(math.Abs(hedge[i]) * float64(req.Quantity)))
+			dirB := -dir
+			if hedge[i] < 0 {
+				dirB = dir
+			}
+			if qtyB > 0 {
+				active = &pairTrade{
+					entryTime: bar.Time, dirA: dir, dirB: dirB, qtyA: req.Quantity, qtyB: qtyB,
+					entryA: a, entryB: b, track: NewTrade(bar.Time, 100, 1, 1),
+				}
+			}
+		}
+
+		equity = append(equity, capital)
+	}
+
+	if active != nil {
+		// the second leg may have no close on the last bars; exit where it
+		// last had one, which an open position always follows
+		last := len(ohlc) - 1
+		for math.IsNaN(pairCloses[last]) {
+			last--
+		}
+		log := active.close(req, ohlc[last].Time, ohlc[last].Close, pairCloses[last], "EndOfBacktest")
+		trades = append(trades, log)
+	}
+
+	return trades, entrySer, equity, nil
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// BuildSymbolResult maps trades back onto the candle series so that the
+// chart can overlay entries and exits without recomputing the strategy.
+func BuildSymbolResult(sym string, ohlc []domain.Candle, trades []domain.TradeLog, signal []bool) domain.BacktestSymbolResult {
+	index := make(map[time.Time]int, len(ohlc))
+	for i, c := range ohlc {
+		index[c.Time] = i
+	}
+
+	res := domain.BacktestSymbolResult{
+		Symbol:  sym,
+		Candles: ohlc,
+		Trades:  trades,
+		Signal:  signal,
+		Entries: make([]int, 0, len(trades)),
+		Exits:   make([]int, 0, len(trades)),
+		Markers: make([]domain.TradeMarker, 0, 2*len(trades)),
+	}
+	for _, t := range trades {
+		// trades are opened and closed on candle timestamps, so lookups only
+		// miss if the caller passes a different series
+		if i, ok := index[t.EntryTime]; ok {
+			res.Entries = append(res.Entries, i)
+			res.Markers = append(res.Markers, domain.TradeMarker{
+				Index: i, Time: t.EntryTime, Price: t.EntryPrice, Kind: "entry", Direction: t.Direction,
+			})
+		}
+		if i, ok := index[t.ExitTime]; ok {
+			res.Exits = append(res.Exits, i)
+			res.Markers = append(res.Markers, domain.TradeMarker{
+				Index: i, Time: t.ExitTime, Price: t.ExitPrice, Kind: "exit", Direction: t.Direction, Reason: t.ExitReason,
+			})
+		}
+	}
+	return res
+}
@@ -6,38 +6,17 @@ import (
 )
 
 // TODO: things lacking here dynamic position sizing
+// The first start bars of ohlc are warm-up history: they feed the conditions
+// but no trade is entered on them.
 func RunBacktest(req domain.BacktestReq, sym string, ctx *engine.EvalCtx, rt *engine.Runtime,
-	entryPlan *engine.Plan, exitPlan *engine.Plan, ohlc []domain.Candle) ([]domain.TradeLog, []bool, []float64, error) {
+	entryPlan *engine.Plan, exitPlan *engine.Plan, ohlc []domain.Candle, start int) ([]domain.TradeLog, []bool, []float64, error) {
 
-	// Entry signals
-	entrySer, err := rt.ExecPlan(entryPlan)
+	entrySer, exitSeries, warm, err := execSignals(rt, entryPlan, exitPlan, len(ohlc), start)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-
-	exitSeries := make([]bool, len(ohlc))
-
-	if exitPlan != nil {
-		exitSeries, err = rt.ExecPlan(exitPlan)
-		if err != nil {
-			return nil, nil, nil, err
-		}
-	}
-
-	exitChecker := &ExitChecker{
-		StopLoss:    req.StopLoss,
-		TakeProfit:  req.TakeProfit,
-		TrailingSL:  req.TrailingSL,
-		HoldingBars: req.HoldingPeriod,
-		Intraday:    req.Intraday,
-	}
-
-	var enrtyDirecction int
-	if req.Direction == "long" {
-		enrtyDirecction = 1
-	} else {
-		enrtyDirecction = -1
-	}
+	exitChecker := newExitChecker(req)
+	enrtyDirecction := entryDirection(req)
 
 	// Trade loop
 	var trades []domain.TradeLog
@@ -71,7 +50,7 @@ func RunBacktest(req domain.BacktestReq, sym string, ctx *engine.EvalCtx, rt *en
 		}
 
 		// Entry
-		if activeTrade == nil && i < len(entrySer) && entrySer[i] {
+		if activeTrade == nil && i >= warm && i < len(entrySer) && entrySer[i] {
 			if exitChecker.AllowEntry(barTime) {
 				activeTrade = NewTrade(barTime, price, req.Quantity, enrtyDirecction)
 			}
@@ -90,3 +69,41 @@ func RunBacktest(req domain.BacktestReq, sym string, ctx *engine.EvalCtx, rt *en
 
 	return trades, entrySer, equity, nil
 }
+
+// execSignals runs the entry and optional exit plans. warm is the first bar,
+// not before start, on which every input of both plans has warmed up.
+func execSignals(rt *engine.Runtime, entryPlan, exitPlan *engine.Plan, n, start int) (entry, exit []bool, warm int, err error) {
+	entry, err = rt.ExecPlan(entryPlan)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	exit = make([]bool, n)
+	if exitPlan != nil {
+		exit, err = rt.ExecPlan(exitPlan)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	warm = entryPlan.Warmup
+	if exitPlan != nil && exitPlan.Warmup > warm {
+		warm = exitPlan.Warmup
+	}
+	return entry, exit, max(warm, start), nil
+}
+
+func newExitChecker(req domain.BacktestReq) *ExitChecker {
+	return &ExitChecker{
+		StopLoss:    req.StopLoss,
+		TakeProfit:  req.TakeProfit,
+		TrailingSL:  req.TrailingSL,
+		HoldingBars: req.HoldingPeriod,
+		Intraday:    req.Intraday,
+	}
+}
+
+func entryDirection(req domain.BacktestReq) int {
+	if req.Direction == "long" {
+		return 1
+	}
+	return -1
+}
@@ -53,3 +53,51 @@ type LogicalNode struct {
 }
 
 func (LogicalNode) predNode() {}
+
+// PatternNode is a registry predicate (e.g. a candlestick pattern) evaluated
+// on a timeframe, like an indicator that yields booleans.
+type PatternNode struct {
+	Name      string
+	Timeframe Timeframe
+	Params    map[string]float64
+	Offset    int
+}
+
+func (PatternNode) predNode() {}
+
+// TemporalNode is a predicate over the history of another predicate:
+// "held_for" (true for the last N bars) or "within" (true at least once in the last N bars).
+type TemporalNode struct {
+	Op   string // "held_for", "within"
+	Pred PredNode
+	N    int
+}
+
+func (TemporalNode) predNode() {}
+
+// PredSeriesNode turns a predicate into a numeric series:
+// "bars_since" (bars since it was last true) or "count_true" (trues in the last N bars).
+type PredSeriesNode struct {
+	Op   string // "bars_since", "count_true"
+	Pred PredNode
+	N    int // window for count_true
+}
+
+func (PredSeriesNode) exprNode() {}
+
+// SequenceNode is true on the bar where the last of its ordered steps fires,
+// each step on a later bar than the previous one and within its MaxGap.
+type SequenceNode struct {
+	Steps []SequenceStep
+	// Reset, when true on a bar, abandons any partially matched sequence.
+	Reset PredNode
+	// Restart begins a new attempt when the first step fires again mid-sequence.
+	Restart bool
+}
+
+type SequenceStep struct {
+	Pred   PredNode
+	MaxGap int // max bars after the previous step; 0 = unlimited
+}
+
+func (SequenceNode) predNode() {}
@@ -5,12 +5,12 @@ import (
 )
 
 type Candle struct {
-	Time   time.Time
-	Open   float64
-	High   float64
-	Low    float64
-	Close  float64
-	Volume float64
+	Time   time.Time `json:"time"`
+	Open   float64   `json:"open"`
+	High   float64   `json:"high"`
+	Low    float64   `json:"low"`
+	Close  float64   `json:"close"`
+	Volume float64   `json:"volume"`
 }
 
 type BacktestReq struct {
@@ -29,6 +29,47 @@ type BacktestReq struct {
 	End           *string       `json:"end,omitempty"`
 	Intraday      *IntradayRule `json:"intraday,omitempty"`
 	HoldingPeriod *int          `json:"holding_period,omitempty"`
+	// Bars replaces the time-bucketed base candles with another bar type.
+	Bars *BarSpec `json:"bars,omitempty"`
+	// Pair trades Symbol against a second symbol; see PairSpec.
+	Pair *PairSpec `json:"pair,omitempty"`
+}
+
+// PairSpec turns a backtest into a pair trade. Symbol is the first leg and
+// PairSpec.Symbol the second; conditions can use the Pair indicators. A
+// "long" entry buys the first leg and sells HedgeRatio times its quantity of
+// the second, "short" the reverse; both legs fill on the same bar.
+type PairSpec struct {
+	Symbol string `json:"symbol"`
+	// HedgeWindow is the rolling OLS window of the hedge ratio used to size
+	// the second leg at entry; default 60 bars.
+	HedgeWindow int `json:"hedge_window,omitempty"`
+}
+
+// Bar types for BarSpec.Type.
+const (
+	BarsHeikinAshi = "heikin_ashi"
+	BarsRenko      = "renko"
+	BarsRange      = "range"
+	BarsVolume     = "volume"
+	BarsTick       = "tick"
+)
+
+// BarSpec selects an alternative base bar series. Heikin-Ashi is built from
+// the base timeframe candles; the other types from 1m candles. Fills still
+// happen at the real close and time of the candle each bar completed on.
+type BarSpec struct {
+	Type string `json:"type"`
+	// Renko brick size; when zero the brick is ATR(ATRPeriod) at the time
+	// each brick forms.
+	BrickSize float64 `json:"brick_size,omitempty"`
+	ATRPeriod int     `json:"atr_period,omitempty"`
+	// Range is the high-low span that closes a range bar.
+	Range float64 `json:"range,omitempty"`
+	// Volume is the traded volume that closes a volume bar.
+	Volume float64 `json:"volume,omitempty"`
+	// Ticks is the number of 1m candles per tick bar; trade ticks are not stored.
+	Ticks int `json:"ticks,omitempty"`
 }
 
 type ExitCondition struct {
@@ -51,11 +92,30 @@ type BacktestResp struct {
 }
 
 type BacktestSymbolResult struct {
-	Symbol  string     `json:"symbol"`
-	Trades  []TradeLog `json:"trades"`
-	Signal  []bool     `json:"signal"`
-	Entries []int      `json:"entries"`
-	Exits   []int      `json:"exits"`
+	Symbol  string        `json:"symbol"`
+	Candles []Candle      `json:"candles"`
+	Trades  []TradeLog    `json:"trades"`
+	Signal  []bool        `json:"signal"`  // entry condition, one value per candle
+	Entries []int         `json:"entries"` // candle index of each trade entry
+	Exits   []int         `json:"exits"`   // candle index of each trade exit
+	Markers []TradeMarker `json:"markers"`
+	// Bars is the bar type of Candles when not time-bucketed.
+	Bars string `json:"bars,omitempty"`
+	// Pair is the second symbol of a pair backtest.
+	Pair string `json:"pair,omitempty"`
+	// WarmupBars is the number of leading candles on which the conditions were
+	// still warming up; no trade is entered before this index.
+	WarmupBars int `json:"warmup_bars"`
+}
+
+// TradeMarker is a chart overlay point for a trade entry or exit.
+type TradeMarker struct {
+	Index     int       `json:"index"`
+	Time      time.Time `json:"time"`
+	Price     float64   `json:"price"`
+	Kind      string    `json:"kind"` // "entry" or "exit"
+	Direction string    `json:"direction"`
+	Reason    string    `json:"reason,omitempty"`
 }
 
 type TradeLog struct {
@@ -68,6 +128,19 @@ type TradeLog struct {
 	Qty         int       `json:"qty"`
 	PnL         float64   `json:"pnl"`
 	HoldingBars int       `json:"holding_bars"`
+	// Legs holds both sides of a pair trade; PnL is their sum and the entry
+	// and exit prices are those of the first leg.
+	Legs []TradeLeg `json:"legs,omitempty"`
+}
+
+// TradeLeg is one side of a pair trade.
+type TradeLeg struct {
+	Symbol     string  `json:"symbol"`
+	Direction  string  `json:"direction"`
+	Qty        int     `json:"qty"`
+	EntryPrice float64 `json:"entry_price"`
+	ExitPrice  float64 `json:"exit_price"`
+	PnL        float64 `json:"pnl"`
 }
 
 type BacktestSummary struct {
@@ -19,6 +19,20 @@ const (
 	TokenNumber    TokenType = "number"
 	TokenFunction  TokenType = "function"
 	TokenLogical   TokenType = "logical" // AND/OR/NOT between clauses
+	// TokenTemporal wraps a nested condition in Args; Function names the operator
+	// (held_for, within, bars_since, count_true) and Params carries "n".
+	// For "sequence", Args are TokenGroup steps instead.
+	TokenTemporal TokenType = "temporal"
+	// TokenGroup is a parenthesized condition held in Args. Inside a sequence,
+	// Params may carry "max_gap" and Function "reset" marks the reset condition.
+	TokenGroup TokenType = "group"
+	// TokenPredicate is a registry predicate such as a candlestick pattern,
+	// used as a clause on its own. Function names it; Timeframe, Params and
+	// Offset work as for indicators.
+	TokenPredicate TokenType = "predicate"
+	// TokenParam references a param of a custom indicator inside its body;
+	// Function names the param.
+	TokenParam TokenType = "param"
 )
 
 type Operator string
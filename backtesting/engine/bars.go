@@ -0,0 +1,184 @@
+// engine/bars.go
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// BarSet is an alternative bar series together with, for every bar, the real
+// candle it completed on. Conditions run on Bars; fills use Real[i].Close at
+// Real[i].Time so trades stay at prices that actually traded.
+type BarSet struct {
+	Bars []domain.Candle
+	Real []domain.Candle
+}
+
+func (bs *BarSet) add(bar, real domain.Candle) {
+	bs.Bars = append(bs.Bars, bar)
+	bs.Real = append(bs.Real, real)
+}
+
+// BuildBars transforms src into the bar type of spec. src is base timeframe
+// candles for Heikin-Ashi and 1m candles for the other types.
+func BuildBars(spec domain.BarSpec, src []domain.Candle) (*BarSet, error) {
+	if err := ValidateBarSpec(spec); err != nil {
+		return nil, err
+	}
+	switch spec.Type {
+	case domain.BarsHeikinAshi:
+		return &BarSet{Bars: HeikinAshi(src), Real: src}, nil
+	case domain.BarsRenko:
+		return RenkoBars(src, spec.BrickSize, spec.ATRPeriod), nil
+	case domain.BarsRange:
+		return RangeBars(src, spec.Range), nil
+	case domain.BarsVolume:
+		return VolumeBars(src, spec.Volume), nil
+	default:
+		return TickBars(src, spec.Ticks), nil
+	}
+}
+
+// ValidateBarSpec checks the type and that its size param is set.
+func ValidateBarSpec(spec domain.BarSpec) error {
+	switch spec.Type {
+	case domain.BarsHeikinAshi:
+		return nil
+	case domain.BarsRenko:
+		if spec.BrickSize < 0 || (spec.BrickSize == 0 && spec.ATRPeriod <= 0) {
+			return errors.New("renko needs brick_size > 0 or atr_period > 0")
+		}
+	case domain.BarsRange:
+		if spec.Range <= 0 {
+			return errors.New("range bars need range > 0")
+		}
+	case domain.BarsVolume:
+		if spec.Volume <= 0 {
+			return errors.New("volume bars need volume > 0")
+		}
+	case domain.BarsTick:
+		if spec.Ticks <= 0 {
+			return errors.New("tick bars need ticks > 0")
+		}
+	default:
+		return fmt.Errorf("unknown bar type %q", spec.Type)
+	}
+	return nil
+}
+
+// BarSourceTF is the timeframe BuildBars expects its source candles in.
+func BarSourceTF(spec domain.BarSpec, baseTF domain.Timeframe) domain.Timeframe {
+	if spec.Type == domain.BarsHeikinAshi {
+		return baseTF
+	}
+	return "1m"
+}
+
+// BarsKey identifies a bar spec in cache keys.
+func BarsKey(spec domain.BarSpec) string {
+	return fmt.Sprintf("%s:%g:%d:%g:%g:%d", spec.Type, spec.BrickSize, spec.ATRPeriod, spec.Range, spec.Volume, spec.Ticks)
+}
+
+// HeikinAshi smooths candles bar for bar: close is the OHLC average and open
+// the midpoint of the previous Heikin-Ashi body.
+func HeikinAshi(src []domain.Candle) []domain.Candle {
+	out := make([]domain.Candle, len(src))
+	for i, c := range src {
+		ha := domain.Candle{Time: c.Time, Volume: c.Volume}
+		ha.Close = (c.Open + c.High + c.Low + c.Close) / 4
+		if i == 0 {
+			ha.Open = (c.Open + c.Close) / 2
+		} else {
+			ha.Open = (out[i-1].Open + out[i-1].Close) / 2
+		}
+		ha.High = math.Max(c.High, math.Max(ha.Open, ha.Close))
+		ha.Low = math.Min(c.Low, math.Min(ha.Open, ha.Close))
+		out[i] = ha
+	}
+	return out
+}
+
+// RenkoBars builds close-based bricks. A brick in the trend direction forms
+// when the close moves one brick past the last brick; a reversal needs the
+// close one brick past the other end. With brick <= 0 each brick is sized by
+// ATR(atrPeriod) of the source on the candle it forms, so no later data is
+// used. Several bricks can complete on one candle; they share its time.
+func RenkoBars(src []domain.Candle, brick float64, atrPeriod int) *BarSet {
+	var atr []float64
+	if brick <= 0 {
+		atr = ATR(src, atrPeriod)
+	}
+	bs := &BarSet{}
+	var top, bot, vol float64
+	started := false
+	for i, c := range src {
+		size := brick
+		if atr != nil {
+			if i < atrPeriod-1 {
+				continue
+			}
+			size = atr[i]
+		}
+		if !(size > 0) {
+			continue
+		}
+		if !started {
+			top = math.Floor(c.Close/size) * size
+			bot, started = top, true
+			continue
+		}
+		vol += c.Volume
+		for c.Close >= top+size {
+			bs.add(domain.Candle{Time: c.Time, Open: top, High: top + size, Low: top, Close: top + size, Volume: vol}, c)
+			bot, top, vol = top, top+size, 0
+		}
+		for c.Close <= bot-size {
+			bs.add(domain.Candle{Time: c.Time, Open: bot, High: bot, Low: bot - size, Close: bot - size, Volume: vol}, c)
+			top, bot, vol = bot, bot-size, 0
+		}
+	}
+	return bs
+}
+
+// aggregate folds source candles into bars, closing a bar after the candle for
+// which done reports true. The trailing partial bar is dropped.
+func aggregate(src []domain.Candle, done func(bar domain.Candle, n int) bool) *BarSet {
+	bs := &BarSet{}
+	var bar domain.Candle
+	n := 0
+	for _, c := range src {
+		if n == 0 {
+			bar = c
+		} else {
+			bar.High = math.Max(bar.High, c.High)
+			bar.Low = math.Min(bar.Low, c.Low)
+			bar.Close = c.Close
+			bar.Volume += c.Volume
+		}
+		n++
+		if done(bar, n) {
+			bs.add(bar, c)
+			n = 0
+		}
+	}
+	return bs
+}
+
+// RangeBars closes a bar once its high-low span reaches size. With 1m source
+// candles a bar can overshoot size by up to one candle's move.
+func RangeBars(src []domain.Candle, size float64) *BarSet {
+	return aggregate(src, func(bar domain.Candle, _ int) bool { return bar.High-bar.Low >= size })
+}
+
+// VolumeBars closes a bar once its traded volume reaches vol.
+func VolumeBars(src []domain.Candle, vol float64) *BarSet {
+	return aggregate(src, func(bar domain.Candle, _ int) bool { return bar.Volume >= vol })
+}
+
+// TickBars closes a bar every n source candles.
+func TickBars(src []domain.Candle, n int) *BarSet {
+	return aggregate(src, func(_ domain.Candle, k int) bool { return k >= n })
+}
@@ -0,0 +1,196 @@
+// engine/cached_provider.go
+package engine
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"unsafe"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// LatestTimer is implemented by providers that can cheaply report the
+// timestamp of the newest stored candle for a symbol. CachedProvider uses it
+// to notice new candles and invalidate what it has cached.
+type LatestTimer interface {
+	LatestTime(symbol string) (time.Time, error)
+}
+
+// CachedProvider wraps a DataProvider with two shared LRUs: one for candle
+// loads and one for computed plan node series. Both are keyed by a per-symbol
+// data version that is bumped whenever new candles are detected or the symbol
+// is invalidated explicitly.
+type CachedProvider struct {
+	inner   DataProvider
+	candles *LRU
+	series  *LRU
+
+	mu       sync.Mutex
+	versions map[string]int64
+	latest   map[string]time.Time
+}
+
+var candleSize = int64(unsafe.Sizeof(domain.Candle{}))
+
+// NewCachedProvider splits maxBytes evenly between candle and series caches.
+func NewCachedProvider(inner DataProvider, maxBytes int64) *CachedProvider {
+	return &CachedProvider{
+		inner:    inner,
+		candles:  NewLRU(maxBytes / 2),
+		series:   NewLRU(maxBytes / 2),
+		versions: map[string]int64{},
+		latest:   map[string]time.Time{},
+	}
+}
+
+func (cp *CachedProvider) version(symbol string) int64 {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.versions[symbol]
+}
+
+// refresh bumps the symbol version when the inner provider reports a newer
+// candle than the one seen last time.
+func (cp *CachedProvider) refresh(symbol string) {
+	lt, ok := cp.inner.(LatestTimer)
+	if !ok {
+		return
+	}
+	t, err := lt.LatestTime(symbol)
+	if err != nil {
+		log.Printf("cache: latest time for %s: %v", symbol, err)
+		return
+	}
+	cp.mu.Lock()
+	prev, seen := cp.latest[symbol]
+	cp.latest[symbol] = t
+	cp.mu.Unlock()
+	if seen && !t.Equal(prev) {
+		cp.Invalidate(symbol)
+	}
+}
+
+// Invalidate drops everything cached for symbol, e.g. after new candles were
+// ingested. Entries keyed on the old version become unreachable even if a
+// concurrent request re-adds them.
+func (cp *CachedProvider) Invalidate(symbol string) {
+	cp.mu.Lock()
+	cp.versions[symbol]++
+	cp.mu.Unlock()
+	prefix := symbol + "|"
+	cp.candles.RemovePrefix(prefix)
+	cp.series.RemovePrefix(prefix)
+}
+
+func (cp *CachedProvider) LoadOHLCV(symbol string, tf domain.Timeframe) ([]domain.Candle, error) {
+	cp.refresh(symbol)
+	key := fmt.Sprintf("%s|%s|v%d", symbol, tf, cp.version(symbol))
+	if v, ok := cp.candles.Get(key); ok {
+		return v.([]domain.Candle), nil
+	}
+	candles, err := cp.inner.LoadOHLCV(symbol, tf)
+	if err != nil {
+		return nil, err
+	}
+	cp.candles.Add(key, candles, int64(len(candles))*candleSize)
+	return candles, nil
+}
+
+// LoadOHLCVWithWarmup caches warm-up loads separately from plain ones. Inner
+// providers without warm-up support get a plain load and no warm-up bars.
+func (cp *CachedProvider) LoadOHLCVWithWarmup(symbol string, tf domain.Timeframe, warmup int) ([]domain.Candle, int, error) {
+	wl, ok := cp.inner.(WarmupLoader)
+	if !ok || warmup <= 0 {
+		candles, err := cp.LoadOHLCV(symbol, tf)
+		return candles, 0, err
+	}
+	cp.refresh(symbol)
+	key := fmt.Sprintf("%s|%s|v%d|w%d", symbol, tf, cp.version(symbol), warmup)
+	if v, ok := cp.candles.Get(key); ok {
+		e := v.(warmLoad)
+		return e.candles, e.pre, nil
+	}
+	candles, pre, err := wl.LoadOHLCVWithWarmup(symbol, tf, warmup)
+	if err != nil {
+		return nil, 0, err
+	}
+	cp.candles.Add(key, warmLoad{candles, pre}, int64(len(candles))*candleSize)
+	return candles, pre, nil
+}
+
+type warmLoad struct {
+	candles []domain.Candle
+	pre     int
+}
+
+func (cp *CachedProvider) AlignTo(baseTF domain.Timeframe, ser Series, fromTF domain.Timeframe) (Series, error) {
+	return cp.inner.AlignTo(baseTF, ser, fromTF)
+}
+
+// Expiries passes through to the inner provider. Expiry dates are few and
+// rarely change, so they are cached in the candle LRU under the data version.
+func (cp *CachedProvider) Expiries(symbol string) ([]time.Time, error) {
+	ep, ok := cp.inner.(ExpiryProvider)
+	if !ok {
+		return nil, errNoExpiries
+	}
+	key := fmt.Sprintf("%s|expiries|v%d", symbol, cp.version(symbol))
+	if v, ok := cp.candles.Get(key); ok {
+		return v.([]time.Time), nil
+	}
+	dates, err := ep.Expiries(symbol)
+	if err != nil {
+		return nil, err
+	}
+	cp.candles.Add(key, dates, int64(len(dates))*int64(unsafe.Sizeof(time.Time{})))
+	return dates, nil
+}
+
+// DataKey identifies a loaded candle range: symbol, timeframe, data version
+// and the first/last bar. Plan node IDs are appended to form series keys.
+func (cp *CachedProvider) DataKey(symbol string, tf domain.Timeframe, candles []domain.Candle) string {
+	key := fmt.Sprintf("%s|%s|v%d|%d", symbol, tf, cp.version(symbol), len(candles))
+	if len(candles) > 0 {
+		key += fmt.Sprintf("|%d|%d", candles[0].Time.Unix(), candles[len(candles)-1].Time.Unix())
+	}
+	return key
+}
+
+// Attach makes ctx read and write computed series through the shared cache.
+func (cp *CachedProvider) Attach(ctx *EvalCtx, candles []domain.Candle) {
+	ctx.UseShared(cp.series, cp.DataKey(ctx.Symbol, ctx.BaseTF, candles)+cp.pairKey(ctx)+regKey(ctx))
+}
+
+// pairKey separates series of a pair backtest from those of the base symbol
+// alone or paired with another symbol.
+func (cp *CachedProvider) pairKey(ctx *EvalCtx) string {
+	if ctx.PairSymbol == "" {
+		return ""
+	}
+	return fmt.Sprintf("|pair:%s:v%d", ctx.PairSymbol, cp.version(ctx.PairSymbol))
+}
+
+// regKey separates series computed with different user scripts.
+func regKey(ctx *EvalCtx) string {
+	if ctx.Reg == nil {
+		return ""
+	}
+	return ctx.Reg.Key
+}
+
+// AttachBars is Attach for an alternative bar series, keyed by its spec so
+// it never shares entries with time candles of the same span.
+func (cp *CachedProvider) AttachBars(ctx *EvalCtx, candles []domain.Candle, spec domain.BarSpec) {
+	ctx.UseShared(cp.series, cp.DataKey(ctx.Symbol, ctx.BaseTF, candles)+"|"+BarsKey(spec)+cp.pairKey(ctx)+regKey(ctx))
+}
+
+type ProviderCacheStats struct {
+	Candles CacheStats `json:"candles"`
+	Series  CacheStats `json:"series"`
+}
+
+func (cp *CachedProvider) Stats() ProviderCacheStats {
+	return ProviderCacheStats{Candles: cp.candles.Stats(), Series: cp.series.Stats()}
+}
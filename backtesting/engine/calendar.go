@@ -0,0 +1,281 @@
+// engine/calendar.go
+package engine
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// ExpiryProvider is implemented by providers that know a symbol's derivative
+// expiry dates (ticker_expiries).
+type ExpiryProvider interface {
+	// Expiries returns the expiry dates of symbol in ascending order.
+	Expiries(symbol string) ([]time.Time, error)
+}
+
+var errNoExpiries = errors.New("data provider has no expiry calendar")
+
+// barClock returns the exchange wall clock of a bar. Candle times carry the
+// exchange clock in their UTC fields (the data layer stores them without a
+// zone), matching sessionKey.
+func barClock(t time.Time) (hour, minute int) {
+	t = t.UTC()
+	return t.Hour(), t.Minute()
+}
+
+func clockMinutes(hhmm string) int {
+	t, _ := time.Parse("15:04", hhmm)
+	return t.Hour()*60 + t.Minute()
+}
+
+// minuteOfSession is minutes from SessionOpen to the bar's start.
+func minuteOfSession(t time.Time) int {
+	h, m := barClock(t)
+	return h*60 + m - clockMinutes(SessionOpen)
+}
+
+// dateKey is the calendar date of a bar or expiry as yyyymmdd.
+func dateKey(t time.Time) int {
+	t = t.UTC()
+	return t.Year()*10000 + int(t.Month())*100 + t.Day()
+}
+
+// tradingDaysBetween counts weekdays after from up to and including to.
+// Exchange holidays are not known here and count as trading days.
+func tradingDaysBetween(from, to time.Time) int {
+	from = time.Date(from.UTC().Year(), from.UTC().Month(), from.UTC().Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.UTC().Year(), to.UTC().Month(), to.UTC().Day(), 0, 0, 0, 0, time.UTC)
+	n := 0
+	for d := from.AddDate(0, 0, 1); !d.After(to); d = d.AddDate(0, 0, 1) {
+		if wd := d.Weekday(); wd != time.Saturday && wd != time.Sunday {
+			n++
+		}
+	}
+	return n
+}
+
+// nextExpiries gives, per bar, the first expiry on or after the bar's date,
+// or the zero time when there is none.
+func nextExpiries(bars []domain.Candle, expiries []time.Time) []time.Time {
+	out := make([]time.Time, len(bars))
+	for i, b := range bars {
+		out[i] = nextExpiry(expiries, b.Time)
+	}
+	return out
+}
+
+func nextExpiry(expiries []time.Time, t time.Time) time.Time {
+	day := dateKey(t)
+	k := sort.Search(len(expiries), func(k int) bool { return dateKey(expiries[k]) >= day })
+	if k < len(expiries) {
+		return expiries[k]
+	}
+	return time.Time{}
+}
+
+func ctxExpiries(ctx *EvalCtx, bars []domain.Candle) ([]time.Time, error) {
+	ep, ok := ctx.Data.(ExpiryProvider)
+	if !ok {
+		return nil, errNoExpiries
+	}
+	exp, err := ep.Expiries(ctx.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	return nextExpiries(bars, exp), nil
+}
+
+// expiryCalendar is nextExpiry for streams. Once bars pass the last expiry
+// it knows it asks the provider again, at most once a day.
+type expiryCalendar struct {
+	ep       ExpiryProvider
+	symbol   string
+	expiries []time.Time
+	loaded   int // dateKey of the last load
+}
+
+// newExpiryCalendar is nil when ctx has no expiries to give, leaving the
+// stream to fail as Eval does.
+func newExpiryCalendar(ctx *EvalCtx) *expiryCalendar {
+	ep, ok := ctx.Data.(ExpiryProvider)
+	if !ok {
+		return nil
+	}
+	exp, err := ep.Expiries(ctx.Symbol)
+	if err != nil {
+		return nil
+	}
+	return &expiryCalendar{ep: ep, symbol: ctx.Symbol, expiries: exp}
+}
+
+func (c *expiryCalendar) next(t time.Time) time.Time {
+	next := nextExpiry(c.expiries, t)
+	if day := dateKey(t); next.IsZero() && c.loaded != day {
+		c.loaded = day
+		if exp, err := c.ep.Expiries(c.symbol); err == nil {
+			c.expiries = exp
+			next = nextExpiry(exp, t)
+		}
+	}
+	return next
+}
+
+// shiftBool delays a bool series by offset bars, false at the head.
+func shiftBool(bs BoolSeries, offset int) (BoolSeries, error) {
+	if offset == 0 {
+		return bs, nil
+	}
+	if offset < 0 || offset >= len(bs) {
+		return nil, errBadOffset
+	}
+	out := make(BoolSeries, len(bs))
+	copy(out[offset:], bs)
+	return out, nil
+}
+
+func registerCalendar(reg *Registry) {
+	perBar := func(desc string, f func(b domain.Candle) float64) IndicatorSpec {
+		return IndicatorSpec{
+			Category:    "Time",
+			Description: desc,
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe,
+				_ map[string]float64, offset int, args ...Series) ([]float64, error) {
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				out := make(Series, len(bars))
+				for i, b := range bars {
+					out[i] = f(b)
+				}
+				return shiftBack(out, offset)
+			},
+			Stream: func(*EvalCtx, map[string]float64) IndicatorStep {
+				return func(bar domain.Candle, _ []float64) float64 { return f(bar) }
+			},
+		}
+	}
+	reg.Indicators["MinuteOfSession"] = perBar("Minutes since the session open (0 on the first bar)",
+		func(b domain.Candle) float64 { return float64(minuteOfSession(b.Time)) })
+	reg.Indicators["TimeOfDay"] = perBar("Bar start time as HHMM, e.g. TimeOfDay >= 09:30",
+		func(b domain.Candle) float64 {
+			h, m := barClock(b.Time)
+			return float64(h*100 + m)
+		})
+	reg.Indicators["DayOfWeek"] = perBar("Day of week, 1 = Monday .. 7 = Sunday",
+		func(b domain.Candle) float64 {
+			wd := int(b.Time.UTC().Weekday())
+			if wd == 0 {
+				wd = 7
+			}
+			return float64(wd)
+		})
+	reg.Indicators["DayOfMonth"] = perBar("Day of month, 1..31",
+		func(b domain.Candle) float64 { return float64(b.Time.UTC().Day()) })
+
+	daysTo := func(b domain.Candle, next time.Time) float64 {
+		if next.IsZero() {
+			return math.NaN()
+		}
+		return float64(tradingDaysBetween(b.Time, next))
+	}
+	reg.Indicators["DaysToExpiry"] = IndicatorSpec{
+		Category:    "Time",
+		Description: "Trading days to the next expiry, 0 on expiry day (weekends skipped, holidays not)",
+		Eval: func(ctx *EvalCtx, tf domain.Timeframe,
+			_ map[string]float64, offset int, args ...Series) ([]float64, error) {
+			bars, err := barsOn(ctx, tf)
+			if err != nil {
+				return nil, err
+			}
+			next, err := ctxExpiries(ctx, bars)
+			if err != nil {
+				return nil, err
+			}
+			out := make(Series, len(bars))
+			for i, b := range bars {
+				out[i] = daysTo(b, next[i])
+			}
+			return shiftBack(out, offset)
+		},
+		Stream: func(ctx *EvalCtx, _ map[string]float64) IndicatorStep {
+			cal := newExpiryCalendar(ctx)
+			if cal == nil {
+				return nil
+			}
+			return func(bar domain.Candle, _ []float64) float64 { return daysTo(bar, cal.next(bar.Time)) }
+		},
+	}
+
+	clock := func(desc string, f func(minute, n int) bool) PredicateSpec {
+		return PredicateSpec{
+			Category:    "Calendar",
+			Description: desc,
+			Params:      []ArgSpec{{Name: "n", Type: "int"}}, // minutes, default 15
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe, params map[string]float64, offset int) (BoolSeries, error) {
+				n := int(floatParam(params, "n", 15))
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				out := make(BoolSeries, len(bars))
+				for i, b := range bars {
+					out[i] = f(minuteOfSession(b.Time), n)
+				}
+				return shiftBool(out, offset)
+			},
+			Stream: func(_ *EvalCtx, params map[string]float64) PredicateStep {
+				n := int(floatParam(params, "n", 15))
+				return func(bar domain.Candle) bool { return f(minuteOfSession(bar.Time), n) }
+			},
+		}
+	}
+	reg.Predicates["FirstMinutes"] = clock("Bar starts within the first n minutes of the session",
+		func(m, n int) bool { return m >= 0 && m < n })
+	reg.Predicates["LastMinutes"] = clock("Bar starts within the last n minutes of the session",
+		func(m, n int) bool { return m >= SessionMinutes-n && m < SessionMinutes })
+
+	expiry := func(desc string, f func(b domain.Candle, next time.Time) bool) PredicateSpec {
+		return PredicateSpec{
+			Category:    "Calendar",
+			Description: desc,
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe, params map[string]float64, offset int) (BoolSeries, error) {
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				next, err := ctxExpiries(ctx, bars)
+				if err != nil {
+					return nil, err
+				}
+				out := make(BoolSeries, len(bars))
+				for i, b := range bars {
+					out[i] = !next[i].IsZero() && f(b, next[i])
+				}
+				return shiftBool(out, offset)
+			},
+			Stream: func(ctx *EvalCtx, _ map[string]float64) PredicateStep {
+				cal := newExpiryCalendar(ctx)
+				if cal == nil {
+					return nil
+				}
+				return func(bar domain.Candle) bool {
+					next := cal.next(bar.Time)
+					return !next.IsZero() && f(bar, next)
+				}
+			},
+		}
+	}
+	reg.Predicates["ExpiryDay"] = expiry("Bar is on an expiry date",
+		func(b domain.Candle, next time.Time) bool { return dateKey(next) == dateKey(b.Time) })
+	reg.Predicates["ExpiryWeek"] = expiry("Bar is in the calendar week of the next expiry",
+		func(b domain.Candle, next time.Time) bool {
+			by, bw := b.Time.UTC().ISOWeek()
+			ey, ew := next.UTC().ISOWeek()
+			return by == ey && bw == ew
+		})
+}
@@ -0,0 +1,207 @@
+// engine/custom.go
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// CustomIndicator is a user-saved expression that conditions call like an
+// indicator, e.g. Trend(fast=20, slow=50) for
+//
+//	(EMA(Close, $fast) - EMA(Close, $slow)) / ATR(14)
+//
+// Body references a param with a TokenParam token or, as an indicator or
+// function param value, with the string "$name". Body indicators and
+// predicates without a timeframe take the call's timeframe, and the call's
+// offset shifts the whole expression. The Parser expands calls in place, so
+// the Planner shares and caches their nodes like any others.
+type CustomIndicator struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Params      []CustomParam  `json:"params,omitempty"`
+	Body        []domain.Token `json:"body"`
+}
+
+type CustomParam struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"` // "int" or "float"
+	Default *float64 `json:"default,omitempty"`
+}
+
+// maxCustomTokens caps the tokens one condition may expand to through custom
+// indicators; a chain in which each indicator calls the previous one twice
+// would otherwise double in size at every step.
+const maxCustomTokens = 10000
+
+// ArgSpecs returns the call params; those without a default are required.
+func (ci CustomIndicator) ArgSpecs() []ArgSpec { return paramSpecs(ci.Params) }
+
+func paramSpecs(ps []CustomParam) []ArgSpec {
+	out := make([]ArgSpec, len(ps))
+	for i, cp := range ps {
+		out[i] = ArgSpec{Name: cp.Name, Type: cp.Type, Req: cp.Default == nil}
+	}
+	return out
+}
+
+// paramValues fills the call's params with defaults.
+func paramValues(ps []CustomParam, params map[string]float64) map[string]float64 {
+	out := maps.Clone(params)
+	if out == nil {
+		out = map[string]float64{}
+	}
+	for _, cp := range ps {
+		if _, ok := out[cp.Name]; !ok && cp.Default != nil {
+			out[cp.Name] = *cp.Default
+		}
+	}
+	return out
+}
+
+func checkParamTypes(ps []CustomParam) error {
+	for _, cp := range ps {
+		if cp.Type != "int" && cp.Type != "float" {
+			return fmt.Errorf("param %s: type must be int or float", cp.Name)
+		}
+	}
+	return nil
+}
+
+// substitute copies ts with param references replaced by values, empty
+// timeframes set to tf and indicator offsets increased by offset.
+func substitute(ts []domain.Token, values map[string]float64, tf domain.Timeframe, offset int) ([]domain.Token, error) {
+	out := make([]domain.Token, len(ts))
+	for i, t := range ts {
+		if t.Type == domain.TokenParam {
+			v, ok := values[t.Function]
+			if !ok {
+				return nil, diag(t, DiagUnknownParam, fmt.Sprintf("unknown param $%s", t.Function))
+			}
+			out[i] = domain.Token{ID: t.ID, Type: domain.TokenNumber, Value: v}
+			continue
+		}
+		if ps, ok := t.Params.(map[string]any); ok {
+			cp := make(map[string]any, len(ps))
+			for k, v := range ps {
+				if ref, isRef := v.(string); isRef && strings.HasPrefix(ref, "$") {
+					pv, ok := values[ref[1:]]
+					if !ok {
+						return nil, diag(t, DiagUnknownParam, fmt.Sprintf("unknown param %s", ref))
+					}
+					v = pv
+				}
+				cp[k] = v
+			}
+			t.Params = cp
+		}
+		if t.Type == domain.TokenIndicator || t.Type == domain.TokenPredicate {
+			if t.Timeframe == "" {
+				t.Timeframe = tf
+			}
+			t.Offset += offset
+		}
+		if len(t.Args) > 0 {
+			args, err := substitute(t.Args, values, tf, offset)
+			if err != nil {
+				return nil, err
+			}
+			t.Args = args
+		}
+		out[i] = t
+	}
+	return out, nil
+}
+
+// expandCustom parses the body of a custom indicator call. Problems inside
+// the body are reported against the call token.
+func (p *Parser) expandCustom(t domain.Token, ci CustomIndicator) (domain.ExprNode, *Diagnostic) {
+	fail := func(code, msg string) (domain.ExprNode, *Diagnostic) {
+		return nil, diag(t, code, fmt.Sprintf("custom indicator %s: %s", ci.Name, msg))
+	}
+	if i := slices.Index(p.expanding, ci.Name); i >= 0 {
+		return fail(DiagCustomIndicator, "refers to itself via "+strings.Join(append(p.expanding[i:], ci.Name), " -> "))
+	}
+	if len(t.Args) > 0 {
+		return fail(DiagSyntax, "takes no series arguments")
+	}
+	params, err := coerceNumMap(t.Params)
+	if err != nil {
+		return fail(DiagInvalidParam, err.Error())
+	}
+	cp := *p
+	cp.diags = nil
+	cp.expanding = append(slices.Clone(p.expanding), ci.Name)
+	if cp.expanded == nil {
+		// the outermost call starts the count; p may be shared, so it is left alone
+		cp.expanded = new(int)
+	}
+	if err := cp.checkParams(t, "indicator "+ci.Name, ci.ArgSpecs(), slices.Collect(maps.Keys(params))); err != nil {
+		return nil, err.(*Diagnostic)
+	}
+	body, err := substitute(ci.Body, paramValues(ci.Params, params), t.Timeframe, t.Offset)
+	if err != nil {
+		return fail(DiagCustomIndicator, err.Error())
+	}
+	if *cp.expanded += countTokens(body); *cp.expanded > maxCustomTokens {
+		return fail(DiagCustomIndicator, fmt.Sprintf("expansion exceeds %d tokens", maxCustomTokens))
+	}
+	node, err := cp.parseExprTokens(body)
+	if err != nil {
+		var d *Diagnostic
+		if errors.As(err, &d) && d.Code == DiagCustomIndicator {
+			// already names the inner indicator; keep the outer token
+			return nil, diag(t, d.Code, d.Message)
+		}
+		return fail(DiagCustomIndicator, err.Error())
+	}
+	return node, nil
+}
+
+// countTokens counts ts and the tokens nested in their arguments.
+func countTokens(ts []domain.Token) int {
+	n := len(ts)
+	for _, t := range ts {
+		n += countTokens(t.Args)
+	}
+	return n
+}
+
+// CheckCustom validates ci before it is saved: its name must not shadow a
+// registry entry (built-in or script), and its body must parse, with defaults or 1 for the
+// params, against the registry and p.Custom without referring back to ci.
+func (p *Parser) CheckCustom(ci CustomIndicator) error {
+	if ci.Name == "" || strings.HasPrefix(ci.Name, "$") {
+		return fmt.Errorf("invalid custom indicator name %q", ci.Name)
+	}
+	if err := p.Reg.taken(ci.Name); err != nil {
+		return err
+	}
+	if err := checkParamTypes(ci.Params); err != nil {
+		return err
+	}
+	call := domain.Token{ID: "custom", Type: domain.TokenIndicator, Indicator: ci.Name, Timeframe: "5m"}
+	params := map[string]any{}
+	for _, cp := range ci.Params {
+		if cp.Default == nil {
+			params[cp.Name] = 1.0
+		}
+	}
+	call.Params = params
+	cp := *p
+	cp.diags = nil
+	cp.Custom = maps.Clone(p.Custom)
+	if cp.Custom == nil {
+		cp.Custom = map[string]CustomIndicator{}
+	}
+	cp.Custom[ci.Name] = ci
+	if _, d := cp.expandCustom(call, ci); d != nil {
+		return d
+	}
+	return nil
+}
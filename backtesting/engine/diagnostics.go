@@ -0,0 +1,256 @@
+// engine/diagnostics.go
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// Diagnostic codes.
+const (
+	DiagSyntax           = "syntax"
+	DiagEmpty            = "empty"
+	DiagUnknownIndicator = "unknown_indicator"
+	DiagUnknownFunction  = "unknown_function"
+	DiagUnknownPredicate = "unknown_predicate"
+	DiagUnknownOperator  = "unknown_operator"
+	DiagUnknownParam     = "unknown_param"
+	DiagMissingParam     = "missing_param"
+	DiagInvalidParam     = "invalid_param"
+	DiagInvalidTimeframe = "invalid_timeframe"
+	DiagLookahead        = "lookahead"
+	DiagCustomIndicator  = "custom_indicator"
+)
+
+// Diagnostic is one validation problem tied to the token that caused it.
+// Suggestion is a machine-usable replacement (closest name, missing param);
+// Fix says in words what to do with it.
+type Diagnostic struct {
+	TokenID    string `json:"token_id,omitempty"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Fix        string `json:"fix,omitempty"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+func (d *Diagnostic) Error() string { return d.Message }
+
+// Diagnostics is every problem found in a condition, in token order.
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	msgs := make([]string, len(ds))
+	for i, d := range ds {
+		msgs[i] = d.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func diag(t domain.Token, code, msg string) *Diagnostic {
+	return &Diagnostic{TokenID: t.ID, Code: code, Message: msg}
+}
+
+// Validate parses the tokens and returns every problem found instead of
+// stopping at the first one. An empty result means the condition is valid.
+func (p *Parser) Validate(ts []domain.Token) Diagnostics {
+	ds := Diagnostics{}
+	cp := *p
+	cp.diags = &ds
+	if _, err := cp.ParsePredicate(ts); err != nil {
+		cp.record(err, "")
+	}
+	return ds
+}
+
+// fail reports d. When collecting diagnostics it is recorded and nil is
+// returned so the caller can carry on; otherwise d aborts the parse.
+func (p *Parser) fail(d *Diagnostic) error {
+	if p.diags == nil {
+		return d
+	}
+	*p.diags = append(*p.diags, d)
+	return nil
+}
+
+// record keeps an error that ended parsing of one clause or argument when
+// collecting diagnostics, and passes it through otherwise. Plain errors are
+// attributed to tokID.
+func (p *Parser) record(err error, tokID string) error {
+	if p.diags == nil {
+		return err
+	}
+	var d *Diagnostic
+	if !errors.As(err, &d) {
+		d = &Diagnostic{TokenID: tokID, Code: DiagSyntax, Message: err.Error()}
+	}
+	for _, seen := range *p.diags {
+		if seen == d {
+			return nil
+		}
+	}
+	*p.diags = append(*p.diags, d)
+	return nil
+}
+
+// checkParams reports unknown and missing params of t against spec.
+func (p *Parser) checkParams(t domain.Token, owner string, spec []ArgSpec, keys []string) error {
+	names := make([]string, len(spec))
+	for i, a := range spec {
+		names[i] = a.Name
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if contains(names, k) {
+			continue
+		}
+		d := diag(t, DiagUnknownParam, fmt.Sprintf("%s: unknown param %q", owner, k))
+		if s := closest(k, names); s != "" {
+			d.Suggestion = s
+			d.Fix = fmt.Sprintf("did you mean %q?", s)
+		} else if len(names) == 0 {
+			d.Fix = fmt.Sprintf("%s takes no params", owner)
+		}
+		if err := p.fail(d); err != nil {
+			return err
+		}
+	}
+	for _, a := range spec {
+		if !a.Req || contains(keys, a.Name) {
+			continue
+		}
+		d := diag(t, DiagMissingParam, fmt.Sprintf("%s: missing param %q", owner, a.Name))
+		d.Suggestion = a.Name
+		d.Fix = fmt.Sprintf("add %s (%s)", a.Name, a.Type)
+		if err := p.fail(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// futureRef reports a future-referencing spec when the parser forbids them.
+func (p *Parser) futureRef(t domain.Token, name string, isFuture bool) error {
+	if !p.NoFutureRef || !isFuture {
+		return nil
+	}
+	d := diag(t, DiagLookahead, fmt.Sprintf("%s reads future bars and cannot be used in a trading condition", name))
+	d.Fix = "remove it or use it only for charting"
+	return p.fail(d)
+}
+
+// unknownName builds the diagnostic for a name missing from the registry,
+// pointing at the other kind when the name exists there.
+func (p *Parser) unknownName(t domain.Token, kind, name string) *Diagnostic {
+	code := DiagUnknownIndicator
+	switch kind {
+	case "function":
+		code = DiagUnknownFunction
+	case "predicate":
+		code = DiagUnknownPredicate
+	}
+	d := diag(t, code, fmt.Sprintf("unknown %s %q", kind, name))
+	_, isInd := p.Reg.Indicators[name]
+	_, isFn := p.Reg.Functions[name]
+	_, isPred := p.Reg.Predicates[name]
+	switch {
+	case kind != "function" && isFn:
+		d.Fix = fmt.Sprintf("%s is a function, use a function token", name)
+	case kind != "indicator" && isInd:
+		d.Fix = fmt.Sprintf("%s is an indicator, use an indicator token", name)
+	case kind != "predicate" && isPred:
+		d.Fix = fmt.Sprintf("%s is a predicate, use it as a condition on its own", name)
+	default:
+		names := make([]string, 0, len(p.Reg.Indicators)+len(p.Reg.Functions)+len(p.Reg.Predicates))
+		for n := range p.Reg.Indicators {
+			names = append(names, n)
+		}
+		for n := range p.Reg.Functions {
+			names = append(names, n)
+		}
+		for n := range p.Reg.Predicates {
+			names = append(names, n)
+		}
+		for n := range p.Custom {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if s := closest(name, names); s != "" {
+			d.Suggestion = s
+			d.Fix = fmt.Sprintf("did you mean %q?", s)
+		}
+	}
+	return d
+}
+
+func invalidTimeframe(t domain.Token) *Diagnostic {
+	tfs := make([]string, 0, len(domain.AllowedTF))
+	for tf := range domain.AllowedTF {
+		tfs = append(tfs, string(tf))
+	}
+	sort.Slice(tfs, func(i, j int) bool {
+		return domain.TimeframeToMinutes[domain.Timeframe(tfs[i])] < domain.TimeframeToMinutes[domain.Timeframe(tfs[j])]
+	})
+	d := diag(t, DiagInvalidTimeframe, fmt.Sprintf("invalid timeframe %q", t.Timeframe))
+	if s := closest(string(t.Timeframe), tfs); s != "" {
+		d.Suggestion = s
+	}
+	d.Fix = "use one of " + strings.Join(tfs, ", ")
+	return d
+}
+
+func contains(xs []string, x string) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+// closest returns the candidate with the smallest case-insensitive edit
+// distance to name, or "" when nothing is near enough to be a likely typo.
+func closest(name string, candidates []string) string {
+	if name == "" {
+		return ""
+	}
+	best, bestD := "", -1
+	ln := strings.ToLower(name)
+	for _, c := range candidates {
+		lc := strings.ToLower(c)
+		d := editDistance(ln, lc)
+		if strings.HasPrefix(lc, ln) || strings.HasPrefix(ln, lc) {
+			d = minInt(d, 1)
+		}
+		if bestD < 0 || d < bestD {
+			best, bestD = c, d
+		}
+	}
+	if bestD < 0 || bestD > maxInt(1, len(name)/3) {
+		return ""
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,752 @@
+// engine/dsl.go
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// The text DSL is a readable spelling of the token language, e.g.
+//
+//	EMA(Close[5m], 20) crosses_above EMA(Close[5m], 50) AND RSI(Close, period=14) < 30
+//
+// Grammar (AND/OR share one precedence and associate left, like token lists):
+//
+//	pred     = clause { ("AND" | "OR") clause }
+//	clause   = { "NOT" } ( "(" pred ")" | held_for(...) | within(...) | sequence(...) | expr cmp expr )
+//	expr     = operand { mathop operand }
+//	operand  = number | time | $param | "(" expr ")" | call ; time is HH:MM and reads as the number HHMM
+//	call     = Name [ "[" tf "]" ] [ "(" args ")" ] [ "[" -offset "]" ]
+//	args     = arg { "," arg } ; positional numbers fill params in spec order, name=value sets params
+//
+// Indicators without a timeframe use the parser's default timeframe. $param
+// refers to a param of a custom indicator and is only valid in its body,
+// either as a value or in place of a number param.
+
+// Span is the byte range [Start, End) of source text a token came from.
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// DSLError is a syntax error at a position in the source text.
+type DSLError struct {
+	Pos     int    `json:"pos"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Message string `json:"message"`
+}
+
+func (e *DSLError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Message)
+}
+
+type lexKind int
+
+const (
+	lexEOF lexKind = iota
+	lexIdent
+	lexNumber
+	lexOp      // math or comparison operator
+	lexPunct   // ( ) , = { }
+	lexBracket // raw [...] contents
+	lexParam   // $name, text is the name
+)
+
+type lexeme struct {
+	kind lexKind
+	text string
+	pos  int
+	end  int
+}
+
+func lex(src string) ([]lexeme, *DSLError) {
+	var out []lexeme
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '$':
+			j := i + 1
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			if j == i+1 {
+				return nil, posError(src, i, "expected a param name after '$'")
+			}
+			out = append(out, lexeme{lexParam, src[i+1 : j], i, j})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			out = append(out, lexeme{lexIdent, src[i:j], i, j})
+			i = j
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			if hhmm, end, ok := clockLiteral(src, i, j); ok {
+				out = append(out, lexeme{lexNumber, hhmm, i, end})
+				i = end
+				continue
+			}
+			out = append(out, lexeme{lexNumber, src[i:j], i, j})
+			i = j
+		case c == '[':
+			j := strings.IndexByte(src[i:], ']')
+			if j < 0 {
+				return nil, posError(src, i, "unclosed '['")
+			}
+			out = append(out, lexeme{lexBracket, strings.TrimSpace(src[i+1 : i+j]), i, i + j + 1})
+			i += j + 1
+		case strings.ContainsRune("(),={}", c) && !strings.HasPrefix(src[i:], "=="):
+			out = append(out, lexeme{lexPunct, string(c), i, i + 1})
+			i++
+		default:
+			// two-char operators first
+			if i+1 < len(src) {
+				two := src[i : i+2]
+				if two == ">=" || two == "<=" || two == "==" || two == "!=" {
+					out = append(out, lexeme{lexOp, two, i, i + 2})
+					i += 2
+					continue
+				}
+			}
+			if strings.ContainsRune("+-*/%^<>", c) {
+				out = append(out, lexeme{lexOp, string(c), i, i + 1})
+				i++
+				continue
+			}
+			return nil, posError(src, i, fmt.Sprintf("unexpected character %q", c))
+		}
+	}
+	out = append(out, lexeme{kind: lexEOF, pos: len(src), end: len(src)})
+	return out, nil
+}
+
+// clockLiteral reads a time of day like 09:30 starting at i, where src[i:j]
+// are the hour digits, and returns it as the number HHMM (930) for
+// comparisons with TimeOfDay.
+func clockLiteral(src string, i, j int) (string, int, bool) {
+	if j-i < 1 || j-i > 2 || j+3 > len(src) || src[j] != ':' {
+		return "", 0, false
+	}
+	mm := src[j+1 : j+3]
+	if !unicode.IsDigit(rune(mm[0])) || !unicode.IsDigit(rune(mm[1])) ||
+		(j+3 < len(src) && unicode.IsDigit(rune(src[j+3]))) {
+		return "", 0, false
+	}
+	h, err := strconv.Atoi(src[i:j])
+	m, _ := strconv.Atoi(mm)
+	if err != nil || h > 23 || m > 59 {
+		return "", 0, false
+	}
+	return strconv.Itoa(h*100 + m), j + 3, true
+}
+
+func posError(src string, pos int, msg string) *DSLError {
+	line, col := 1, 1
+	for _, r := range src[:pos] {
+		if r == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return &DSLError{Pos: pos, Line: line, Col: col, Message: msg}
+}
+
+// DSLParser turns condition text into tokens for Parser.
+type DSLParser struct {
+	Reg       *Registry
+	DefaultTF domain.Timeframe
+	// Custom holds the caller's saved indicators, callable by name.
+	Custom map[string]CustomIndicator
+}
+
+type dslState struct {
+	p     *DSLParser
+	src   string
+	lx    []lexeme
+	i     int
+	spans map[string]Span
+	next  int
+}
+
+// Parse converts text to a token list. Spans maps each token ID (assigned
+// here as t1, t2, ...) to its source range so diagnostics can be located.
+func (p *DSLParser) Parse(src string) ([]domain.Token, map[string]Span, error) {
+	lx, lerr := lex(src)
+	if lerr != nil {
+		return nil, nil, lerr
+	}
+	st := &dslState{p: p, src: src, lx: lx, spans: map[string]Span{}}
+	toks, err := st.pred()
+	if err != nil {
+		return nil, nil, err
+	}
+	if st.peek().kind != lexEOF {
+		return nil, nil, st.errf("unexpected %q", st.peek().text)
+	}
+	return toks, st.spans, nil
+}
+
+// ParseExpr converts the text of a value expression, such as the body of a
+// custom indicator, to tokens.
+func (p *DSLParser) ParseExpr(src string) ([]domain.Token, map[string]Span, error) {
+	lx, lerr := lex(src)
+	if lerr != nil {
+		return nil, nil, lerr
+	}
+	st := &dslState{p: p, src: src, lx: lx, spans: map[string]Span{}}
+	toks, err := st.expr()
+	if err != nil {
+		return nil, nil, err
+	}
+	if st.peek().kind != lexEOF {
+		return nil, nil, st.errf("unexpected %q", st.peek().text)
+	}
+	return toks, st.spans, nil
+}
+
+func (st *dslState) peek() lexeme { return st.lx[st.i] }
+func (st *dslState) peekAt(k int) lexeme {
+	if st.i+k < len(st.lx) {
+		return st.lx[st.i+k]
+	}
+	return st.lx[len(st.lx)-1]
+}
+func (st *dslState) advance() lexeme { l := st.lx[st.i]; st.i++; return l }
+
+func (st *dslState) errf(format string, args ...any) *DSLError {
+	return posError(st.src, st.peek().pos, fmt.Sprintf(format, args...))
+}
+
+func (st *dslState) is(kind lexKind, text string) bool {
+	l := st.peek()
+	return l.kind == kind && l.text == text
+}
+
+func (st *dslState) expect(kind lexKind, text string) (lexeme, error) {
+	if !st.is(kind, text) {
+		got := st.peek().text
+		if st.peek().kind == lexEOF {
+			got = "end of input"
+		}
+		return lexeme{}, st.errf("expected %q, got %q", text, got)
+	}
+	return st.advance(), nil
+}
+
+// tok assigns an ID and span to a token.
+func (st *dslState) tok(t domain.Token, start, end int) domain.Token {
+	st.next++
+	t.ID = "t" + strconv.Itoa(st.next)
+	st.spans[t.ID] = Span{Start: start, End: end}
+	return t
+}
+
+func (st *dslState) keyword() string {
+	if st.peek().kind != lexIdent {
+		return ""
+	}
+	switch up := strings.ToUpper(st.peek().text); up {
+	case "AND", "OR", "NOT":
+		return up
+	}
+	return ""
+}
+
+func (st *dslState) pred() ([]domain.Token, error) {
+	out, err := st.clause()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		kw := st.keyword()
+		if kw != "AND" && kw != "OR" {
+			return out, nil
+		}
+		l := st.advance()
+		out = append(out, st.tok(domain.Token{Type: domain.TokenLogical, Operator: kw}, l.pos, l.end))
+		rhs, err := st.clause()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rhs...)
+	}
+}
+
+func (st *dslState) clause() ([]domain.Token, error) {
+	var out []domain.Token
+	for st.keyword() == "NOT" {
+		l := st.advance()
+		out = append(out, st.tok(domain.Token{Type: domain.TokenLogical, Operator: "NOT"}, l.pos, l.end))
+	}
+
+	if st.is(lexPunct, "(") && st.isPredGroup() {
+		open := st.advance()
+		inner, err := st.pred()
+		if err != nil {
+			return nil, err
+		}
+		cl, err := st.expect(lexPunct, ")")
+		if err != nil {
+			return nil, err
+		}
+		return append(out, st.tok(domain.Token{Type: domain.TokenGroup, Args: inner}, open.pos, cl.end)), nil
+	}
+
+	if l := st.peek(); l.kind == lexIdent {
+		if op, ok := temporalOps[l.text]; ok && op.isPred {
+			t, err := st.temporal()
+			if err != nil {
+				return nil, err
+			}
+			return append(out, t), nil
+		}
+		if _, ok := st.p.Reg.Predicates[l.text]; ok {
+			t, err := st.call()
+			if err != nil {
+				return nil, err
+			}
+			return append(out, t), nil
+		}
+	}
+
+	lhs, err := st.expr()
+	if err != nil {
+		return nil, err
+	}
+	l := st.peek()
+	isCmp := (l.kind == lexOp || l.kind == lexIdent) && isCompare(l.text)
+	if !isCmp {
+		return nil, st.errf("expected comparison operator")
+	}
+	st.advance()
+	cmp := st.tok(domain.Token{Type: domain.TokenOperator, Operator: l.text}, l.pos, l.end)
+	rhs, err := st.expr()
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, lhs...)
+	out = append(out, cmp)
+	return append(out, rhs...), nil
+}
+
+// isPredGroup looks past the "(" at the cursor to its matching ")": the group
+// is a predicate unless an operator follows, as in "(Close - Open) > 5".
+func (st *dslState) isPredGroup() bool {
+	depth := 0
+	for k := st.i; k < len(st.lx); k++ {
+		l := st.lx[k]
+		if l.kind == lexPunct && l.text == "(" {
+			depth++
+		}
+		if l.kind == lexPunct && l.text == ")" {
+			depth--
+			if depth == 0 {
+				nx := st.lx[k+1] // the lexeme list always ends in EOF
+				return !(nx.kind == lexOp || (nx.kind == lexIdent && isCompare(nx.text)))
+			}
+		}
+	}
+	return false
+}
+
+func (st *dslState) expr() ([]domain.Token, error) {
+	out, err := st.operand()
+	if err != nil {
+		return nil, err
+	}
+	for st.peek().kind == lexOp && isMath(st.peek().text) {
+		l := st.advance()
+		out = append(out, st.tok(domain.Token{Type: domain.TokenOperator, Operator: l.text}, l.pos, l.end))
+		rhs, err := st.operand()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rhs...)
+	}
+	return out, nil
+}
+
+func (st *dslState) operand() ([]domain.Token, error) {
+	l := st.peek()
+	switch {
+	case l.kind == lexOp && l.text == "-" && st.peekAt(1).kind == lexNumber:
+		st.advance()
+		n := st.advance()
+		v, err := strconv.ParseFloat(n.text, 64)
+		if err != nil {
+			return nil, posError(st.src, n.pos, "invalid number "+n.text)
+		}
+		return []domain.Token{st.tok(domain.Token{Type: domain.TokenNumber, Value: -v}, l.pos, n.end)}, nil
+
+	case l.kind == lexNumber:
+		st.advance()
+		v, err := strconv.ParseFloat(l.text, 64)
+		if err != nil {
+			return nil, posError(st.src, l.pos, "invalid number "+l.text)
+		}
+		return []domain.Token{st.tok(domain.Token{Type: domain.TokenNumber, Value: v}, l.pos, l.end)}, nil
+
+	case l.kind == lexParam:
+		st.advance()
+		return []domain.Token{st.tok(domain.Token{Type: domain.TokenParam, Function: l.text}, l.pos, l.end)}, nil
+
+	case l.kind == lexPunct && l.text == "(":
+		st.advance()
+		inner, err := st.expr()
+		if err != nil {
+			return nil, err
+		}
+		cl, err := st.expect(lexPunct, ")")
+		if err != nil {
+			return nil, err
+		}
+		return []domain.Token{st.tok(domain.Token{Type: domain.TokenGroup, Args: inner}, l.pos, cl.end)}, nil
+
+	case l.kind == lexIdent && st.keyword() == "":
+		if _, ok := temporalOps[l.text]; ok {
+			t, err := st.temporal()
+			if err != nil {
+				return nil, err
+			}
+			return []domain.Token{t}, nil
+		}
+		if _, ok := st.p.Reg.Predicates[l.text]; ok {
+			return nil, st.errf("%s is a condition and cannot be used as a value", l.text)
+		}
+		t, err := st.call()
+		if err != nil {
+			return nil, err
+		}
+		return []domain.Token{t}, nil
+
+	case l.kind == lexOp && l.text == "-":
+		return nil, st.errf("unary minus is only allowed before numbers")
+	case l.kind == lexEOF:
+		return nil, st.errf("unexpected end of input, expected a value")
+	}
+	return nil, st.errf("expected a value, got %q", l.text)
+}
+
+// callArgs holds the parsed contents of "(...)" after a name.
+type callArgs struct {
+	exprs  [][]domain.Token
+	pos    []any // numbers, or "$name" param references
+	params map[string]any
+}
+
+func (st *dslState) args(allowBool bool) (callArgs, int, error) {
+	ca := callArgs{params: map[string]any{}}
+	if _, err := st.expect(lexPunct, "("); err != nil {
+		return ca, 0, err
+	}
+	if st.is(lexPunct, ")") {
+		return ca, st.advance().end, nil
+	}
+	for {
+		switch {
+		case st.peek().kind == lexIdent && st.peekAt(1).kind == lexPunct && st.peekAt(1).text == "=":
+			name := st.advance().text
+			st.advance()
+			v, err := st.paramValue(allowBool)
+			if err != nil {
+				return ca, 0, err
+			}
+			if _, dup := ca.params[name]; dup {
+				return ca, 0, st.errf("duplicate param %q", name)
+			}
+			ca.params[name] = v
+		case st.peek().kind == lexNumber && (st.peekAt(1).text == "," || st.peekAt(1).text == ")"):
+			v, _ := strconv.ParseFloat(st.advance().text, 64)
+			ca.pos = append(ca.pos, v)
+		case st.peek().kind == lexParam && (st.peekAt(1).text == "," || st.peekAt(1).text == ")"):
+			ca.pos = append(ca.pos, "$"+st.advance().text)
+		default:
+			e, err := st.expr()
+			if err != nil {
+				return ca, 0, err
+			}
+			ca.exprs = append(ca.exprs, e)
+		}
+		if st.is(lexPunct, ",") {
+			st.advance()
+			continue
+		}
+		cl, err := st.expect(lexPunct, ")")
+		if err != nil {
+			return ca, 0, err
+		}
+		return ca, cl.end, nil
+	}
+}
+
+func (st *dslState) paramValue(allowBool bool) (any, error) {
+	neg := false
+	if st.is(lexOp, "-") {
+		st.advance()
+		neg = true
+	}
+	l := st.peek()
+	if l.kind == lexNumber {
+		st.advance()
+		v, err := strconv.ParseFloat(l.text, 64)
+		if err != nil {
+			return nil, posError(st.src, l.pos, "invalid number "+l.text)
+		}
+		if neg {
+			v = -v
+		}
+		return v, nil
+	}
+	if !neg && l.kind == lexParam {
+		st.advance()
+		return "$" + l.text, nil
+	}
+	if allowBool && !neg && l.kind == lexIdent && (l.text == "true" || l.text == "false") {
+		st.advance()
+		return l.text == "true", nil
+	}
+	return nil, st.errf("expected a number")
+}
+
+// fillPositional maps positional numbers and $params onto spec params in
+// declared order.
+func (st *dslState) fillPositional(name string, spec []ArgSpec, ca callArgs, at int) error {
+	k := 0
+	for _, v := range ca.pos {
+		for k < len(spec) {
+			if _, set := ca.params[spec[k].Name]; !set {
+				break
+			}
+			k++
+		}
+		if k >= len(spec) {
+			return posError(st.src, at, fmt.Sprintf("%s: too many positional arguments", name))
+		}
+		ca.params[spec[k].Name] = v
+		k++
+	}
+	return nil
+}
+
+func (st *dslState) call() (domain.Token, error) {
+	name := st.advance()
+	start, end := name.pos, name.end
+	tf := st.p.DefaultTF
+	offset := 0
+
+	if st.peek().kind == lexBracket && !strings.HasPrefix(st.peek().text, "-") {
+		b := st.advance()
+		tf = domain.Timeframe(b.text)
+		if _, ok := domain.AllowedTF[tf]; !ok {
+			return domain.Token{}, posError(st.src, b.pos, fmt.Sprintf("invalid timeframe %q", b.text))
+		}
+		end = b.end
+	}
+
+	ca := callArgs{params: map[string]any{}}
+	if st.is(lexPunct, "(") {
+		var err error
+		if ca, end, err = st.args(false); err != nil {
+			return domain.Token{}, err
+		}
+	}
+
+	if st.peek().kind == lexBracket {
+		b := st.advance()
+		n, err := strconv.Atoi(strings.TrimPrefix(b.text, "-"))
+		if err != nil || !strings.HasPrefix(b.text, "-") || n <= 0 {
+			return domain.Token{}, posError(st.src, b.pos, "offset must look like [-N]")
+		}
+		offset = n
+		end = b.end
+	}
+
+	var args []domain.Token
+	for _, e := range ca.exprs {
+		if len(e) == 1 {
+			args = append(args, e[0])
+		} else {
+			// arguments are single tokens; wrap compound expressions
+			args = append(args, st.tok(domain.Token{Type: domain.TokenGroup, Args: e}, st.spans[e[0].ID].Start, st.spans[e[len(e)-1].ID].End))
+		}
+	}
+
+	if spec, ok := st.p.Reg.Indicators[name.text]; ok {
+		if err := st.fillPositional(name.text, spec.Params, ca, name.pos); err != nil {
+			return domain.Token{}, err
+		}
+		t := domain.Token{Type: domain.TokenIndicator, Indicator: name.text, Timeframe: tf, Offset: offset, Args: args}
+		if len(ca.params) > 0 {
+			t.Params = ca.params
+		}
+		return st.tok(t, start, end), nil
+	}
+	if ci, ok := st.p.Custom[name.text]; ok {
+		if err := st.fillPositional(name.text, ci.ArgSpecs(), ca, name.pos); err != nil {
+			return domain.Token{}, err
+		}
+		if len(args) > 0 {
+			return domain.Token{}, posError(st.src, name.pos, fmt.Sprintf("%s takes no series arguments", name.text))
+		}
+		t := domain.Token{Type: domain.TokenIndicator, Indicator: name.text, Timeframe: tf, Offset: offset}
+		if len(ca.params) > 0 {
+			t.Params = ca.params
+		}
+		return st.tok(t, start, end), nil
+	}
+	if spec, ok := st.p.Reg.Functions[name.text]; ok {
+		if err := st.fillPositional(name.text, spec.Params, ca, name.pos); err != nil {
+			return domain.Token{}, err
+		}
+		if offset != 0 {
+			return domain.Token{}, posError(st.src, name.pos, "offsets apply to indicators only")
+		}
+		return st.tok(domain.Token{Type: domain.TokenFunction, Function: name.text, Params: ca.params, Args: args}, start, end), nil
+	}
+	if spec, ok := st.p.Reg.Predicates[name.text]; ok {
+		if err := st.fillPositional(name.text, spec.Params, ca, name.pos); err != nil {
+			return domain.Token{}, err
+		}
+		if len(args) > 0 {
+			return domain.Token{}, posError(st.src, name.pos, fmt.Sprintf("%s takes no series arguments", name.text))
+		}
+		t := domain.Token{Type: domain.TokenPredicate, Function: name.text, Timeframe: tf, Offset: offset}
+		if len(ca.params) > 0 {
+			t.Params = ca.params
+		}
+		return st.tok(t, start, end), nil
+	}
+	return domain.Token{}, posError(st.src, name.pos, fmt.Sprintf("unknown indicator or function %q", name.text))
+}
+
+// temporal parses held_for/within/bars_since/count_true(pred, n) and sequence(...).
+func (st *dslState) temporal() (domain.Token, error) {
+	name := st.advance()
+	if name.text == "sequence" {
+		return st.sequence(name)
+	}
+	if _, err := st.expect(lexPunct, "("); err != nil {
+		return domain.Token{}, err
+	}
+	inner, err := st.pred()
+	if err != nil {
+		return domain.Token{}, err
+	}
+	params := map[string]any{}
+	if st.is(lexPunct, ",") {
+		st.advance()
+		if st.peek().kind == lexIdent && st.peekAt(1).text == "=" {
+			key := st.advance().text
+			st.advance()
+			if key != "n" {
+				return domain.Token{}, st.errf("unknown param %q", key)
+			}
+		}
+		v, err := st.paramValue(false)
+		if err != nil {
+			return domain.Token{}, err
+		}
+		params["n"] = v
+	}
+	cl, err := st.expect(lexPunct, ")")
+	if err != nil {
+		return domain.Token{}, err
+	}
+	t := domain.Token{Type: domain.TokenTemporal, Function: name.text, Args: inner}
+	if len(params) > 0 {
+		t.Params = params
+	}
+	return st.tok(t, name.pos, cl.end), nil
+}
+
+// sequence parses sequence((A), (B){max_gap=10}, reset(C), max_gap=20, restart=false).
+func (st *dslState) sequence(name lexeme) (domain.Token, error) {
+	if _, err := st.expect(lexPunct, "("); err != nil {
+		return domain.Token{}, err
+	}
+	var steps []domain.Token
+	params := map[string]any{}
+	for {
+		l := st.peek()
+		switch {
+		case l.kind == lexIdent && st.peekAt(1).text == "=":
+			st.advance()
+			st.advance()
+			v, err := st.paramValue(true)
+			if err != nil {
+				return domain.Token{}, err
+			}
+			params[l.text] = v
+		case l.kind == lexIdent && l.text == "reset", l.kind == lexPunct && l.text == "(":
+			g := domain.Token{Type: domain.TokenGroup}
+			if l.text == "reset" {
+				st.advance()
+				g.Function = "reset"
+			}
+			if _, err := st.expect(lexPunct, "("); err != nil {
+				return domain.Token{}, err
+			}
+			inner, err := st.pred()
+			if err != nil {
+				return domain.Token{}, err
+			}
+			cl, err := st.expect(lexPunct, ")")
+			if err != nil {
+				return domain.Token{}, err
+			}
+			end := cl.end
+			if st.is(lexPunct, "{") {
+				st.advance()
+				gp := map[string]any{}
+				for !st.is(lexPunct, "}") {
+					key, err := st.expect(lexIdent, "max_gap")
+					if err != nil {
+						return domain.Token{}, err
+					}
+					if _, err := st.expect(lexPunct, "="); err != nil {
+						return domain.Token{}, err
+					}
+					v, err := st.paramValue(false)
+					if err != nil {
+						return domain.Token{}, err
+					}
+					gp[key.text] = v
+				}
+				end = st.advance().end
+				g.Params = gp
+			}
+			g.Args = inner
+			steps = append(steps, st.tok(g, l.pos, end))
+		default:
+			return domain.Token{}, st.errf("expected a (step), reset(...) or name=value")
+		}
+		if st.is(lexPunct, ",") {
+			st.advance()
+			continue
+		}
+		cl, err := st.expect(lexPunct, ")")
+		if err != nil {
+			return domain.Token{}, err
+		}
+		t := domain.Token{Type: domain.TokenTemporal, Function: "sequence", Args: steps}
+		if len(params) > 0 {
+			t.Params = params
+		}
+		return st.tok(t, name.pos, cl.end), nil
+	}
+}
@@ -3,7 +3,9 @@ package engine
 
 import (
 	"errors"
+	"maps"
 	"math"
+	"sync"
 
 	"github.com/gulll/deepmarket/backtesting/domain"
 )
@@ -17,6 +19,14 @@ type DataProvider interface {
 	AlignTo(baseTF domain.Timeframe, series Series, fromTF domain.Timeframe) (Series, error)
 }
 
+// WarmupLoader is implemented by providers that can prepend history to a load
+// so that a plan is already warm on the first bar of the usual range.
+type WarmupLoader interface {
+	// LoadOHLCVWithWarmup returns up to warmup bars before the usual range
+	// followed by the range itself, and how many bars were prepended.
+	LoadOHLCVWithWarmup(symbol string, tf domain.Timeframe, warmup int) ([]domain.Candle, int, error)
+}
+
 type EvalPolicy struct {
 	// When comparing floats, optionally treat NaN as false (skip) instead of propagating.
 	NaNIsFalse bool
@@ -25,15 +35,23 @@ type EvalPolicy struct {
 type EvalCtx struct {
 	Symbol string
 	BaseTF domain.Timeframe
-	Data   DataProvider
-	Reg    *Registry
+	// PairSymbol is the second leg of a pair backtest; see SetPair.
+	PairSymbol string
+	Data       DataProvider
+	Reg        *Registry
 
+	// mu guards cache and bcache; plan nodes may be evaluated concurrently
+	mu sync.RWMutex
 	// memoize computed indicator/function series by a key
 	cache map[string]Series
 	// memoize booleans
 	bcache map[string]BoolSeries
 
 	Policy EvalPolicy
+
+	// optional cross-request cache of node outputs, keyed by sharedKey+node ID
+	shared    *LRU
+	sharedKey string
 }
 
 func NewEvalCtx(sym string, baseTF domain.Timeframe, dp DataProvider, reg *Registry) *EvalCtx {
@@ -44,14 +62,84 @@ func NewEvalCtx(sym string, baseTF domain.Timeframe, dp DataProvider, reg *Regis
 	}
 }
 
-func (ctx *EvalCtx) GetCache() map[string]Series { return ctx.cache }
+// GetCache returns a snapshot of the series cache.
+func (ctx *EvalCtx) GetCache() map[string]Series {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	return maps.Clone(ctx.cache)
+}
 func (ctx *EvalCtx) SetCache(cache map[string]Series) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
 	ctx.cache = cache
 	if ctx.bcache == nil {
 		ctx.bcache = map[string]BoolSeries{}
 	}
 }
 
+// SetPair adds the closes of a second symbol, aligned to the base bars, as
+// the "pair_close" series read by the Pair indicators. Call it before
+// attaching a shared cache so that the cache key includes the pair.
+func (ctx *EvalCtx) SetPair(symbol string, closes Series) {
+	ctx.PairSymbol = symbol
+	ctx.storeSeries("pair_close", closes)
+}
+
+// SeriesOf returns the computed series for a plan node ID, if present.
+func (ctx *EvalCtx) SeriesOf(id string) (Series, bool) {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	s, ok := ctx.cache[id]
+	return s, ok
+}
+
+// BoolOf returns the computed boolean series for a plan node ID, if present.
+func (ctx *EvalCtx) BoolOf(id string) (BoolSeries, bool) {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	s, ok := ctx.bcache[id]
+	return s, ok
+}
+
+// UseShared lets the context reuse node outputs computed by earlier requests
+// on the same data. dataKey must change whenever the underlying candles do.
+func (ctx *EvalCtx) UseShared(cache *LRU, dataKey string) {
+	ctx.shared = cache
+	ctx.sharedKey = dataKey
+}
+
+func (ctx *EvalCtx) sharedGet(id string) (any, bool) {
+	if ctx.shared == nil {
+		return nil, false
+	}
+	return ctx.shared.Get(ctx.sharedKey + "|" + id)
+}
+
+func (ctx *EvalCtx) sharedPut(id string, v any, size int64) {
+	if ctx.shared == nil {
+		return
+	}
+	ctx.shared.Add(ctx.sharedKey+"|"+id, v, size)
+}
+
+// series returns a cached series (e.g. "close") or nil when missing.
+func (ctx *EvalCtx) series(key string) Series {
+	s, _ := ctx.SeriesOf(key)
+	return s
+}
+
+func (ctx *EvalCtx) storeSeries(id string, s Series) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.cache[id] = s
+}
+
+func (ctx *EvalCtx) storeBool(id string, s BoolSeries) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.bcache[id] = s
+}
+
 func eqLen(a, b Series) error {
 	if len(a) != len(b) {
 		return errors.New("series length mismatch")
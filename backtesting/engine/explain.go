@@ -0,0 +1,69 @@
+// engine/explain.go
+package engine
+
+import (
+	"fmt"
+	"math"
+)
+
+// ExplainNode mirrors a plan node (and therefore the condition AST) together
+// with the values it produced at the requested bars.
+type ExplainNode struct {
+	ID       string         `json:"id"`
+	Op       string         `json:"op"`
+	Label    string         `json:"label"`
+	Kind     string         `json:"kind"` // "series" or "bool"
+	Values   []any          `json:"values"`
+	Children []*ExplainNode `json:"children,omitempty"`
+}
+
+// Explain evaluates the plan and returns the value of every node at the given
+// bar indices, starting from the root predicate. Shared (CSE) nodes appear
+// under every parent that uses them so the tree reads like the condition.
+func (rt *Runtime) Explain(pl *Plan, bars []int) (*ExplainNode, error) {
+	if len(pl.Roots) == 0 {
+		return nil, fmt.Errorf("empty plan")
+	}
+	if _, err := rt.ExecPlan(pl); err != nil {
+		return nil, err
+	}
+	return rt.explainNode(pl.Roots[0], bars)
+}
+
+func (rt *Runtime) explainNode(n *PlanNode, bars []int) (*ExplainNode, error) {
+	out := &ExplainNode{ID: n.ID, Op: n.Op, Label: n.Label(), Values: make([]any, len(bars))}
+
+	if n.Kind == NodeBool {
+		out.Kind = "bool"
+		bs, ok := rt.ctx.BoolOf(n.ID)
+		if !ok {
+			return nil, fmt.Errorf("node %s was not evaluated", n.Label())
+		}
+		for i, b := range bars {
+			if b >= 0 && b < len(bs) {
+				out.Values[i] = bs[b]
+			}
+		}
+	} else {
+		out.Kind = "series"
+		ser, ok := rt.ctx.SeriesOf(n.ID)
+		if !ok {
+			return nil, fmt.Errorf("node %s was not evaluated", n.Label())
+		}
+		for i, b := range bars {
+			// NaN has no JSON form, leave it as null
+			if b >= 0 && b < len(ser) && !math.IsNaN(ser[b]) && !math.IsInf(ser[b], 0) {
+				out.Values[i] = ser[b]
+			}
+		}
+	}
+
+	for _, d := range n.Deps {
+		child, err := rt.explainNode(d, bars)
+		if err != nil {
+			return nil, err
+		}
+		out.Children = append(out.Children, child)
+	}
+	return out, nil
+}
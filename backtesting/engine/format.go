@@ -0,0 +1,224 @@
+// engine/format.go
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// FormatTokens renders a token list as a single readable line, e.g.
+// "EMA(Close[5m], period=20) crosses_above SMA(Close[5m], period=50) AND RSI[5m](period=14) < 30".
+func FormatTokens(ts []domain.Token) string {
+	parts := make([]string, 0, len(ts))
+	for _, t := range ts {
+		parts = append(parts, formatToken(t))
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatToken(t domain.Token) string {
+	switch t.Type {
+	case domain.TokenNumber:
+		return formatNumber(t.Value)
+	case domain.TokenOperator, domain.TokenLogical:
+		return t.Operator
+	case domain.TokenParam:
+		return "$" + t.Function
+	case domain.TokenIndicator:
+		s := t.Indicator
+		if t.Timeframe != "" {
+			s += "[" + string(t.Timeframe) + "]"
+		}
+		args := formatArgs(t.Args, t.Params)
+		if args != "" {
+			s += "(" + args + ")"
+		}
+		if t.Offset != 0 {
+			s += fmt.Sprintf("[-%d]", t.Offset)
+		}
+		return s
+	case domain.TokenFunction:
+		return t.Function + "(" + formatArgs(t.Args, t.Params) + ")"
+	case domain.TokenPredicate:
+		s := t.Function
+		if t.Timeframe != "" {
+			s += "[" + string(t.Timeframe) + "]"
+		}
+		if args := formatArgs(nil, t.Params); args != "" {
+			s += "(" + args + ")"
+		}
+		if t.Offset != 0 {
+			s += fmt.Sprintf("[-%d]", t.Offset)
+		}
+		return s
+	case domain.TokenGroup:
+		s := "(" + FormatTokens(t.Args) + ")"
+		if t.Function == "reset" {
+			s = "reset" + s
+		}
+		if ps, ok := t.Params.(map[string]any); ok && len(ps) > 0 {
+			s += "{" + formatParams(ps) + "}"
+		}
+		return s
+	case domain.TokenTemporal:
+		if t.Function == "sequence" {
+			parts := make([]string, 0, len(t.Args))
+			for _, a := range t.Args {
+				parts = append(parts, formatToken(a))
+			}
+			s := "sequence(" + strings.Join(parts, ", ")
+			if ps, ok := t.Params.(map[string]any); ok && len(ps) > 0 {
+				s += ", " + formatParams(ps)
+			}
+			return s + ")"
+		}
+		s := t.Function + "(" + FormatTokens(t.Args)
+		if ps, ok := t.Params.(map[string]any); ok && len(ps) > 0 {
+			s += ", " + formatParams(ps)
+		}
+		return s + ")"
+	}
+	return string(t.Type)
+}
+
+// formatNumber prints v without an exponent, which the DSL cannot read.
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatArgs(args []domain.Token, params any) string {
+	parts := make([]string, 0, len(args))
+	for _, a := range args {
+		parts = append(parts, formatToken(a))
+	}
+	if ps, ok := params.(map[string]any); ok && len(ps) > 0 {
+		parts = append(parts, formatParams(ps))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatPred renders a parsed predicate back to DSL text. AND/OR chains print
+// flat; a nested chain on the right is parenthesized since the grammar
+// associates left.
+func FormatPred(p domain.PredNode) string {
+	switch n := p.(type) {
+	case domain.CompareNode:
+		return FormatExpr(n.Left) + " " + n.Op + " " + FormatExpr(n.Right)
+	case domain.LogicalNode:
+		if n.Op == "NOT" {
+			return "NOT " + formatPredOperand(n.Lhs)
+		}
+		rhs := FormatPred(n.Rhs)
+		if isBinaryLogical(n.Rhs) {
+			rhs = "(" + rhs + ")"
+		}
+		return FormatPred(n.Lhs) + " " + n.Op + " " + rhs
+	case domain.PatternNode:
+		s := n.Name
+		if n.Timeframe != "" {
+			s += "[" + string(n.Timeframe) + "]"
+		}
+		if len(n.Params) > 0 {
+			s += "(" + formatParams(n.Params) + ")"
+		}
+		if n.Offset != 0 {
+			s += fmt.Sprintf("[-%d]", n.Offset)
+		}
+		return s
+	case domain.TemporalNode:
+		return fmt.Sprintf("%s(%s, %d)", n.Op, FormatPred(n.Pred), n.N)
+	case domain.SequenceNode:
+		parts := make([]string, 0, len(n.Steps)+2)
+		for _, s := range n.Steps {
+			step := "(" + FormatPred(s.Pred) + ")"
+			if s.MaxGap > 0 {
+				step += fmt.Sprintf("{max_gap=%d}", s.MaxGap)
+			}
+			parts = append(parts, step)
+		}
+		if n.Reset != nil {
+			parts = append(parts, "reset("+FormatPred(n.Reset)+")")
+		}
+		if !n.Restart {
+			parts = append(parts, "restart=false")
+		}
+		return "sequence(" + strings.Join(parts, ", ") + ")"
+	}
+	return fmt.Sprintf("%T", p)
+}
+
+func isBinaryLogical(p domain.PredNode) bool {
+	n, ok := p.(domain.LogicalNode)
+	return ok && n.Op != "NOT"
+}
+
+// formatPredOperand parenthesizes anything NOT would otherwise bind too loosely to.
+func formatPredOperand(p domain.PredNode) string {
+	switch n := p.(type) {
+	case domain.PatternNode, domain.TemporalNode, domain.SequenceNode:
+		return FormatPred(p)
+	case domain.LogicalNode:
+		if n.Op == "NOT" {
+			return FormatPred(p)
+		}
+	}
+	return "(" + FormatPred(p) + ")"
+}
+
+// FormatExpr renders an expression, adding only the parentheses precedence needs.
+func FormatExpr(e domain.ExprNode) string {
+	switch n := e.(type) {
+	case domain.NumberNode:
+		return formatNumber(n.Value)
+	case domain.IndicatorNode:
+		s := n.Name
+		if n.Timeframe != "" {
+			s += "[" + string(n.Timeframe) + "]"
+		}
+		if args := formatExprArgs(n.Args, n.Params); args != "" {
+			s += "(" + args + ")"
+		}
+		if n.Offset != 0 {
+			s += fmt.Sprintf("[-%d]", n.Offset)
+		}
+		return s
+	case domain.FunctionNode:
+		return n.Name + "(" + formatExprArgs(n.Args, n.Params) + ")"
+	case domain.BinaryMathNode:
+		return formatMathOperand(n.Left, n.Op, false) + " " + n.Op + " " + formatMathOperand(n.Right, n.Op, true)
+	case domain.PredSeriesNode:
+		if n.Op == "count_true" {
+			return fmt.Sprintf("%s(%s, %d)", n.Op, FormatPred(n.Pred), n.N)
+		}
+		return n.Op + "(" + FormatPred(n.Pred) + ")"
+	}
+	return fmt.Sprintf("%T", e)
+}
+
+func formatMathOperand(e domain.ExprNode, parentOp string, right bool) string {
+	s := FormatExpr(e)
+	if b, ok := e.(domain.BinaryMathNode); ok {
+		pc, pp := mathPrecedence[b.Op], mathPrecedence[parentOp]
+		if pc < pp || (right && pc == pp) {
+			return "(" + s + ")"
+		}
+	}
+	if num, ok := e.(domain.NumberNode); ok && right && num.Value < 0 {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+func formatExprArgs[V any](args []domain.ExprNode, params map[string]V) string {
+	parts := make([]string, 0, len(args)+1)
+	for _, a := range args {
+		parts = append(parts, FormatExpr(a))
+	}
+	if len(params) > 0 {
+		parts = append(parts, formatParams(params))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,49 @@
+package engine
+
+import "testing"
+
+// Formatted text must parse back to the same tokens and predicate.
+func TestFormatRoundTrip(t *testing.T) {
+	reg := BuildRegistry()
+	dsl := &DSLParser{Reg: reg, DefaultTF: "5m"}
+	parser := &Parser{Reg: reg}
+	for _, text := range []string{
+		"Close > 0.00001",
+		"Close * 1000000000000000000000 > Open",
+		"AVWAP(anchor=1700000000) < Close",
+		"EMA(Close, 9) crosses_above SMA(Close, 21) AND RSI(Close, 14) < 30.5",
+		"(Close - Open) / (High - Low) > 0.6 OR Close[-2] > Close",
+		"held_for(Close > Open, 3) AND count_true(Close > Open, n=5) >= 3",
+		"sequence((Close > Open), (Close < Open){max_gap=5}, restart=true)",
+	} {
+		toks, _, err := dsl.Parse(text)
+		if err != nil {
+			t.Fatalf("%s: %v", text, err)
+		}
+		formatted := FormatTokens(toks)
+		again, _, err := dsl.Parse(formatted)
+		if err != nil {
+			t.Errorf("%s formats to %q, which does not parse: %v", text, formatted, err)
+			continue
+		}
+		if f := FormatTokens(again); f != formatted {
+			t.Errorf("%s: %q formats back to %q", text, formatted, f)
+		}
+
+		pred, err := parser.ParsePredicate(toks)
+		if err != nil {
+			t.Fatalf("%s: %v", text, err)
+		}
+		canonical := FormatPred(pred)
+		toks, _, err = dsl.Parse(canonical)
+		if err != nil {
+			t.Errorf("%s formats to %q, which does not parse: %v", text, canonical, err)
+			continue
+		}
+		if pred, err = parser.ParsePredicate(toks); err != nil {
+			t.Errorf("%s: %q: %v", text, canonical, err)
+		} else if f := FormatPred(pred); f != canonical {
+			t.Errorf("%s: %q formats back to %q", text, canonical, f)
+		}
+	}
+}
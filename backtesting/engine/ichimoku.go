@@ -0,0 +1,153 @@
+// engine/ichimoku.go
+package engine
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+var ichimokuParams = []ArgSpec{
+	{Name: "conv", Type: "int"},         // tenkan period, default 9
+	{Name: "base", Type: "int"},         // kijun period, default 26
+	{Name: "span_b", Type: "int"},       // senkou B period, default 52
+	{Name: "displacement", Type: "int"}, // default 26
+}
+
+func ichimokuSettings(params map[string]float64) (conv, base, spanB, disp int) {
+	get := func(k string, def int) int {
+		if v, ok := params[k]; ok && v > 0 {
+			return int(v)
+		}
+		return def
+	}
+	return get("conv", 9), get("base", 26), get("span_b", 52), get("displacement", 26)
+}
+
+// ctxBars rebuilds base candles from the series in ctx.
+func ctxBars(ctx *EvalCtx) []domain.Candle {
+	open, high, low, close := ctx.series("open"), ctx.series("high"), ctx.series("low"), ctx.series("close")
+	ts, vol := ctx.series("time"), ctx.series("volume")
+	bars := make([]domain.Candle, len(close))
+	for i := range bars {
+		bars[i] = domain.Candle{Open: open[i], High: high[i], Low: low[i], Close: close[i]}
+		if i < len(ts) {
+			bars[i].Time = time.Unix(int64(ts[i]), 0)
+		}
+		if i < len(vol) {
+			bars[i].Volume = vol[i]
+		}
+	}
+	return bars
+}
+
+// barsOn is the candles of ctx's symbol on tf: the base candles in ctx, or a
+// load from the data provider for another timeframe, which the runtime then
+// aligns to the base bars.
+func barsOn(ctx *EvalCtx, tf domain.Timeframe) ([]domain.Candle, error) {
+	if tf == "" || tf == ctx.BaseTF {
+		return ctxBars(ctx), nil
+	}
+	if ctx.Data == nil {
+		return nil, fmt.Errorf("no data provider to load %s bars", tf)
+	}
+	return ctx.Data.LoadOHLCV(ctx.Symbol, tf)
+}
+
+// displace moves s by n bars: forward (later) for n > 0, back for n < 0.
+// Bars without a source value are NaN.
+func displace(s Series, n int) Series {
+	out := make(Series, len(s))
+	for i := range out {
+		j := i - n
+		if j >= 0 && j < len(s) {
+			out[i] = s[j]
+		} else {
+			out[i] = math.NaN()
+		}
+	}
+	return out
+}
+
+// registerIchimoku adds the five Ichimoku lines as they are drawn on a chart:
+// the senkou spans are displaced forward, which only uses past bars, while the
+// chikou span is the close displaced back and therefore reads future bars.
+func registerIchimoku(reg *Registry) {
+	// stream picks the line from ichimokuStep's values and displaces it;
+	// nil for the chikou span, which cannot be known on its own bar
+	line := func(desc string, pick func(bars []domain.Candle, conv, base, spanB, disp int) Series,
+		lookback func(conv, base, spanB, disp int) int, futureRef bool,
+		stream func(tenkan, kijun, senkouA, senkouB float64) float64, displaced bool) IndicatorSpec {
+		spec := IndicatorSpec{
+			Category:    "Trend",
+			Description: desc,
+			Params:      ichimokuParams,
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe,
+				params map[string]float64, offset int, args ...Series) ([]float64, error) {
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				conv, base, spanB, disp := ichimokuSettings(params)
+				return shiftBack(pick(bars, conv, base, spanB, disp), offset)
+			},
+			Lookback: func(params map[string]float64) int {
+				return lookback(ichimokuSettings(params))
+			},
+			FutureRef: futureRef,
+		}
+		if stream != nil {
+			spec.Stream = func(_ *EvalCtx, params map[string]float64) IndicatorStep {
+				conv, base, spanB, disp := ichimokuSettings(params)
+				step := ichimokuStep(conv, base, spanB)
+				delay := newDelay(0)
+				if displaced {
+					delay = newDelay(disp)
+				}
+				return func(bar domain.Candle, _ []float64) float64 { return delay.push(stream(step(bar))) }
+			}
+		}
+		return spec
+	}
+
+	reg.Indicators["IchimokuTenkan"] = line("Ichimoku conversion line",
+		func(bars []domain.Candle, conv, base, spanB, disp int) Series {
+			t, _, _, _, _ := Ichimoku(bars, conv, base, spanB, disp)
+			return t
+		},
+		func(conv, _, _, _ int) int { return conv - 1 }, false,
+		func(tenkan, _, _, _ float64) float64 { return tenkan }, false)
+
+	reg.Indicators["IchimokuKijun"] = line("Ichimoku base line",
+		func(bars []domain.Candle, conv, base, spanB, disp int) Series {
+			_, k, _, _, _ := Ichimoku(bars, conv, base, spanB, disp)
+			return k
+		},
+		func(_, base, _, _ int) int { return base - 1 }, false,
+		func(_, kijun, _, _ float64) float64 { return kijun }, false)
+
+	reg.Indicators["IchimokuSenkouA"] = line("Ichimoku leading span A, displaced forward",
+		func(bars []domain.Candle, conv, base, spanB, disp int) Series {
+			_, _, a, _, _ := Ichimoku(bars, conv, base, spanB, disp)
+			return displace(a, disp)
+		},
+		func(conv, base, _, disp int) int { return maxInt(conv, base) - 1 + disp }, false,
+		func(_, _, senkouA, _ float64) float64 { return senkouA }, true)
+
+	reg.Indicators["IchimokuSenkouB"] = line("Ichimoku leading span B, displaced forward",
+		func(bars []domain.Candle, conv, base, spanB, disp int) Series {
+			_, _, _, b, _ := Ichimoku(bars, conv, base, spanB, disp)
+			return displace(b, disp)
+		},
+		func(_, _, spanB, disp int) int { return spanB - 1 + disp }, false,
+		func(_, _, _, senkouB float64) float64 { return senkouB }, true)
+
+	reg.Indicators["IchimokuChikou"] = line("Ichimoku lagging span: the close displaced back (reads future bars)",
+		func(bars []domain.Candle, conv, base, spanB, disp int) Series {
+			_, _, _, _, c := Ichimoku(bars, conv, base, spanB, disp)
+			return displace(c, -disp)
+		},
+		func(_, _, _, _ int) int { return 0 }, true, nil, false)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 
 	domain "github.com/gulll/deepmarket/backtesting/domain"
 )
@@ -13,6 +14,7 @@ func BuildRegistry() *Registry {
 	reg := &Registry{
 		Indicators: map[string]IndicatorSpec{},
 		Functions:  map[string]FunctionSpec{},
+		Predicates: map[string]PredicateSpec{},
 	}
 
 	// Close
@@ -22,22 +24,11 @@ func BuildRegistry() *Registry {
 		Eval: func(ctx *EvalCtx, tf domain.Timeframe,
 			_ map[string]float64, offset int, args ...Series) ([]float64, error) {
 
-			cl := ctx.cache["close"]
-			if offset == 0 {
-				return cl, nil
-			}
-			// positive offset = shift back by N bars
-			if offset < 0 || offset >= len(cl) {
-				return nil, errors.New("bad offset")
-			}
-			out := make([]float64, len(cl))
-			for i := range cl {
-				j := i - offset
-				if j >= 0 {
-					out[i] = cl[j]
-				} // leading zeros remain 0
-			}
-			return out, nil
+			cl := ctx.series("close")
+			return shiftBack(cl, offset)
+		},
+		Stream: func(*EvalCtx, map[string]float64) IndicatorStep {
+			return func(bar domain.Candle, _ []float64) float64 { return bar.Close }
 		},
 	}
 
@@ -47,22 +38,11 @@ func BuildRegistry() *Registry {
 		Eval: func(ctx *EvalCtx, tf domain.Timeframe,
 			_ map[string]float64, offset int, args ...Series) ([]float64, error) {
 
-			cl := ctx.cache["open"]
-			if offset == 0 {
-				return cl, nil
-			}
-			// positive offset = shift back by N bars
-			if offset < 0 || offset >= len(cl) {
-				return nil, errors.New("bad offset")
-			}
-			out := make([]float64, len(cl))
-			for i := range cl {
-				j := i - offset
-				if j >= 0 {
-					out[i] = cl[j]
-				} // leading zeros remain 0
-			}
-			return out, nil
+			cl := ctx.series("open")
+			return shiftBack(cl, offset)
+		},
+		Stream: func(*EvalCtx, map[string]float64) IndicatorStep {
+			return func(bar domain.Candle, _ []float64) float64 { return bar.Open }
 		},
 	}
 
@@ -72,22 +52,11 @@ func BuildRegistry() *Registry {
 		Eval: func(ctx *EvalCtx, tf domain.Timeframe,
 			_ map[string]float64, offset int, args ...Series) ([]float64, error) {
 
-			cl := ctx.cache["high"]
-			if offset == 0 {
-				return cl, nil
-			}
-			// positive offset = shift back by N bars
-			if offset < 0 || offset >= len(cl) {
-				return nil, errors.New("bad offset")
-			}
-			out := make([]float64, len(cl))
-			for i := range cl {
-				j := i - offset
-				if j >= 0 {
-					out[i] = cl[j]
-				} // leading zeros remain 0
-			}
-			return out, nil
+			cl := ctx.series("high")
+			return shiftBack(cl, offset)
+		},
+		Stream: func(*EvalCtx, map[string]float64) IndicatorStep {
+			return func(bar domain.Candle, _ []float64) float64 { return bar.High }
 		},
 	}
 
@@ -97,22 +66,11 @@ func BuildRegistry() *Registry {
 		Eval: func(ctx *EvalCtx, tf domain.Timeframe,
 			_ map[string]float64, offset int, args ...Series) ([]float64, error) {
 
-			cl := ctx.cache["low"]
-			if offset == 0 {
-				return cl, nil
-			}
-			// positive offset = shift back by N bars
-			if offset < 0 || offset >= len(cl) {
-				return nil, errors.New("bad offset")
-			}
-			out := make([]float64, len(cl))
-			for i := range cl {
-				j := i - offset
-				if j >= 0 {
-					out[i] = cl[j]
-				} // leading zeros remain 0
-			}
-			return out, nil
+			cl := ctx.series("low")
+			return shiftBack(cl, offset)
+		},
+		Stream: func(*EvalCtx, map[string]float64) IndicatorStep {
+			return func(bar domain.Candle, _ []float64) float64 { return bar.Low }
 		},
 	}
 
@@ -122,22 +80,11 @@ func BuildRegistry() *Registry {
 		Eval: func(ctx *EvalCtx, tf domain.Timeframe,
 			_ map[string]float64, offset int, args ...Series) ([]float64, error) {
 
-			cl := ctx.cache["time"]
-			if offset == 0 {
-				return cl, nil
-			}
-			// positive offset = shift back by N bars
-			if offset < 0 || offset >= len(cl) {
-				return nil, errors.New("bad offset")
-			}
-			out := make([]float64, len(cl))
-			for i := range cl {
-				j := i - offset
-				if j >= 0 {
-					out[i] = cl[j]
-				} // leading zeros remain 0
-			}
-			return out, nil
+			cl := ctx.series("time")
+			return shiftBack(cl, offset)
+		},
+		Stream: func(*EvalCtx, map[string]float64) IndicatorStep {
+			return func(bar domain.Candle, _ []float64) float64 { return float64(bar.Time.Unix()) }
 		},
 	}
 
@@ -159,9 +106,9 @@ func BuildRegistry() *Registry {
 				high, low, close = args[0], args[1], args[2]
 			} else {
 				log.Println("using default series for Supertrend")
-				high = ctx.cache["high"]
-				low = ctx.cache["low"]
-				close = ctx.cache["close"]
+				high = ctx.series("high")
+				low = ctx.series("low")
+				close = ctx.series("close")
 			}
 
 			n := len(close)
@@ -181,7 +128,19 @@ func BuildRegistry() *Registry {
 
 			// Call Supertrend
 			trend, _ := Supertrend(bars, int(params["period"]), params["mult"])
-			return trend, nil
+			return shiftBack(trend, offset)
+		},
+		Lookback: func(params map[string]float64) int { return int(params["period"]) },
+		Stream: func(_ *EvalCtx, params map[string]float64) IndicatorStep {
+			step := supertrendStep(int(params["period"]), params["mult"])
+			return func(bar domain.Candle, args []float64) float64 {
+				if len(args) >= 3 {
+					trend, _ := step(args[0], args[1], args[2])
+					return trend
+				}
+				trend, _ := step(bar.High, bar.Low, bar.Close)
+				return trend
+			}
 		},
 	}
 
@@ -200,6 +159,11 @@ func BuildRegistry() *Registry {
 			period := int(params["period"].(float64))
 			return SMA(args[0], period), nil
 		},
+		Lookback: func(params map[string]any) int { return intParam(params, "period") - 1 },
+		Stream: func(params map[string]any) FunctionStep {
+			step := smaStep(intParam(params, "period"))
+			return func(args []float64) float64 { return step(args[0]) }
+		},
 	}
 
 	reg.Functions["EMA"] = FunctionSpec{
@@ -215,6 +179,13 @@ func BuildRegistry() *Registry {
 			period := int(params["period"].(float64))
 			return EMA(args[0], period), nil
 		},
+		// EMA is seeded with the first value, so it has output from bar 0 but
+		// is treated as warm after the same span an SMA would need.
+		Lookback: func(params map[string]any) int { return intParam(params, "period") - 1 },
+		Stream: func(params map[string]any) FunctionStep {
+			step := emaStep(intParam(params, "period"))
+			return func(args []float64) float64 { return step(args[0]) }
+		},
 	}
 
 	reg.Indicators["RSI"] = IndicatorSpec{
@@ -228,9 +199,52 @@ func BuildRegistry() *Registry {
 				return nil, errors.New("RSI requires an input series")
 			}
 			period := int(params["period"])
-			return RSI(args[0], period), nil
+			return shiftBack(RSI(args[0], period), offset)
+		},
+		Lookback: func(params map[string]float64) int { return int(params["period"]) },
+		Stream: func(_ *EvalCtx, params map[string]float64) IndicatorStep {
+			step := rsiStep(int(params["period"]))
+			return func(_ domain.Candle, args []float64) float64 { return step(args[0]) }
 		},
 	}
 
+	registerIchimoku(reg)
+	registerPatterns(reg)
+	registerSession(reg)
+	registerCalendar(reg)
+	registerVolatility(reg)
+	registerStats(reg)
+	registerPairs(reg)
+
 	return reg
 }
+
+var errBadOffset = errors.New("bad offset")
+
+// shiftBack delays s by offset bars. The first offset values have no source
+// bar and are NaN, like any other warm-up.
+func shiftBack(s Series, offset int) (Series, error) {
+	if offset == 0 {
+		return s, nil
+	}
+	if offset < 0 || offset >= len(s) {
+		return nil, errBadOffset
+	}
+	out := make([]float64, len(s))
+	for i := range s {
+		if j := i - offset; j >= 0 {
+			out[i] = s[j]
+		} else {
+			out[i] = math.NaN()
+		}
+	}
+	return out, nil
+}
+
+// intParam reads a numeric function param as int, 0 when absent.
+func intParam(params map[string]any, name string) int {
+	if f, ok := params[name].(float64); ok {
+		return int(f)
+	}
+	return 0
+}
@@ -0,0 +1,209 @@
+// engine/inspect.go
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PlanNodeInfo is the serializable view of a plan node.
+type PlanNodeInfo struct {
+	ID       string         `json:"id"`
+	Kind     string         `json:"kind"`
+	Op       string         `json:"op"`
+	Label    string         `json:"label"`
+	Meta     map[string]any `json:"meta,omitempty"`
+	Deps     []string       `json:"deps,omitempty"`
+	Parents  int            `json:"parents"`
+	Shared   bool           `json:"shared"`   // reused by more than one parent (CSE hit)
+	Lookback int            `json:"lookback"` // bars needed before the first valid value
+	Cost     float64        `json:"cost"`     // relative cost of computing this node alone
+}
+
+// PlanInfo describes a whole plan: nodes in evaluation order plus totals.
+type PlanInfo struct {
+	Roots     []string       `json:"roots"`
+	Nodes     []PlanNodeInfo `json:"nodes"`
+	Lookback  int            `json:"lookback"`
+	Cost      float64        `json:"cost"`       // cost with CSE (each node once)
+	NaiveCost float64        `json:"naive_cost"` // cost if every reference were recomputed
+}
+
+func (k NodeType) String() string {
+	switch k {
+	case NodeSeries:
+		return "series"
+	case NodeBool:
+		return "bool"
+	case NodeAlign:
+		return "align"
+	case NodeShift:
+		return "shift"
+	}
+	return fmt.Sprintf("NodeType(%d)", int(k))
+}
+
+// maxInt is the int counterpart of max in ta.go.
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// nodeCost is a rough per-bar work estimate: windowed indicators and
+// functions are charged by their period, everything else is a single pass.
+func nodeCost(n *PlanNode) float64 {
+	switch n.Op {
+	case "const":
+		return 0
+	case "indicator", "function":
+		return 1 + float64(nodePeriod(n))/10
+	}
+	return 1
+}
+
+// nodePeriod returns the largest period-like parameter of the node.
+func nodePeriod(n *PlanNode) int {
+	p := 0
+	visit := func(k string, v float64) {
+		k = strings.ToLower(k)
+		if strings.Contains(k, "period") || k == "fast" || k == "slow" || k == "signal" || k == "n" {
+			p = maxInt(p, int(v))
+		}
+	}
+	switch ps := n.Meta["params"].(type) {
+	case map[string]float64:
+		for k, v := range ps {
+			visit(k, v)
+		}
+	case map[string]any:
+		for k, v := range ps {
+			if f, ok := v.(float64); ok {
+				visit(k, f)
+			}
+		}
+	}
+	return p
+}
+
+// nodeLookback is the warm-up the node adds on top of its inputs. Indicator
+// and function nodes carry it from their spec; the rest follow from the op.
+func nodeLookback(n *PlanNode) int {
+	lb := 0
+	if spec, ok := n.Meta["lookback"].(int); ok {
+		lb = spec
+	}
+	if n.Op == "cmp:crosses_above" || n.Op == "cmp:crosses_below" {
+		lb++
+	}
+	if w, ok := n.Meta["n"].(int); ok && w > 0 {
+		lb += w - 1
+	}
+	if gaps, ok := n.Meta["gaps"].([]int); ok {
+		for _, g := range gaps {
+			lb += g
+		}
+	}
+	return lb
+}
+
+// planLookbacks returns the cumulative warm-up of every node: the longest
+// warm-up among its inputs plus its own. order must be topological.
+func planLookbacks(order []*PlanNode) map[string]int {
+	lookback := make(map[string]int, len(order))
+	for _, n := range order {
+		lb := 0
+		for _, d := range n.Deps {
+			lb = maxInt(lb, lookback[d.ID])
+		}
+		lookback[n.ID] = lb + nodeLookback(n)
+	}
+	return lookback
+}
+
+// InspectPlan summarizes a plan for debugging and visualization.
+func InspectPlan(pl *Plan) PlanInfo {
+	parents := map[string]int{}
+	for _, n := range pl.Order {
+		for _, d := range n.Deps {
+			parents[d.ID]++
+		}
+	}
+
+	info := PlanInfo{Lookback: pl.Warmup}
+	lookback := planLookbacks(pl.Order)
+	for _, n := range pl.Order {
+		lb := lookback[n.ID]
+		ni := PlanNodeInfo{
+			ID:       n.ID,
+			Kind:     n.Kind.String(),
+			Op:       n.Op,
+			Label:    n.Label(),
+			Meta:     n.Meta,
+			Parents:  parents[n.ID],
+			Shared:   parents[n.ID] > 1,
+			Lookback: lb,
+			Cost:     nodeCost(n),
+		}
+		for _, d := range n.Deps {
+			ni.Deps = append(ni.Deps, d.ID)
+		}
+		info.Nodes = append(info.Nodes, ni)
+		info.Cost += ni.Cost
+	}
+
+	var naive func(n *PlanNode) float64
+	naive = func(n *PlanNode) float64 {
+		c := nodeCost(n)
+		for _, d := range n.Deps {
+			c += naive(d)
+		}
+		return c
+	}
+	for _, r := range pl.Roots {
+		info.Roots = append(info.Roots, r.ID)
+		info.NaiveCost += naive(r)
+	}
+	return info
+}
+
+// DOT renders the plan as a Graphviz digraph. Edges point from a node to the
+// nodes that consume it; shared nodes are highlighted.
+func (info PlanInfo) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph plan {\n")
+	b.WriteString("  rankdir=BT;\n  node [fontname=\"Helvetica\", fontsize=10];\n")
+	for _, n := range info.Nodes {
+		shape := "box"
+		if n.Kind == "bool" {
+			shape = "ellipse"
+		}
+		style := ""
+		if n.Shared {
+			style = `, style=filled, fillcolor="#ffe8a3"`
+		}
+		label := fmt.Sprintf("%s\nlookback=%d cost=%.1f", n.Label, n.Lookback, n.Cost)
+		if n.Shared {
+			label += fmt.Sprintf("\nshared x%d", n.Parents)
+		}
+		fmt.Fprintf(&b, "  %q [label=%q, shape=%s%s];\n", n.ID, label, shape, style)
+	}
+	for _, n := range info.Nodes {
+		for _, d := range n.Deps {
+			fmt.Fprintf(&b, "  %q -> %q;\n", d, n.ID)
+		}
+	}
+	for _, r := range info.Roots {
+		fmt.Fprintf(&b, "  %q [peripheries=2];\n", r)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
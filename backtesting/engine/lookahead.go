@@ -0,0 +1,236 @@
+// engine/lookahead.go
+package engine
+
+import (
+	"math"
+	"time"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// LookaheadFinding is a plan node whose value on some bar changed once later
+// bars became available, i.e. the node peeks into the future.
+type LookaheadFinding struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	// Source is true when none of the node's inputs changed, so the leak
+	// starts here rather than being inherited.
+	Source bool `json:"source"`
+	// Checkpoint is the prefix length (in bars) of the first run that disagreed,
+	// Bar the first bar that differed and Full/Prefix the two values there.
+	Checkpoint int `json:"checkpoint"`
+	Bar        int `json:"bar"`
+	Full       any `json:"full"`
+	Prefix     any `json:"prefix"`
+	// Mismatches counts differing bars over all checkpoints.
+	Mismatches int `json:"mismatches"`
+}
+
+type LookaheadReport struct {
+	Bars        int                `json:"bars"`
+	Checkpoints []int              `json:"checkpoints"`
+	Findings    []LookaheadFinding `json:"findings"`
+	// Declared lists nodes whose spec is marked FutureRef.
+	Declared []string `json:"declared,omitempty"`
+	Clean    bool     `json:"clean"`
+}
+
+// AuditLookahead evaluates pl on the full data in ctx, then again on
+// truncated prefixes of it, and reports every node whose value on a bar inside
+// the prefix differs from the full run. A causal node computes the same value
+// for bar i whether or not bars after i exist.
+//
+// ctx holds the base candle series and is used for the full run.
+// Higher timeframe loads in the prefix runs only see candles that had closed
+// by the end of the prefix.
+func AuditLookahead(ctx *EvalCtx, pl *Plan, checkpoints int) (*LookaheadReport, error) {
+	base := ctx.GetCache()
+	for _, node := range pl.Order {
+		delete(base, node.ID) // only candle series are truncated
+	}
+	n := len(base["close"])
+
+	full := NewRuntime(ctx)
+	if _, err := full.ExecPlan(pl); err != nil {
+		return nil, err
+	}
+
+	rep := &LookaheadReport{Bars: n, Checkpoints: auditCheckpoints(n, pl.Warmup, checkpoints)}
+	for _, node := range pl.Order {
+		if isFutureRef(ctx.Reg, node) {
+			rep.Declared = append(rep.Declared, node.Label())
+		}
+	}
+
+	found := map[string]*LookaheadFinding{}
+	for _, k := range rep.Checkpoints {
+		pctx := NewEvalCtx(ctx.Symbol, ctx.BaseTF, ctx.Data, ctx.Reg)
+		pctx.Policy = ctx.Policy
+		prefix := make(map[string]Series, len(base))
+		for key, s := range base {
+			if len(s) == n {
+				prefix[key] = s[:k:k]
+			}
+		}
+		pctx.SetCache(prefix)
+		if ts := prefix["time"]; len(ts) > 0 && ctx.Data != nil {
+			end := time.Unix(int64(ts[k-1]), 0).Add(time.Duration(domain.TimeframeToMinutes[ctx.BaseTF]) * time.Minute)
+			pctx.Data = closedBarsProvider{inner: ctx.Data, until: end}
+		}
+
+		rt := NewRuntime(pctx)
+		rt.Workers = 1
+		if _, err := rt.ExecPlan(pl); err != nil {
+			return nil, err
+		}
+		for _, node := range pl.Order {
+			bar, fv, pv, count := compareNode(ctx, pctx, node, k)
+			if count == 0 {
+				continue
+			}
+			f := found[node.ID]
+			if f == nil {
+				f = &LookaheadFinding{ID: node.ID, Label: node.Label(), Checkpoint: k, Bar: bar, Full: fv, Prefix: pv}
+				found[node.ID] = f
+			}
+			f.Mismatches += count
+		}
+	}
+
+	for _, node := range pl.Order {
+		f := found[node.ID]
+		if f == nil {
+			continue
+		}
+		f.Source = true
+		for _, d := range node.Deps {
+			if found[d.ID] != nil {
+				f.Source = false
+			}
+		}
+		rep.Findings = append(rep.Findings, *f)
+	}
+	rep.Clean = len(rep.Findings) == 0 && len(rep.Declared) == 0
+	return rep, nil
+}
+
+// auditCheckpoints spreads up to want prefix lengths between the end of the
+// warm-up and the last bar.
+func auditCheckpoints(n, warmup, want int) []int {
+	if want <= 0 {
+		want = 20
+	}
+	lo := maxInt(warmup+1, 2)
+	if lo >= n {
+		return nil
+	}
+	span := n - lo
+	want = minInt(want, span)
+	out := make([]int, 0, want)
+	for i := 0; i < want; i++ {
+		k := lo + i*span/want
+		if len(out) == 0 || k != out[len(out)-1] {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// compareNode compares a node's first k values between the full and prefix
+// runs. It returns the first differing bar with both values, and how many
+// bars differ.
+func compareNode(full, prefix *EvalCtx, node *PlanNode, k int) (int, any, any, int) {
+	first, count := -1, 0
+	var fv, pv any
+	if node.Kind == NodeBool {
+		fs, _ := full.BoolOf(node.ID)
+		ps, _ := prefix.BoolOf(node.ID)
+		for i := 0; i < k && i < len(fs); i++ {
+			p := i < len(ps) && ps[i]
+			if fs[i] != p {
+				if first < 0 {
+					first, fv, pv = i, fs[i], p
+				}
+				count++
+			}
+		}
+		return first, fv, pv, count
+	}
+	fs, _ := full.SeriesOf(node.ID)
+	ps, _ := prefix.SeriesOf(node.ID)
+	for i := 0; i < k && i < len(fs); i++ {
+		p := math.NaN()
+		if i < len(ps) {
+			p = ps[i]
+		}
+		if !sameValue(fs[i], p) {
+			if first < 0 {
+				first, fv, pv = i, jsonFloat(fs[i]), jsonFloat(p)
+			}
+			count++
+		}
+	}
+	return first, fv, pv, count
+}
+
+func sameValue(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(a))
+}
+
+// jsonFloat maps NaN/Inf, which JSON cannot carry, to nil.
+func jsonFloat(v float64) any {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return v
+}
+
+func isFutureRef(reg *Registry, n *PlanNode) bool {
+	if reg == nil {
+		return false
+	}
+	name, _ := n.Meta["name"].(string)
+	switch n.Op {
+	case "indicator":
+		return reg.Indicators[name].FutureRef
+	case "function":
+		return reg.Functions[name].FutureRef
+	}
+	return false
+}
+
+// closedBarsProvider hides candles that had not closed by until, so a prefix
+// run cannot see the final values of a higher timeframe bar still in progress.
+type closedBarsProvider struct {
+	inner DataProvider
+	until time.Time
+}
+
+func (p closedBarsProvider) LoadOHLCV(symbol string, tf domain.Timeframe) ([]domain.Candle, error) {
+	candles, err := p.inner.LoadOHLCV(symbol, tf)
+	if err != nil {
+		return nil, err
+	}
+	d := time.Duration(domain.TimeframeToMinutes[tf]) * time.Minute
+	k := 0
+	for k < len(candles) && !candles[k].Time.Add(d).After(p.until) {
+		k++
+	}
+	return candles[:k:k], nil
+}
+
+// Expiries are calendar data known in advance, so they are not truncated.
+func (p closedBarsProvider) Expiries(symbol string) ([]time.Time, error) {
+	ep, ok := p.inner.(ExpiryProvider)
+	if !ok {
+		return nil, errNoExpiries
+	}
+	return ep.Expiries(symbol)
+}
+
+func (p closedBarsProvider) AlignTo(baseTF domain.Timeframe, ser Series, fromTF domain.Timeframe) (Series, error) {
+	return p.inner.AlignTo(baseTF, ser, fromTF)
+}
@@ -0,0 +1,112 @@
+// engine/lru.go
+package engine
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// LRU is a size-bounded, concurrency-safe least-recently-used cache.
+// Sizes are caller-estimated bytes; the cache evicts from the cold end until
+// the total fits in MaxBytes.
+type LRU struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ll       *list.List
+	items    map[string]*list.Element
+
+	hits, misses, evictions, invalidations int64
+}
+
+type lruEntry struct {
+	key  string
+	val  any
+	size int64
+}
+
+// CacheStats is a point-in-time snapshot of LRU counters.
+type CacheStats struct {
+	Entries       int   `json:"entries"`
+	Bytes         int64 `json:"bytes"`
+	MaxBytes      int64 `json:"max_bytes"`
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Evictions     int64 `json:"evictions"`
+	Invalidations int64 `json:"invalidations"`
+}
+
+func NewLRU(maxBytes int64) *LRU {
+	return &LRU{maxBytes: maxBytes, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *LRU) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		c.hits++
+		return el.Value.(*lruEntry).val, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// Add stores val under key. Values larger than the whole cache are dropped.
+func (c *LRU) Add(key string, val any, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if size > c.maxBytes {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*lruEntry)
+		c.bytes += size - e.size
+		e.val, e.size = val, size
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&lruEntry{key: key, val: val, size: size})
+		c.bytes += size
+	}
+	for c.bytes > c.maxBytes {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+// RemovePrefix drops every entry whose key starts with prefix and returns how many were removed.
+func (c *LRU) RemovePrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for k, el := range c.items {
+		if strings.HasPrefix(k, prefix) {
+			c.removeElement(el)
+			n++
+		}
+	}
+	c.invalidations += int64(n)
+	return n
+}
+
+func (c *LRU) removeElement(el *list.Element) {
+	e := el.Value.(*lruEntry)
+	c.ll.Remove(el)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
+
+func (c *LRU) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries:       len(c.items),
+		Bytes:         c.bytes,
+		MaxBytes:      c.maxBytes,
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+		Invalidations: c.invalidations,
+	}
+}
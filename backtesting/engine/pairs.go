@@ -0,0 +1,278 @@
+// engine/pairs.go
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// Pair series relate the base symbol (the first leg, y) to a second symbol
+// (x) loaded alongside it; see EvalCtx.SetPair.
+
+var errNoPair = errors.New("pair series need a second symbol (pair backtests only)")
+
+// AlignCloses maps other onto the bars of base: each base bar gets the close
+// of the last other candle starting at or before it, NaN before the first.
+func AlignCloses(base, other []domain.Candle) Series {
+	out := make(Series, len(base))
+	for i, b := range base {
+		k := sort.Search(len(other), func(k int) bool { return other[k].Time.After(b.Time) }) - 1
+		if k < 0 {
+			out[i] = math.NaN()
+			continue
+		}
+		out[i] = other[k].Close
+	}
+	return out
+}
+
+// LoadPairCloses loads symbol on tf, with the same warm-up as the base
+// candles where the provider supports it, and aligns it to base.
+func LoadPairCloses(dp DataProvider, symbol string, tf domain.Timeframe, warmup int, base []domain.Candle) (Series, error) {
+	var other []domain.Candle
+	var err error
+	if wl, ok := dp.(WarmupLoader); ok {
+		// the prepended count is base's to trim: closes are aligned to base
+		// by time, warm-up bars included
+		other, _, err = wl.LoadOHLCVWithWarmup(symbol, tf, warmup)
+	} else {
+		other, err = dp.LoadOHLCV(symbol, tf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return AlignCloses(base, other), nil
+}
+
+// pairStream is AlignCloses one base bar at a time, for streams: it loads
+// the second symbol when it starts and again, at most once per bar, when a
+// bar is past the last candle it has.
+type pairStream struct {
+	dp       DataProvider
+	symbol   string
+	tf       domain.Timeframe
+	candles  []domain.Candle
+	k        int // the last candle at or before the previous bar, -1 before the first
+	loadedAt time.Time
+}
+
+// newPairStream is nil when ctx has no second symbol to load, leaving the
+// stream to fail as Eval does.
+func newPairStream(ctx *EvalCtx) *pairStream {
+	if ctx.PairSymbol == "" || ctx.Data == nil {
+		return nil
+	}
+	candles, err := ctx.Data.LoadOHLCV(ctx.PairSymbol, ctx.BaseTF)
+	if err != nil {
+		return nil
+	}
+	return &pairStream{dp: ctx.Data, symbol: ctx.PairSymbol, tf: ctx.BaseTF, candles: candles, k: -1}
+}
+
+func (ps *pairStream) close(t time.Time) float64 {
+	if n := len(ps.candles); (n == 0 || ps.candles[n-1].Time.Before(t)) && ps.loadedAt.Before(t) {
+		ps.loadedAt = t
+		if candles, err := ps.dp.LoadOHLCV(ps.symbol, ps.tf); err == nil {
+			ps.candles = candles
+			ps.k = sort.Search(len(candles), func(k int) bool { return candles[k].Time.After(t) }) - 1
+		}
+	}
+	for ps.k+1 < len(ps.candles) && !ps.candles[ps.k+1].Time.After(t) {
+		ps.k++
+	}
+	if ps.k < 0 {
+		return math.NaN()
+	}
+	return ps.candles[ps.k].Close
+}
+
+func ctxPair(ctx *EvalCtx) (y, x Series, err error) {
+	x = ctx.series("pair_close")
+	if ctx.PairSymbol == "" || x == nil {
+		return nil, nil, errNoPair
+	}
+	return ctx.series("close"), x, nil
+}
+
+// olsFit fits y = alpha + beta*x by least squares.
+func olsFit(yw, xw []float64) (alpha, beta float64) {
+	cov, vx, _ := covariance(xw, yw)
+	if vx == 0 {
+		return math.NaN(), math.NaN()
+	}
+	var my, mx float64
+	for i := range yw {
+		my, mx = my+yw[i], mx+xw[i]
+	}
+	n := float64(len(yw))
+	beta = cov / vx
+	return my/n - beta*mx/n, beta
+}
+
+// HedgeRatio is the rolling OLS slope of y on x over p bars: holding one
+// unit of y against HedgeRatio units of x leaves the spread.
+func HedgeRatio(y, x []float64, p int) []float64 { return rolling2(y, x, p, hedgeOf) }
+
+func hedgeOf(yw, xw []float64) float64 { _, b := olsFit(yw, xw); return b }
+
+// PairSpread is y - beta*x with beta the rolling hedge ratio.
+func PairSpread(y, x []float64, p int) []float64 {
+	beta := HedgeRatio(y, x, p)
+	out := make([]float64, len(beta))
+	for i := range beta {
+		out[i] = y[i] - beta[i]*x[i]
+	}
+	return out
+}
+
+// EngleGranger is the rolling Engle-Granger cointegration statistic: the
+// Dickey-Fuller t-statistic (no constant, no lags) of the residuals of the
+// OLS fit of y on x over p bars. More negative means more strongly
+// cointegrated; the two-variable critical values are about -3.90 (1%),
+// -3.34 (5%) and -3.04 (10%).
+func EngleGranger(y, x []float64, p int) []float64 { return rolling2(y, x, p, engleGrangerOf) }
+
+func engleGrangerOf(yw, xw []float64) float64 {
+	alpha, beta := olsFit(yw, xw)
+	if math.IsNaN(beta) || len(yw) < 4 {
+		return math.NaN()
+	}
+	e := make([]float64, len(yw))
+	for i := range yw {
+		e[i] = yw[i] - alpha - beta*xw[i]
+	}
+	// regress de[t] = g*e[t-1] + u[t]
+	var see, sde float64
+	for t := 1; t < len(e); t++ {
+		see += e[t-1] * e[t-1]
+		sde += e[t-1] * (e[t] - e[t-1])
+	}
+	if see == 0 {
+		return math.NaN()
+	}
+	g := sde / see
+	var ssr float64
+	for t := 1; t < len(e); t++ {
+		u := e[t] - e[t-1] - g*e[t-1]
+		ssr += u * u
+	}
+	se := math.Sqrt(ssr / float64(len(e)-2) / see)
+	if se == 0 {
+		return math.NaN()
+	}
+	return g / se
+}
+
+// pairWindowStep feeds y and x to a windowStep of p bars over f.
+func pairWindowStep(p int, f func(yw, xw []float64) float64) func(y, x float64) float64 {
+	step := windowStep(p, 2, func(w ...[]float64) float64 { return f(w[0], w[1]) })
+	vals := make([]float64, 2)
+	return func(y, x float64) float64 {
+		vals[0], vals[1] = y, x
+		return step(vals)
+	}
+}
+
+// spreadStep is PairSpread one bar at a time.
+func spreadStep(p int) func(y, x float64) float64 {
+	hedge := pairWindowStep(p, hedgeOf)
+	return func(y, x float64) float64 { return y - hedge(y, x)*x }
+}
+
+func registerPairs(reg *Registry) {
+	// every param is a window length of at least two bars
+	pair := func(name, desc string, specs []ArgSpec, lookback func(params map[string]float64) int,
+		f func(y, x Series, params map[string]float64) Series,
+		step func(params map[string]float64) func(y, x float64) float64) IndicatorSpec {
+		return IndicatorSpec{
+			Category:    "Pair",
+			Description: desc,
+			Params:      specs,
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe,
+				params map[string]float64, offset int, args ...Series) ([]float64, error) {
+				for _, a := range specs {
+					if v, ok := params[a.Name]; ok && v < 2 {
+						return nil, fmt.Errorf("%s %s must be at least 2", name, a.Name)
+					}
+				}
+				y, x, err := ctxPair(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return shiftBack(f(y, x, params), offset)
+			},
+			Lookback: lookback,
+			Stream: func(ctx *EvalCtx, params map[string]float64) IndicatorStep {
+				for _, a := range specs {
+					if v, ok := params[a.Name]; ok && v < 2 {
+						return nil
+					}
+				}
+				ps := newPairStream(ctx)
+				if ps == nil {
+					return nil
+				}
+				st := step(params)
+				return func(bar domain.Candle, _ []float64) float64 { return st(bar.Close, ps.close(bar.Time)) }
+			},
+		}
+	}
+	period := []ArgSpec{{Name: "period", Type: "int", Req: true}}
+	window := func(params map[string]float64) int { return int(params["period"]) - 1 }
+
+	ratio := func(y, x float64) float64 {
+		if x == 0 {
+			return math.NaN()
+		}
+		return y / x
+	}
+	reg.Indicators["PairClose"] = pair("PairClose", "Close of the pair's second symbol", nil, nil,
+		func(_, x Series, _ map[string]float64) Series { return x },
+		func(map[string]float64) func(y, x float64) float64 { return func(_, x float64) float64 { return x } })
+	reg.Indicators["PairRatio"] = pair("PairRatio", "Price ratio of the symbol to the pair's second symbol", nil, nil,
+		func(y, x Series, _ map[string]float64) Series {
+			out := make(Series, len(y))
+			for i := range y {
+				out[i] = ratio(y[i], x[i])
+			}
+			return out
+		},
+		func(map[string]float64) func(y, x float64) float64 { return ratio })
+	reg.Indicators["PairHedge"] = pair("PairHedge", "Rolling OLS hedge ratio of the symbol on the second symbol", period, window,
+		func(y, x Series, params map[string]float64) Series { return HedgeRatio(y, x, int(params["period"])) },
+		func(params map[string]float64) func(y, x float64) float64 {
+			return pairWindowStep(int(params["period"]), hedgeOf)
+		})
+	reg.Indicators["PairSpread"] = pair("PairSpread", "Symbol minus hedge ratio times the second symbol", period, window,
+		func(y, x Series, params map[string]float64) Series { return PairSpread(y, x, int(params["period"])) },
+		func(params map[string]float64) func(y, x float64) float64 { return spreadStep(int(params["period"])) })
+	reg.Indicators["PairZScore"] = pair("PairZScore", "Z-score of the pair spread over z bars (default period)",
+		[]ArgSpec{{Name: "period", Type: "int", Req: true}, {Name: "z", Type: "int"}},
+		func(params map[string]float64) int {
+			p := int(params["period"])
+			return p - 1 + int(floatParam(params, "z", float64(p))) - 1
+		},
+		func(y, x Series, params map[string]float64) Series {
+			p := int(params["period"])
+			return ZScore(PairSpread(y, x, p), int(floatParam(params, "z", float64(p))))
+		},
+		func(params map[string]float64) func(y, x float64) float64 {
+			p := int(params["period"])
+			spread, z := spreadStep(p), sumsStep(int(floatParam(params, "z", float64(p))), (*windowSums).zscore)
+			vals := make([]float64, 1)
+			return func(y, x float64) float64 {
+				vals[0] = spread(y, x)
+				return z(vals)
+			}
+		})
+	reg.Indicators["PairCoint"] = pair("PairCoint", "Engle-Granger cointegration t-statistic over period bars (< -3.34 at 5%)", period, window,
+		func(y, x Series, params map[string]float64) Series { return EngleGranger(y, x, int(params["period"])) },
+		func(params map[string]float64) func(y, x float64) float64 {
+			return pairWindowStep(int(params["period"]), engleGrangerOf)
+		})
+}
@@ -0,0 +1,212 @@
+// engine/patterns.go
+package engine
+
+import (
+	"math"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// Candlestick pattern predicates. Each detector marks the bar that completes
+// the pattern. Tolerances are fractions of the bar range or body as noted on
+// the params; every param is optional.
+
+func bodyLen(c domain.Candle) float64   { return math.Abs(c.Close - c.Open) }
+func barRange(c domain.Candle) float64  { return c.High - c.Low }
+func upperWick(c domain.Candle) float64 { return c.High - math.Max(c.Open, c.Close) }
+func lowerWick(c domain.Candle) float64 { return math.Min(c.Open, c.Close) - c.Low }
+func bullish(c domain.Candle) bool      { return c.Close > c.Open }
+func bearish(c domain.Candle) bool      { return c.Close < c.Open }
+func bodyTop(c domain.Candle) float64   { return math.Max(c.Open, c.Close) }
+func bodyBot(c domain.Candle) float64   { return math.Min(c.Open, c.Close) }
+
+type patternFunc func(bars []domain.Candle, i int, p patternParams) bool
+
+// patternParams reads a param with a default.
+type patternParams map[string]float64
+
+func (p patternParams) get(k string, def float64) float64 {
+	if v, ok := p[k]; ok {
+		return v
+	}
+	return def
+}
+
+func doji(bars []domain.Candle, i int, p patternParams) bool {
+	c := bars[i]
+	return barRange(c) > 0 && bodyLen(c) <= p.get("body_pct", 0.1)*barRange(c)
+}
+
+// hammer: small body near the top, long lower shadow, little upper shadow.
+func hammer(bars []domain.Candle, i int, p patternParams) bool {
+	c := bars[i]
+	r := barRange(c)
+	return r > 0 && bodyLen(c) <= p.get("body_pct", 0.35)*r &&
+		lowerWick(c) >= p.get("wick_ratio", 2)*bodyLen(c) &&
+		upperWick(c) <= p.get("shadow_pct", 0.1)*r
+}
+
+func shootingStar(bars []domain.Candle, i int, p patternParams) bool {
+	c := bars[i]
+	r := barRange(c)
+	return r > 0 && bodyLen(c) <= p.get("body_pct", 0.35)*r &&
+		upperWick(c) >= p.get("wick_ratio", 2)*bodyLen(c) &&
+		lowerWick(c) <= p.get("shadow_pct", 0.1)*r
+}
+
+// engulfs reports whether c's body covers prev's body, allowing tol of prev's body.
+func engulfs(c, prev domain.Candle, tol float64) bool {
+	slack := tol * bodyLen(prev)
+	return bodyTop(c) >= bodyTop(prev)-slack && bodyBot(c) <= bodyBot(prev)+slack && bodyLen(c) > bodyLen(prev)
+}
+
+func bullishEngulfing(bars []domain.Candle, i int, p patternParams) bool {
+	c, prev := bars[i], bars[i-1]
+	return bearish(prev) && bullish(c) && engulfs(c, prev, p.get("tol", 0))
+}
+
+func bearishEngulfing(bars []domain.Candle, i int, p patternParams) bool {
+	c, prev := bars[i], bars[i-1]
+	return bullish(prev) && bearish(c) && engulfs(c, prev, p.get("tol", 0))
+}
+
+// inside reports whether c's body lies within prev's body, allowing tol of prev's body.
+func insideBody(c, prev domain.Candle, tol float64) bool {
+	slack := tol * bodyLen(prev)
+	return bodyTop(c) <= bodyTop(prev)+slack && bodyBot(c) >= bodyBot(prev)-slack && bodyLen(c) < bodyLen(prev)
+}
+
+func bullishHarami(bars []domain.Candle, i int, p patternParams) bool {
+	c, prev := bars[i], bars[i-1]
+	return bearish(prev) && bullish(c) && insideBody(c, prev, p.get("tol", 0))
+}
+
+func bearishHarami(bars []domain.Candle, i int, p patternParams) bool {
+	c, prev := bars[i], bars[i-1]
+	return bullish(prev) && bearish(c) && insideBody(c, prev, p.get("tol", 0))
+}
+
+// star checks the three-bar reversal shared by morning and evening stars: a
+// small middle body, and a third bar that closes past penetration of the first body.
+func star(bars []domain.Candle, i int, p patternParams, up bool) bool {
+	a, b, c := bars[i-2], bars[i-1], bars[i]
+	if bodyLen(b) > p.get("star_pct", 0.3)*bodyLen(a) {
+		return false
+	}
+	pen := p.get("penetration", 0.5)
+	if up {
+		return bearish(a) && bullish(c) && c.Close >= a.Close+pen*bodyLen(a)
+	}
+	return bullish(a) && bearish(c) && c.Close <= a.Close-pen*bodyLen(a)
+}
+
+func morningStar(bars []domain.Candle, i int, p patternParams) bool {
+	return star(bars, i, p, true)
+}
+
+func eveningStar(bars []domain.Candle, i int, p patternParams) bool {
+	return star(bars, i, p, false)
+}
+
+// threeWhiteSoldiers: three rising bullish bars, each opening inside the prior
+// body and closing near its high.
+func threeWhiteSoldiers(bars []domain.Candle, i int, p patternParams) bool {
+	minBody, shadow := p.get("body_pct", 0.5), p.get("shadow_pct", 0.3)
+	for j := i - 2; j <= i; j++ {
+		c := bars[j]
+		if !bullish(c) || bodyLen(c) < minBody*barRange(c) || upperWick(c) > shadow*barRange(c) {
+			return false
+		}
+		if j > i-2 {
+			prev := bars[j-1]
+			if c.Close <= prev.Close || c.Open < prev.Open || c.Open > prev.Close {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// insideBar and outsideBar compare full ranges; tol is a fraction of the prior range.
+func insideBar(bars []domain.Candle, i int, p patternParams) bool {
+	c, prev := bars[i], bars[i-1]
+	slack := p.get("tol", 0) * barRange(prev)
+	return c.High <= prev.High+slack && c.Low >= prev.Low-slack
+}
+
+func outsideBar(bars []domain.Candle, i int, p patternParams) bool {
+	c, prev := bars[i], bars[i-1]
+	slack := p.get("tol", 0) * barRange(prev)
+	return c.High >= prev.High-slack && c.Low <= prev.Low+slack
+}
+
+// narrowRange: the bar's range is the narrowest of the last n (within tol).
+func narrowRange(n int) patternFunc {
+	return func(bars []domain.Candle, i int, p patternParams) bool {
+		r := barRange(bars[i]) * (1 - p.get("tol", 0))
+		for j := i - n + 1; j < i; j++ {
+			if barRange(bars[j]) < r {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// evalPattern runs detect on every bar with enough history, then applies offset.
+func evalPattern(bars []domain.Candle, need int, detect patternFunc, params map[string]float64, offset int) (BoolSeries, error) {
+	out := make(BoolSeries, len(bars))
+	for i := need; i < len(bars); i++ {
+		out[i] = detect(bars, i, params)
+	}
+	return shiftBool(out, offset)
+}
+
+func registerPatterns(reg *Registry) {
+	tol := []ArgSpec{{Name: "tol", Type: "float"}}
+	add := func(name, desc string, need int, params []ArgSpec, detect patternFunc) {
+		reg.Predicates[name] = PredicateSpec{
+			Category:    "Pattern",
+			Description: desc,
+			Params:      params,
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe, params map[string]float64, offset int) (BoolSeries, error) {
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				return evalPattern(bars, need, detect, params, offset)
+			},
+			Lookback: func(map[string]float64) int { return need },
+			Stream: func(_ *EvalCtx, params map[string]float64) PredicateStep {
+				// the last need+1 bars, oldest first
+				win := make([]domain.Candle, 0, need+1)
+				return func(bar domain.Candle) bool {
+					if len(win) == need+1 {
+						win = append(win[:0], win[1:]...)
+					}
+					win = append(win, bar)
+					return len(win) == need+1 && detect(win, need, params)
+				}
+			},
+		}
+	}
+
+	add("Doji", "Open and close nearly equal (body <= body_pct of range, default 0.1)", 0,
+		[]ArgSpec{{Name: "body_pct", Type: "float"}}, doji)
+	wicks := []ArgSpec{{Name: "body_pct", Type: "float"}, {Name: "wick_ratio", Type: "float"}, {Name: "shadow_pct", Type: "float"}}
+	add("Hammer", "Small body on top of a lower shadow >= wick_ratio x body (default 2)", 0, wicks, hammer)
+	add("ShootingStar", "Small body under an upper shadow >= wick_ratio x body (default 2)", 0, wicks, shootingStar)
+	add("BullishEngulfing", "Bullish body engulfs the prior bearish body (tol of prior body)", 1, tol, bullishEngulfing)
+	add("BearishEngulfing", "Bearish body engulfs the prior bullish body (tol of prior body)", 1, tol, bearishEngulfing)
+	add("BullishHarami", "Bullish body inside the prior bearish body (tol of prior body)", 1, tol, bullishHarami)
+	add("BearishHarami", "Bearish body inside the prior bullish body (tol of prior body)", 1, tol, bearishHarami)
+	stars := []ArgSpec{{Name: "star_pct", Type: "float"}, {Name: "penetration", Type: "float"}}
+	add("MorningStar", "Bearish bar, small star (<= star_pct of first body), bullish close past penetration", 2, stars, morningStar)
+	add("EveningStar", "Bullish bar, small star (<= star_pct of first body), bearish close past penetration", 2, stars, eveningStar)
+	add("ThreeWhiteSoldiers", "Three rising bullish bars with bodies >= body_pct of range", 2,
+		[]ArgSpec{{Name: "body_pct", Type: "float"}, {Name: "shadow_pct", Type: "float"}}, threeWhiteSoldiers)
+	add("InsideBar", "Range inside the prior range (tol of prior range)", 1, tol, insideBar)
+	add("OutsideBar", "Range covers the prior range (tol of prior range)", 1, tol, outsideBar)
+	add("NR4", "Narrowest range of the last 4 bars", 3, tol, narrowRange(4))
+	add("NR7", "Narrowest range of the last 7 bars", 6, tol, narrowRange(7))
+}
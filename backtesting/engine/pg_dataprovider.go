@@ -16,7 +16,43 @@ type PGProvider struct {
 
 func NewPGProvider(db *gorm.DB) *PGProvider { return &PGProvider{db: db} }
 
+// Backtest data range loaded by LoadOHLCV.
+const (
+	pgRangeFrom = "2025-01-01"
+	pgRangeTo   = "2025-08-01"
+)
+
 func (p *PGProvider) LoadOHLCV(symbol string, tf domain.Timeframe) ([]domain.Candle, error) {
+	return p.loadRange(symbol, tf, pgRangeFrom, pgRangeTo)
+}
+
+// LoadOHLCVWithWarmup loads the usual range plus up to warmup bars before it.
+// Sessions are SessionMinutes long; the calendar span is padded for weekends
+// and holidays and the surplus trimmed off.
+func (p *PGProvider) LoadOHLCVWithWarmup(symbol string, tf domain.Timeframe, warmup int) ([]domain.Candle, int, error) {
+	if warmup <= 0 {
+		candles, err := p.LoadOHLCV(symbol, tf)
+		return candles, 0, err
+	}
+	from, err := time.Parse("2006-01-02", pgRangeFrom)
+	if err != nil {
+		return nil, 0, err
+	}
+	sessions := (warmup*domain.TimeframeToMinutes[tf])/SessionMinutes + 1
+	days := sessions*7/5 + 7
+	candles, err := p.loadRange(symbol, tf, from.AddDate(0, 0, -days).Format("2006-01-02"), pgRangeTo)
+	if err != nil {
+		return nil, 0, err
+	}
+	start := 0
+	for start < len(candles) && candles[start].Time.Before(from) {
+		start++
+	}
+	skip := maxInt(0, start-warmup)
+	return candles[skip:], start - skip, nil
+}
+
+func (p *PGProvider) loadRange(symbol string, tf domain.Timeframe, from, to string) ([]domain.Candle, error) {
 	startTime := time.Now()
 	interval, found := domain.TimeframeToMinutes[tf]
 
@@ -24,12 +60,11 @@ func (p *PGProvider) LoadOHLCV(symbol string, tf domain.Timeframe) ([]domain.Can
 		return nil, fmt.Errorf("timeframe %q not supported", tf)
 	}
 
-	// NOTE: consider making start/end times function arguments instead of hardcoding strings.
 	rows, err := p.db.Raw(`
 		WITH src AS (
 		  SELECT *
 		  FROM public.ohlc_data_nse_eq
-		  WHERE (time::time >= time '09:15:00' AND time::time <= time '15:30:00'
+		  WHERE (time::time >= ?::time AND time::time <= ?::time
 		         AND ticker = ? AND "time" > ? AND "time" < ?)
 		),
 		annot AS (
@@ -37,8 +72,8 @@ func (p *PGProvider) LoadOHLCV(symbol string, tf domain.Timeframe) ([]domain.Can
 		    ticker,
 		    time,
 		    open, high, low, close, volume, oi,
-		    (date_trunc('day', time) + interval '9 hours 15 minutes') AS session_open,
-		    EXTRACT(EPOCH FROM (time - (date_trunc('day', time) + interval '9 hours 15 minutes'))) AS secs_since_open
+		    (date_trunc('day', time) + ?::time) AS session_open,
+		    EXTRACT(EPOCH FROM (time - (date_trunc('day', time) + ?::time))) AS secs_since_open
 		  FROM src
 		),
 		buckets AS (
@@ -61,7 +96,7 @@ func (p *PGProvider) LoadOHLCV(symbol string, tf domain.Timeframe) ([]domain.Can
 		FROM buckets
 		GROUP BY session_open, bucket_no
 		ORDER BY bucket_start;
-	`, symbol, "2025-01-01", "2025-08-01", interval, interval).Rows()
+	`, SessionOpen, SessionClose, symbol, from, to, SessionOpen, SessionOpen, interval, interval).Rows()
 
 	if err != nil {
 		return nil, err
@@ -93,6 +128,35 @@ func (p *PGProvider) LoadOHLCV(symbol string, tf domain.Timeframe) ([]domain.Can
 	return candles, nil
 }
 
+// LatestTime returns the timestamp of the newest stored 1m candle for symbol.
+func (p *PGProvider) LatestTime(symbol string) (time.Time, error) {
+	var latest *time.Time
+	err := p.db.Raw(`SELECT MAX("time") FROM public.ohlc_data_nse_eq WHERE ticker = ?`, symbol).
+		Row().Scan(&latest)
+	if err != nil || latest == nil {
+		return time.Time{}, err
+	}
+	return *latest, nil
+}
+
+// LoadSince returns the tf bars built from the 1m candles stored after
+// after, up to the newest. The last bar may still be forming.
+func (p *PGProvider) LoadSince(symbol string, tf domain.Timeframe, after time.Time) ([]domain.Candle, error) {
+	return p.loadRange(symbol, tf, after.Format("2006-01-02 15:04:05"), "infinity")
+}
+
+// Expiries returns the distinct expiry dates stored for symbol.
+func (p *PGProvider) Expiries(symbol string) ([]time.Time, error) {
+	var dates []time.Time
+	err := p.db.Raw(`
+		SELECT DISTINCT ticker_expiries.expiry_date
+		FROM ticker_expiries
+		JOIN tickers ON ticker_expiries.ticker_id = tickers.id
+		WHERE tickers.ticker_symbol = ?
+		ORDER BY ticker_expiries.expiry_date`, symbol).Scan(&dates).Error
+	return dates, err
+}
+
 func (p *PGProvider) AlignTo(baseTF domain.Timeframe, ser Series, fromTF domain.Timeframe) (Series, error) {
 	// Simplest approach: if fromTF is higher than baseTF, forward-fill each base bar within the same higher-timeframe window.
 	// If fromTF is lower than baseTF, resample by last value within the base bar boundary.
@@ -4,6 +4,9 @@ package engine
 import (
 	"fmt"
 	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
 
 	domain "github.com/gulll/deepmarket/backtesting/domain"
 )
@@ -28,6 +31,52 @@ type PlanNode struct {
 type Plan struct {
 	Roots []*PlanNode
 	Order []*PlanNode // topological order
+	// Warmup is the number of leading base bars on which the roots are not yet
+	// reliable because some input is still warming up.
+	Warmup int
+}
+
+// Label renders a short human readable name for the node, e.g. "RSI[5m](period=14)".
+func (n *PlanNode) Label() string {
+	switch n.Op {
+	case "const", "bconst":
+		return fmt.Sprint(n.Meta["value"])
+	case "indicator", "pattern":
+		label := fmt.Sprintf("%v[%v]", n.Meta["name"], n.Meta["tf"])
+		if ps, _ := n.Meta["params"].(map[string]float64); len(ps) > 0 {
+			label += "(" + formatParams(ps) + ")"
+		}
+		if off, _ := n.Meta["offset"].(int); off != 0 {
+			label += fmt.Sprintf("[-%d]", off)
+		}
+		return label
+	case "function":
+		ps, _ := n.Meta["params"].(map[string]any)
+		return fmt.Sprintf("%v(%s)", n.Meta["name"], formatParams(ps))
+	case "sequence":
+		return fmt.Sprintf("sequence(gaps=%v,restart=%v)", n.Meta["gaps"], n.Meta["restart"])
+	case "held_for", "within", "count_true":
+		return fmt.Sprintf("%s(n=%v)", n.Op, n.Meta["n"])
+	}
+	return n.Op
+}
+
+// formatParams renders params sorted by key so labels are stable.
+func formatParams[V any](ps map[string]V) string {
+	keys := make([]string, 0, len(ps))
+	for k := range ps {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if f, ok := any(ps[k]).(float64); ok {
+			parts = append(parts, k+"="+formatNumber(f))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", k, ps[k]))
+	}
+	return strings.Join(parts, ",")
 }
 
 // hashKey creates a stable-ish key
@@ -42,67 +91,190 @@ func hashKey(parts ...any) string {
 // Planner turns AST into a DAG with alignment & CSE
 type Planner struct {
 	baseTF domain.Timeframe
+	reg    *Registry // for spec lookbacks; may be nil
 	cache  map[string]*PlanNode
 }
 
-func NewPlanner(baseTF domain.Timeframe) *Planner {
-	return &Planner{baseTF: baseTF, cache: map[string]*PlanNode{}}
+func NewPlanner(baseTF domain.Timeframe, reg *Registry) *Planner {
+	return &Planner{baseTF: baseTF, reg: reg, cache: map[string]*PlanNode{}}
+}
+
+// indicatorLookback is the warm-up of an indicator node in base bars,
+// including its offset. Bars of a higher timeframe count as several base bars.
+func (p *Planner) indicatorLookback(v domain.IndicatorNode) int {
+	lb := v.Offset
+	if p.reg != nil {
+		if spec, ok := p.reg.Indicators[v.Name]; ok && spec.Lookback != nil {
+			lb += maxInt(0, spec.Lookback(v.Params))
+		}
+	}
+	return p.scaleLookback(lb, v.Timeframe)
+}
+
+func (p *Planner) patternLookback(v domain.PatternNode) int {
+	lb := v.Offset
+	if p.reg != nil {
+		if spec, ok := p.reg.Predicates[v.Name]; ok && spec.Lookback != nil {
+			lb += maxInt(0, spec.Lookback(v.Params))
+		}
+	}
+	return p.scaleLookback(lb, v.Timeframe)
+}
+
+// scaleLookback converts a lookback in bars of tf to base bars.
+func (p *Planner) scaleLookback(lb int, tf domain.Timeframe) int {
+	base, mins := domain.TimeframeToMinutes[p.baseTF], domain.TimeframeToMinutes[tf]
+	if base > 0 && mins > base {
+		lb = lb * mins / base
+	}
+	return lb
+}
+
+func (p *Planner) functionLookback(v domain.FunctionNode) int {
+	if p.reg != nil {
+		if spec, ok := p.reg.Functions[v.Name]; ok && spec.Lookback != nil {
+			return maxInt(0, spec.Lookback(v.Params))
+		}
+	}
+	return 0
+}
+
+// intern returns the cached node for key, or caches and returns mk().
+// Every node goes through here so equal subexpressions share one node.
+func (p *Planner) intern(key string, mk func() *PlanNode) *PlanNode {
+	if n, ok := p.cache[key]; ok {
+		return n
+	}
+	n := mk()
+	n.ID = key
+	p.cache[key] = n
+	return n
+}
+
+func (p *Planner) constNode(v float64) *PlanNode {
+	return p.intern(hashKey("num", v, p.baseTF), func() *PlanNode {
+		return &PlanNode{Kind: NodeSeries, Op: "const", Meta: map[string]any{"value": v}}
+	})
+}
+
+func (p *Planner) boolConstNode(v bool) *PlanNode {
+	return p.intern(hashKey("bool", v, p.baseTF), func() *PlanNode {
+		return &PlanNode{Kind: NodeBool, Op: "bconst", Meta: map[string]any{"value": v}}
+	})
+}
+
+func (p *Planner) planArgs(args []domain.ExprNode) ([]*PlanNode, []string, error) {
+	deps := make([]*PlanNode, 0, len(args))
+	ids := make([]string, 0, len(args))
+	for _, a := range args {
+		dep, err := p.planExpr(a)
+		if err != nil {
+			return nil, nil, err
+		}
+		deps = append(deps, dep)
+		ids = append(ids, dep.ID)
+	}
+	return deps, ids, nil
+}
+
+func isConst(n *PlanNode) bool { return n.Op == "const" }
+
+// ordered reports whether (l, r) is already in canonical operand order:
+// constants go right, otherwise operands are sorted by ID.
+func ordered(l, r *PlanNode) bool {
+	if isConst(l) != isConst(r) {
+		return isConst(r)
+	}
+	return l.ID <= r.ID
+}
+
+// foldMath evaluates a math op on two constants the same way the runtime does.
+func foldMath(op string, l, r float64) float64 {
+	switch op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "/":
+		if r == 0 {
+			return math.NaN()
+		}
+		return l / r
+	case "%":
+		return math.Mod(l, r)
+	case "^":
+		return math.Pow(l, r)
+	}
+	return math.NaN()
+}
+
+// foldCompare evaluates a comparison of two constants. Crosses never fire on
+// constant inputs.
+func foldCompare(op string, l, r float64, nanIsFalse bool) bool {
+	if math.IsNaN(l) || math.IsNaN(r) {
+		return !nanIsFalse
+	}
+	switch op {
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	}
+	return false
+}
+
+// flipCompare returns the operator that gives the same result with operands swapped.
+var flipCompare = map[string]string{
+	">": "<", "<": ">", ">=": "<=", "<=": ">=", "==": "==", "!=": "!=",
+	"crosses_above": "crosses_below", "crosses_below": "crosses_above",
 }
 
 func (p *Planner) planExpr(x domain.ExprNode) (*PlanNode, error) {
 	switch v := x.(type) {
 	case domain.NumberNode:
-		key := hashKey("num", v.Value, p.baseTF)
-		if n, ok := p.cache[key]; ok {
-			return n, nil
-		}
-		n := &PlanNode{ID: key, Kind: NodeSeries, Op: "const", Meta: map[string]any{"value": v.Value}}
-		p.cache[key] = n
-		return n, nil
+		return p.constNode(v.Value), nil
 
 	case domain.IndicatorNode:
-		key := hashKey("ind", v.Name, v.Timeframe, v.Params, v.Offset)
-		if n, ok := p.cache[key]; ok {
-			return n, nil
+		deps, ids, err := p.planArgs(v.Args)
+		if err != nil {
+			return nil, err
 		}
-		pn := &PlanNode{
-			ID:   key,
-			Kind: NodeSeries,
-			Op:   "indicator",
-			Meta: map[string]any{
-				"name":   v.Name,
-				"tf":     v.Timeframe,
-				"params": v.Params,
-				"offset": v.Offset,
-			},
-		}
-		for _, a := range v.Args {
-			dep, err := p.planExpr(a)
-			if err != nil {
-				return nil, err
+		key := hashKey("ind", v.Name, v.Timeframe, v.Params, v.Offset, ids)
+		return p.intern(key, func() *PlanNode {
+			return &PlanNode{
+				Kind: NodeSeries,
+				Op:   "indicator",
+				Meta: map[string]any{
+					"name":     v.Name,
+					"tf":       v.Timeframe,
+					"params":   v.Params,
+					"offset":   v.Offset,
+					"lookback": p.indicatorLookback(v),
+				},
+				Deps: deps,
 			}
-			pn.Deps = append(pn.Deps, dep)
-		}
-		return pn, nil
+		}), nil
 
 	case domain.FunctionNode:
-		key := hashKey("fn", v.Name, v.Params, v.Args)
-		if n, ok := p.cache[key]; ok {
-			return n, nil
-		}
-
-		meta := map[string]any{"name": v.Name, "params": v.Params}
-		deps := []*PlanNode{}
-		for _, argVal := range v.Args {
-			dep, err := p.planExpr(argVal)
-			if err != nil {
-				return nil, err
-			}
-			deps = append(deps, dep)
+		deps, ids, err := p.planArgs(v.Args)
+		if err != nil {
+			return nil, err
 		}
-		n := &PlanNode{ID: key, Kind: NodeSeries, Op: "function", Meta: meta, Deps: deps}
-		p.cache[key] = n
-		return n, nil
+		key := hashKey("fn", v.Name, v.Params, ids)
+		return p.intern(key, func() *PlanNode {
+			meta := map[string]any{"name": v.Name, "params": v.Params, "lookback": p.functionLookback(v)}
+			return &PlanNode{Kind: NodeSeries, Op: "function", Meta: meta, Deps: deps}
+		}), nil
 
 	case domain.BinaryMathNode:
 		l, err := p.planExpr(v.Left)
@@ -113,13 +285,30 @@ func (p *Planner) planExpr(x domain.ExprNode) (*PlanNode, error) {
 		if err != nil {
 			return nil, err
 		}
+		// a NaN result (x / 0) stays a math node: plans hold only finite
+		// constants, and how NaN compares is up to the run's policy
+		if isConst(l) && isConst(r) {
+			if c := foldMath(v.Op, l.Meta["value"].(float64), r.Meta["value"].(float64)); !math.IsNaN(c) && !math.IsInf(c, 0) {
+				return p.constNode(c), nil
+			}
+		}
+		if (v.Op == "+" || v.Op == "*") && !ordered(l, r) {
+			l, r = r, l
+		}
 		key := hashKey("math", v.Op, l.ID, r.ID)
-		if n, ok := p.cache[key]; ok {
-			return n, nil
+		return p.intern(key, func() *PlanNode {
+			return &PlanNode{Kind: NodeSeries, Op: v.Op, Deps: []*PlanNode{l, r}}
+		}), nil
+
+	case domain.PredSeriesNode:
+		pred, err := p.planPred(v.Pred)
+		if err != nil {
+			return nil, err
 		}
-		n := &PlanNode{ID: key, Kind: NodeSeries, Op: v.Op, Deps: []*PlanNode{l, r}}
-		p.cache[key] = n
-		return n, nil
+		key := hashKey("predser", v.Op, v.N, pred.ID)
+		return p.intern(key, func() *PlanNode {
+			return &PlanNode{Kind: NodeSeries, Op: v.Op, Meta: map[string]any{"n": v.N}, Deps: []*PlanNode{pred}}
+		}), nil
 	}
 	return nil, fmt.Errorf("unknown expr node")
 }
@@ -135,13 +324,73 @@ func (p *Planner) planPred(x domain.PredNode) (*PlanNode, error) {
 		if err != nil {
 			return nil, err
 		}
-		key := hashKey("cmp", v.Op, l.ID, r.ID)
-		if n, ok := p.cache[key]; ok {
-			return n, nil
+		op := v.Op
+		// constants are never NaN, so the NaN policy does not enter here
+		if isConst(l) && isConst(r) {
+			return p.boolConstNode(foldCompare(op, l.Meta["value"].(float64), r.Meta["value"].(float64), true)), nil
+		}
+		if flipped, ok := flipCompare[op]; ok && !ordered(l, r) {
+			l, r, op = r, l, flipped
+		}
+		key := hashKey("cmp", op, l.ID, r.ID)
+		return p.intern(key, func() *PlanNode {
+			return &PlanNode{Kind: NodeBool, Op: "cmp:" + op, Deps: []*PlanNode{l, r}}
+		}), nil
+
+	case domain.PatternNode:
+		key := hashKey("pattern", v.Name, v.Timeframe, v.Params, v.Offset)
+		return p.intern(key, func() *PlanNode {
+			return &PlanNode{
+				Kind: NodeBool,
+				Op:   "pattern",
+				Meta: map[string]any{
+					"name":     v.Name,
+					"tf":       v.Timeframe,
+					"params":   v.Params,
+					"offset":   v.Offset,
+					"lookback": p.patternLookback(v),
+				},
+			}
+		}), nil
+
+	case domain.TemporalNode:
+		pred, err := p.planPred(v.Pred)
+		if err != nil {
+			return nil, err
+		}
+		key := hashKey("temporal", v.Op, v.N, pred.ID)
+		return p.intern(key, func() *PlanNode {
+			return &PlanNode{Kind: NodeBool, Op: v.Op, Meta: map[string]any{"n": v.N}, Deps: []*PlanNode{pred}}
+		}), nil
+
+	case domain.SequenceNode:
+		deps := make([]*PlanNode, 0, len(v.Steps)+1)
+		ids := make([]string, 0, len(v.Steps)+1)
+		gaps := make([]int, 0, len(v.Steps))
+		for _, st := range v.Steps {
+			d, err := p.planPred(st.Pred)
+			if err != nil {
+				return nil, err
+			}
+			deps = append(deps, d)
+			ids = append(ids, d.ID)
+			gaps = append(gaps, st.MaxGap)
+		}
+		// the reset condition, if any, is always the last dependency
+		if v.Reset != nil {
+			d, err := p.planPred(v.Reset)
+			if err != nil {
+				return nil, err
+			}
+			deps = append(deps, d)
+			ids = append(ids, d.ID)
 		}
-		n := &PlanNode{ID: key, Kind: NodeBool, Op: "cmp:" + v.Op, Deps: []*PlanNode{l, r}}
-		p.cache[key] = n
-		return n, nil
+		key := hashKey("seq", gaps, v.Restart, v.Reset != nil, ids)
+		return p.intern(key, func() *PlanNode {
+			return &PlanNode{Kind: NodeBool, Op: "sequence", Deps: deps, Meta: map[string]any{
+				"gaps": gaps, "restart": v.Restart, "reset": v.Reset != nil,
+			}}
+		}), nil
 
 	case domain.LogicalNode:
 		if v.Op == "NOT" {
@@ -149,13 +398,16 @@ func (p *Planner) planPred(x domain.PredNode) (*PlanNode, error) {
 			if err != nil {
 				return nil, err
 			}
-			key := hashKey("not", l.ID)
-			if n, ok := p.cache[key]; ok {
-				return n, nil
+			switch l.Op {
+			case "NOT": // NOT NOT x == x
+				return l.Deps[0], nil
+			case "bconst":
+				return p.boolConstNode(!l.Meta["value"].(bool)), nil
 			}
-			n := &PlanNode{ID: key, Kind: NodeBool, Op: "NOT", Deps: []*PlanNode{l}}
-			p.cache[key] = n
-			return n, nil
+			key := hashKey("not", l.ID)
+			return p.intern(key, func() *PlanNode {
+				return &PlanNode{Kind: NodeBool, Op: "NOT", Deps: []*PlanNode{l}}
+			}), nil
 		}
 		l, err := p.planPred(v.Lhs)
 		if err != nil {
@@ -165,17 +417,40 @@ func (p *Planner) planPred(x domain.PredNode) (*PlanNode, error) {
 		if err != nil {
 			return nil, err
 		}
-		key := hashKey("logic", v.Op, l.ID, r.ID)
-		if n, ok := p.cache[key]; ok {
+		if n, ok := p.foldLogical(v.Op, l, r); ok {
 			return n, nil
 		}
-		n := &PlanNode{ID: key, Kind: NodeBool, Op: v.Op, Deps: []*PlanNode{l, r}}
-		p.cache[key] = n
-		return n, nil
+		if l.ID > r.ID {
+			l, r = r, l
+		}
+		key := hashKey("logic", v.Op, l.ID, r.ID)
+		return p.intern(key, func() *PlanNode {
+			return &PlanNode{Kind: NodeBool, Op: v.Op, Deps: []*PlanNode{l, r}}
+		}), nil
 	}
 	return nil, fmt.Errorf("unknown predicate node")
 }
 
+// foldLogical simplifies AND/OR with a constant or identical operand.
+func (p *Planner) foldLogical(op string, l, r *PlanNode) (*PlanNode, bool) {
+	if l.ID == r.ID {
+		return l, true
+	}
+	if r.Op == "bconst" {
+		l, r = r, l
+	}
+	if l.Op != "bconst" {
+		return nil, false
+	}
+	c := l.Meta["value"].(bool)
+	switch {
+	case op == "AND" && !c, op == "OR" && c:
+		return l, true // absorbing element
+	default:
+		return r, true // identity element
+	}
+}
+
 func (p *Planner) Build(root domain.PredNode) (*Plan, error) {
 	r, err := p.planPred(root)
 	if err != nil {
@@ -198,5 +473,10 @@ func (p *Planner) Build(root domain.PredNode) (*Plan, error) {
 	}
 	dfs(r)
 
-	return &Plan{Roots: []*PlanNode{r}, Order: order}, nil
+	pl := &Plan{Roots: []*PlanNode{r}, Order: order}
+	lookback := planLookbacks(order)
+	for _, root := range pl.Roots {
+		pl.Warmup = maxInt(pl.Warmup, lookback[root.ID])
+	}
+	return pl, nil
 }
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+var (
+	closeExpr = domain.IndicatorNode{Name: "Close", Timeframe: "5m"}
+	openExpr  = domain.IndicatorNode{Name: "Open", Timeframe: "5m"}
+	ema9Expr  = domain.FunctionNode{Name: "EMA", Params: map[string]any{"period": 9.0}, Args: []domain.ExprNode{closeExpr}}
+)
+
+func num(v float64) domain.NumberNode { return domain.NumberNode{Value: v} }
+
+func cmp(l domain.ExprNode, op string, r domain.ExprNode) domain.CompareNode {
+	return domain.CompareNode{Left: l, Op: op, Right: r}
+}
+
+func buildPlan(t *testing.T, pred domain.PredNode) *Plan {
+	t.Helper()
+	pl, err := NewPlanner("5m", BuildRegistry()).Build(pred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pl
+}
+
+func TestPlannerNodeCounts(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		a, b  domain.PredNode // b is nil or plans to the same DAG as a
+		nodes int
+	}{
+		// Close, Open, +, 1, >
+		{"commuted sum",
+			cmp(domain.BinaryMathNode{Left: closeExpr, Op: "+", Right: openExpr}, ">", num(1)),
+			cmp(domain.BinaryMathNode{Left: openExpr, Op: "+", Right: closeExpr}, ">", num(1)), 5},
+		// Close, 5, >
+		{"mirrored comparison", cmp(closeExpr, ">", num(5)), cmp(num(5), "<", closeExpr), 3},
+		{"double negation",
+			domain.LogicalNode{Op: "NOT", Lhs: domain.LogicalNode{Op: "NOT", Lhs: cmp(closeExpr, ">", num(5))}},
+			cmp(closeExpr, ">", num(5)), 3},
+		{"constant", cmp(domain.BinaryMathNode{Left: num(2), Op: "*", Right: num(3)}, ">", num(5)), nil, 1},
+		// Close, EMA, Open, two comparisons, AND
+		{"shared EMA",
+			domain.LogicalNode{Op: "AND", Lhs: cmp(ema9Expr, ">", closeExpr), Rhs: cmp(ema9Expr, "<", openExpr)}, nil, 6},
+		// both sides are the same comparison, and x AND x is x
+		{"repeated comparison",
+			domain.LogicalNode{Op: "AND", Lhs: cmp(ema9Expr, ">", closeExpr), Rhs: cmp(closeExpr, "<", ema9Expr)},
+			cmp(ema9Expr, ">", closeExpr), 3},
+	} {
+		pl := buildPlan(t, tc.a)
+		if len(pl.Order) != tc.nodes {
+			t.Errorf("%s: %d nodes, want %d", tc.name, len(pl.Order), tc.nodes)
+		}
+		if tc.b == nil {
+			continue
+		}
+		other := buildPlan(t, tc.b)
+		if len(other.Order) != tc.nodes {
+			t.Errorf("%s: other side has %d nodes, want %d", tc.name, len(other.Order), tc.nodes)
+		}
+		if pl.Roots[0].ID != other.Roots[0].ID {
+			t.Errorf("%s: different roots %s and %s", tc.name, pl.Roots[0].ID, other.Roots[0].ID)
+		}
+	}
+}
+
+func TestPlannerFoldsConstantCondition(t *testing.T) {
+	pl := buildPlan(t, cmp(domain.BinaryMathNode{Left: num(2), Op: "*", Right: num(3)}, ">", num(5)))
+	if root := pl.Roots[0]; root.Op != "bconst" || !root.Meta["value"].(bool) {
+		t.Errorf("2 * 3 > 5 planned to %s, want bconst true", root.Label())
+	}
+}
+
+func TestPlannerKeepsNaNUnfolded(t *testing.T) {
+	pl := buildPlan(t, cmp(domain.BinaryMathNode{Left: num(1), Op: "/", Right: num(0)}, ">", num(0)))
+	if _, err := json.Marshal(InspectPlan(pl)); err != nil {
+		t.Fatalf("inspect 1 / 0 > 0: %v", err)
+	}
+	for _, nanIsFalse := range []bool{true, false} {
+		ctx := NewEvalCtx("X", "5m", nil, BuildRegistry())
+		ctx.Policy.NaNIsFalse = nanIsFalse
+		ctx.SetCache(candleSeries(streamCandles(3)))
+		root, err := NewRuntime(ctx).ExecPlan(pl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root[0] == nanIsFalse {
+			t.Errorf("1 / 0 > 0 with NaNIsFalse=%v: %v", nanIsFalse, root[0])
+		}
+	}
+}
+
+func TestTemporalWindowIsBounded(t *testing.T) {
+	reg := BuildRegistry()
+	for _, c := range []string{
+		"held_for(Close > Open, 20000)",
+		"within(Close > Open, 0)",
+		"count_true(Close > Open, n=10001) > 3",
+	} {
+		toks, _, err := (&DSLParser{Reg: reg, DefaultTF: "5m"}).Parse(c)
+		if err == nil {
+			_, err = (&Parser{Reg: reg}).ParsePredicate(toks)
+		}
+		if err == nil {
+			t.Errorf("%s: accepted", c)
+		}
+	}
+	planText(t, "held_for(Close > Open, 10000)")
+}
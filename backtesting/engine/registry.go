@@ -1,7 +1,11 @@
 // engine/registry.go
 package engine
 
-import domain "github.com/gulll/deepmarket/backtesting/domain"
+import (
+	"fmt"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
 
 type ArgSpec struct {
 	Name string
@@ -16,28 +20,67 @@ type IndicatorSpec struct {
 	// Eval returns a series for the requested timeframe
 	Eval func(ctx *EvalCtx, tf domain.Timeframe, params map[string]float64,
 		offset int, args ...Series) ([]float64, error)
+	// Lookback is how many leading bars of output are warm-up (NaN or not yet
+	// meaningful) for the given params, not counting offset. Nil means 0.
+	Lookback func(params map[string]float64) int
+	// FutureRef marks outputs that depend on later bars (e.g. a span shifted
+	// back in time). Such specs are fine for charts but not for trading.
+	FutureRef bool
+	// Stream, if set, starts an incremental evaluation for a Stream on the
+	// base timeframe of ctx; see IndicatorStep. Without it, or when it
+	// returns nil (for params or data it has no step for), the Stream falls
+	// back to Eval.
+	Stream func(ctx *EvalCtx, params map[string]float64) IndicatorStep
 }
 
+// IndicatorStep is fed each new base bar with the values of the args on it
+// and returns the indicator's value on that bar, before offset, exactly as
+// Eval over the bars so far would.
+type IndicatorStep func(bar domain.Candle, args []float64) float64
+
 type FunctionSpec struct {
 	Category    string
 	Description string
 	Params      []ArgSpec
 	// Eval: args may include nested ExprNode; resolve inside via ctx.EvalExpr if needed
 	Eval func(ctx *EvalCtx, params map[string]any, args ...Series) ([]float64, error)
+	// Lookback is the warm-up the function adds on top of its inputs. Nil means 0.
+	Lookback func(params map[string]any) int
+	// FutureRef marks outputs that depend on later bars; see IndicatorSpec.
+	FutureRef bool
+	// Stream, if set, starts an incremental evaluation; see FunctionStep.
+	Stream func(params map[string]any) FunctionStep
 }
 
-// Optional: direct Bool generators (rare but useful, e.g., "IsUpTrend")
+// FunctionStep is fed the values of the args on each new bar and returns
+// the function's value on it, as Eval over the bars so far would.
+type FunctionStep func(args []float64) float64
+
+// PredicateSpec is a direct bool generator, e.g. a candlestick pattern. Like
+// indicators it is evaluated for a timeframe and offset.
 type PredicateSpec struct {
 	Category    string
 	Description string
 	Params      []ArgSpec
-	Eval        func(ctx *EvalCtx, params map[string]any, args ...Series) ([]float64, error)
+	Eval        func(ctx *EvalCtx, tf domain.Timeframe, params map[string]float64, offset int) (BoolSeries, error)
+	// Lookback is how many leading bars cannot match, not counting offset.
+	Lookback func(params map[string]float64) int
+	// Stream, if set, starts an incremental evaluation; see PredicateStep
+	// and IndicatorSpec.Stream.
+	Stream func(ctx *EvalCtx, params map[string]float64) PredicateStep
 }
 
+// PredicateStep is fed each new base bar and returns whether the predicate
+// holds on it, before offset, as Eval over the bars so far would.
+type PredicateStep func(bar domain.Candle) bool
+
 type Registry struct {
 	Indicators map[string]IndicatorSpec
 	Functions  map[string]FunctionSpec
 	Predicates map[string]PredicateSpec
+	// Key tells apart registries holding different user scripts, so that
+	// series cached for one never serve another; "" for the built-ins.
+	Key string
 }
 
 func NewRegistry() *Registry {
@@ -47,3 +90,17 @@ func NewRegistry() *Registry {
 		Predicates: map[string]PredicateSpec{},
 	}
 }
+
+// taken reports a name already used by the registry.
+func (r *Registry) taken(name string) error {
+	if _, ok := r.Indicators[name]; ok {
+		return fmt.Errorf("%s is already an indicator", name)
+	}
+	if _, ok := r.Functions[name]; ok {
+		return fmt.Errorf("%s is already a function", name)
+	}
+	if _, ok := r.Predicates[name]; ok {
+		return fmt.Errorf("%s is already a predicate", name)
+	}
+	return nil
+}
@@ -5,54 +5,168 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"runtime"
 	"slices"
 	"strings"
+	"sync"
 
 	domain "github.com/gulll/deepmarket/backtesting/domain"
 )
 
 type Runtime struct {
 	ctx *EvalCtx
+	// Workers bounds how many plan nodes are evaluated at once.
+	// Zero means runtime.GOMAXPROCS(0); one disables parallelism.
+	Workers int
 }
 
 func NewRuntime(ctx *EvalCtx) *Runtime { return &Runtime{ctx: ctx} }
 
 // ExecPlan evaluates the plan and returns the root BoolSeries.
+// Independent branches of the DAG are evaluated concurrently; a node starts
+// as soon as all of its dependencies are cached.
 func (rt *Runtime) ExecPlan(pl *Plan) (BoolSeries, error) {
+	workers := rt.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var err error
+	if workers == 1 || len(pl.Order) < 2 {
+		err = rt.execSequential(pl)
+	} else {
+		err = rt.execParallel(pl, workers)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Root is Bool
+	root := pl.Roots[0]
+	if bs, ok := rt.ctx.BoolOf(root.ID); ok {
+		return bs, nil
+	}
+	return nil, fmt.Errorf("root bool not found")
+}
+
+func (rt *Runtime) execSequential(pl *Plan) error {
 	for _, n := range pl.Order {
-		switch n.Kind {
-		case NodeSeries, NodeAlign, NodeShift:
-			if _, ok := rt.ctx.cache[n.ID]; ok {
+		if err := rt.execNode(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execParallel schedules pl.Order on a bounded worker pool. Nodes are keyed
+// by ID since equal subexpressions may be distinct *PlanNode values.
+func (rt *Runtime) execParallel(pl *Plan, workers int) error {
+	pending := make(map[string]int, len(pl.Order))
+	dependents := make(map[string][]*PlanNode, len(pl.Order))
+	for _, n := range pl.Order {
+		seen := map[string]bool{}
+		for _, d := range n.Deps {
+			if seen[d.ID] {
 				continue
 			}
-			ser, err := rt.execSeriesNode(n)
-			if err != nil {
-				return nil, err
+			seen[d.ID] = true
+			pending[n.ID]++
+			dependents[d.ID] = append(dependents[d.ID], n)
+		}
+	}
+
+	type result struct {
+		n   *PlanNode
+		err error
+	}
+	jobs := make(chan *PlanNode, len(pl.Order))
+	done := make(chan result, len(pl.Order))
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				done <- result{n: n, err: rt.execNode(n)}
 			}
-			rt.ctx.cache[n.ID] = ser
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
 
-		case NodeBool:
-			if _, ok := rt.ctx.bcache[n.ID]; ok {
-				continue
+	inflight := 0
+	for _, n := range pl.Order {
+		if pending[n.ID] == 0 {
+			jobs <- n
+			inflight++
+		}
+	}
+
+	var firstErr error
+	for inflight > 0 {
+		r := <-done
+		inflight--
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
 			}
-			bs, err := rt.execBoolNode(n)
-			if err != nil {
-				return nil, err
+			continue
+		}
+		if firstErr != nil {
+			// drain in-flight work, schedule nothing new
+			continue
+		}
+		for _, dep := range dependents[r.n.ID] {
+			pending[dep.ID]--
+			if pending[dep.ID] == 0 {
+				jobs <- dep
+				inflight++
 			}
-			rt.ctx.bcache[n.ID] = bs
 		}
 	}
-	// Root is Bool
-	root := pl.Roots[0]
-	if bs, ok := rt.ctx.bcache[root.ID]; ok {
-		return bs, nil
+	return firstErr
+}
+
+// execNode evaluates a single node and caches its output unless already cached.
+func (rt *Runtime) execNode(n *PlanNode) error {
+	switch n.Kind {
+	case NodeSeries, NodeAlign, NodeShift:
+		if _, ok := rt.ctx.SeriesOf(n.ID); ok {
+			return nil
+		}
+		if v, ok := rt.ctx.sharedGet(n.ID); ok {
+			rt.ctx.storeSeries(n.ID, v.(Series))
+			return nil
+		}
+		ser, err := rt.execSeriesNode(n)
+		if err != nil {
+			return err
+		}
+		rt.ctx.storeSeries(n.ID, ser)
+		rt.ctx.sharedPut(n.ID, ser, int64(len(ser))*8)
+
+	case NodeBool:
+		if _, ok := rt.ctx.BoolOf(n.ID); ok {
+			return nil
+		}
+		if v, ok := rt.ctx.sharedGet(n.ID); ok {
+			rt.ctx.storeBool(n.ID, v.(BoolSeries))
+			return nil
+		}
+		bs, err := rt.execBoolNode(n)
+		if err != nil {
+			return err
+		}
+		rt.ctx.storeBool(n.ID, bs)
+		rt.ctx.sharedPut(n.ID, bs, int64(len(bs)))
 	}
-	return nil, fmt.Errorf("root bool not found")
+	return nil
 }
 
 func (rt *Runtime) loadSeries(n *PlanNode, idx int) (Series, error) {
 	d := n.Deps[idx]
-	if s, ok := rt.ctx.cache[d.ID]; ok {
+	if s, ok := rt.ctx.SeriesOf(d.ID); ok {
 		return s, nil
 	}
 	// Should have been created earlier via topo order
@@ -60,20 +174,20 @@ func (rt *Runtime) loadSeries(n *PlanNode, idx int) (Series, error) {
 	if err != nil {
 		return nil, err
 	}
-	rt.ctx.cache[d.ID] = ser
+	rt.ctx.storeSeries(d.ID, ser)
 	return ser, nil
 }
 
 func (rt *Runtime) loadBool(n *PlanNode, idx int) (BoolSeries, error) {
 	d := n.Deps[idx]
-	if s, ok := rt.ctx.bcache[d.ID]; ok {
+	if s, ok := rt.ctx.BoolOf(d.ID); ok {
 		return s, nil
 	}
 	bs, err := rt.execBoolNode(d)
 	if err != nil {
 		return nil, err
 	}
-	rt.ctx.bcache[d.ID] = bs
+	rt.ctx.storeBool(d.ID, bs)
 	return bs, nil
 }
 
@@ -208,7 +322,7 @@ func (rt *Runtime) execSeriesNode(n *PlanNode) (Series, error) {
 	switch n.Op {
 	case "const":
 		value := n.Meta["value"].(float64)
-		L := len(rt.ctx.cache["close"])
+		L := len(rt.ctx.series("close"))
 		out := make(Series, L)
 		for i := range out {
 			out[i] = value
@@ -273,9 +387,19 @@ func (rt *Runtime) execSeriesNode(n *PlanNode) (Series, error) {
 
 	case "align":
 		fromTF := n.Meta["fromTF"].(domain.Timeframe)
-		src := rt.ctx.cache[n.Deps[0].ID]
+		src := rt.ctx.series(n.Deps[0].ID)
 		return rt.ctx.Data.AlignTo(rt.ctx.BaseTF, src, fromTF)
 
+	case "bars_since", "count_true":
+		pred, err := rt.loadBool(n, 0)
+		if err != nil {
+			return nil, err
+		}
+		if n.Op == "bars_since" {
+			return BarsSince(pred), nil
+		}
+		return CountTrue(pred, n.Meta["n"].(int)), nil
+
 	case "+", "-", "*", "/", "%", "^":
 		l, err := rt.loadSeries(n, 0)
 		if err != nil {
@@ -326,6 +450,45 @@ func (rt *Runtime) execSeriesNode(n *PlanNode) (Series, error) {
 
 func (rt *Runtime) execBoolNode(n *PlanNode) (BoolSeries, error) {
 	switch n.Op {
+	case "bconst":
+		value := n.Meta["value"].(bool)
+		out := make(BoolSeries, len(rt.ctx.series("close")))
+		for i := range out {
+			out[i] = value
+		}
+		return out, nil
+
+	case "pattern":
+		name := n.Meta["name"].(string)
+		tf := n.Meta["tf"].(domain.Timeframe)
+		spec, ok := rt.ctx.Reg.Predicates[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown predicate %s", name)
+		}
+		bs, err := spec.Eval(rt.ctx, tf, n.Meta["params"].(map[string]float64), n.Meta["offset"].(int))
+		if err != nil {
+			return nil, err
+		}
+		if tf == rt.ctx.BaseTF {
+			return bs, nil
+		}
+		// align as 0/1 so higher timeframe bars forward-fill like indicators
+		ser := make(Series, len(bs))
+		for i, b := range bs {
+			if b {
+				ser[i] = 1
+			}
+		}
+		ser, err = rt.ctx.Data.AlignTo(rt.ctx.BaseTF, ser, tf)
+		if err != nil {
+			return nil, err
+		}
+		out := make(BoolSeries, len(ser))
+		for i, v := range ser {
+			out[i] = v == 1
+		}
+		return out, nil
+
 	case "NOT":
 		l, err := rt.loadBool(n, 0)
 		if err != nil {
@@ -337,6 +500,36 @@ func (rt *Runtime) execBoolNode(n *PlanNode) (BoolSeries, error) {
 		}
 		return out, nil
 
+	case "held_for", "within":
+		pred, err := rt.loadBool(n, 0)
+		if err != nil {
+			return nil, err
+		}
+		if n.Op == "held_for" {
+			return HeldFor(pred, n.Meta["n"].(int)), nil
+		}
+		return Within(pred, n.Meta["n"].(int)), nil
+
+	case "sequence":
+		gaps := n.Meta["gaps"].([]int)
+		steps := make([]BoolSeries, 0, len(gaps))
+		for i := range gaps {
+			bs, err := rt.loadBool(n, i)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, bs)
+		}
+		var reset BoolSeries
+		if n.Meta["reset"].(bool) {
+			bs, err := rt.loadBool(n, len(gaps))
+			if err != nil {
+				return nil, err
+			}
+			reset = bs
+		}
+		return Sequence(steps, gaps, reset, n.Meta["restart"].(bool)), nil
+
 	case "AND", "OR":
 		l, err := rt.loadBool(n, 0)
 		if err != nil {
@@ -349,17 +542,19 @@ func (rt *Runtime) execBoolNode(n *PlanNode) (BoolSeries, error) {
 		if len(l) != len(r) {
 			return nil, fmt.Errorf("boolean length mismatch")
 		}
+		// write into a fresh slice: l is the cached result of another node
+		out := make(BoolSeries, len(l))
 		switch n.Op {
 		case "AND":
 			for i := range l {
-				l[i] = l[i] && r[i]
+				out[i] = l[i] && r[i]
 			}
 		case "OR":
 			for i := range l {
-				l[i] = l[i] || r[i]
+				out[i] = l[i] || r[i]
 			}
 		}
-		return l, nil
+		return out, nil
 
 	default:
 		// cmp: prefix "cmp:"
@@ -0,0 +1,315 @@
+// engine/script.go
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"runtime/metrics"
+	"time"
+	"unicode"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+	starmath "go.starlark.net/lib/math"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// Script is a user indicator written in Starlark, for logic that needs loops
+// or state and so cannot be an expression (trailing stops, zigzag). Source
+// defines
+//
+//	def compute(bars, params):
+//	    out = []
+//	    for i in range(bars.len):
+//	        out.append(bars.close[i] - bars.open[i])
+//	    return out
+//
+// bars has the open, high, low, close, volume and time (unix seconds) lists
+// and len, the bar count; params has the call params by name. compute
+// returns one number per bar, None for NaN. Scripts get the Starlark
+// built-ins and the math module only: no load, files or network.
+type Script struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Params      []CustomParam `json:"params,omitempty"`
+	// Warmup is the number of leading bars the script leaves NaN, used as
+	// the indicator's Lookback; at most maxScriptWarmup.
+	Warmup int    `json:"warmup,omitempty"`
+	Source string `json:"source"`
+}
+
+// ScriptLimits bound one run of a script. Memory is the run's allocation
+// budget: the bytes it may allocate by building strings, lists and text
+// with +, *, %, join, replace, format, extend and str, counted as they are
+// built (see script_alloc.go). Growth the budget does not count, such as
+// appending in a loop, is left to a last-resort cap on the process heap of
+// heapCapFactor times Memory; the heap is shared by the whole process, so
+// the cap is far above what one script should reach.
+type ScriptLimits struct {
+	Steps   uint64
+	Timeout time.Duration
+	Memory  uint64
+}
+
+var DefaultScriptLimits = ScriptLimits{Steps: 100_000_000, Timeout: 5 * time.Second, Memory: 256 << 20}
+
+// heapCapFactor times Memory is how far the process heap may grow during a
+// run before the run is cancelled.
+const heapCapFactor = 16
+
+var scriptOptions = &syntax.FileOptions{While: true, Set: true, TopLevelControl: true}
+
+var scriptBuiltins = withAllocGuards(starlark.StringDict{"math": starmath.Module})
+
+// CompiledScript is a Script whose top level has run; it is frozen and safe
+// to run concurrently.
+type CompiledScript struct {
+	Script
+	limits  ScriptLimits
+	compute starlark.Callable
+}
+
+// CompileScript runs the top level of s under limits and checks that it
+// defines compute.
+func CompileScript(s Script, limits ScriptLimits) (*CompiledScript, error) {
+	if !isIdent(s.Name) {
+		return nil, fmt.Errorf("invalid script name %q", s.Name)
+	}
+	if err := checkParamTypes(s.Params); err != nil {
+		return nil, err
+	}
+	if s.Warmup < 0 || s.Warmup > maxScriptWarmup {
+		return nil, fmt.Errorf("script %s: warmup must be between 0 and %d", s.Name, maxScriptWarmup)
+	}
+	cs := &CompiledScript{Script: s, limits: limits}
+	var globals starlark.StringDict
+	err := cs.exec(func(thread *starlark.Thread) (err error) {
+		f, err := scriptOptions.Parse(s.Name+".star", s.Source, 0)
+		if err != nil {
+			return err
+		}
+		if err := guardAllocs(f); err != nil {
+			return err
+		}
+		prog, err := starlark.FileProgram(f, scriptBuiltins.Has)
+		if err != nil {
+			return err
+		}
+		globals, err = prog.Init(thread, scriptBuiltins)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := globals["compute"].(*starlark.Function)
+	if !ok {
+		return nil, fmt.Errorf("script %s: must define compute(bars, params)", s.Name)
+	}
+	if fn.NumParams() != 2 {
+		return nil, fmt.Errorf("script %s: compute must take (bars, params)", s.Name)
+	}
+	globals.Freeze()
+	cs.compute = fn
+	return cs, nil
+}
+
+// Run calls compute on the bars with params (defaults filled in).
+func (cs *CompiledScript) Run(ohlcv map[string]Series, params map[string]float64) ([]float64, error) {
+	n := len(ohlcv["close"])
+	fields := starlark.StringDict{"len": starlark.MakeInt(n)}
+	for _, k := range []string{"open", "high", "low", "close", "volume", "time"} {
+		fields[k] = frozenList(ohlcv[k], n)
+	}
+	bars := starlarkstruct.FromStringDict(starlarkstruct.Default, fields)
+
+	vals := paramValues(cs.Params, params)
+	pd := starlark.StringDict{}
+	for _, cp := range cs.Params {
+		v, ok := vals[cp.Name]
+		if !ok {
+			return nil, fmt.Errorf("script %s: missing param %q", cs.Name, cp.Name)
+		}
+		if cp.Type == "int" {
+			pd[cp.Name] = starlark.MakeInt(int(v))
+		} else {
+			pd[cp.Name] = starlark.Float(v)
+		}
+	}
+	args := starlark.Tuple{bars, starlarkstruct.FromStringDict(starlarkstruct.Default, pd)}
+
+	var res starlark.Value
+	err := cs.exec(func(thread *starlark.Thread) (err error) {
+		res, err = starlark.Call(thread, cs.compute, args, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cs.series(res, n)
+}
+
+// exec runs f on a new thread with the script's limits.
+func (cs *CompiledScript) exec(f func(thread *starlark.Thread) error) error {
+	thread := &starlark.Thread{
+		Name:  cs.Name,
+		Print: func(*starlark.Thread, string) {},
+		Load: func(*starlark.Thread, string) (starlark.StringDict, error) {
+			return nil, errors.New("load is not available in scripts")
+		},
+	}
+	if cs.limits.Steps > 0 {
+		thread.SetMaxExecutionSteps(cs.limits.Steps)
+	}
+	if cs.limits.Timeout > 0 {
+		timer := time.AfterFunc(cs.limits.Timeout, func() {
+			thread.Cancel(fmt.Sprintf("time limit of %s exceeded", cs.limits.Timeout))
+		})
+		defer timer.Stop()
+	}
+	if cs.limits.Memory > 0 {
+		thread.SetLocal(allocKey, &allocBudget{left: cs.limits.Memory, limit: cs.limits.Memory})
+		done := make(chan struct{})
+		defer close(done)
+		go watchHeap(thread, heapCapFactor*cs.limits.Memory, done)
+	}
+	err := f(thread)
+	var ee *starlark.EvalError
+	if errors.As(err, &ee) {
+		return fmt.Errorf("script %s: %s", cs.Name, ee.Backtrace())
+	}
+	if err != nil {
+		return fmt.Errorf("script %s: %w", cs.Name, err)
+	}
+	return nil
+}
+
+// watchHeap cancels thread once the live heap of the process has grown by
+// more than limit. Other work in the process grows the same heap, so limit
+// must leave room for it.
+func watchHeap(thread *starlark.Thread, limit uint64, done <-chan struct{}) {
+	sample := []metrics.Sample{{Name: "/gc/heap/live:bytes"}}
+	metrics.Read(sample)
+	base := sample[0].Value.Uint64()
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+			metrics.Read(sample)
+			if live := sample[0].Value.Uint64(); live > base && live-base > limit {
+				thread.Cancel(fmt.Sprintf("process heap grew past the %d MB safety cap", limit>>20))
+				return
+			}
+		}
+	}
+}
+
+func isIdent(s string) bool {
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func frozenList(xs Series, n int) *starlark.List {
+	vals := make([]starlark.Value, n)
+	for i := range vals {
+		v := 0.0
+		if i < len(xs) {
+			v = xs[i]
+		}
+		vals[i] = starlark.Float(v)
+	}
+	l := starlark.NewList(vals)
+	l.Freeze()
+	return l
+}
+
+// series converts what compute returned to one value per bar.
+func (cs *CompiledScript) series(v starlark.Value, n int) ([]float64, error) {
+	seq, ok := v.(starlark.Indexable)
+	if !ok {
+		return nil, fmt.Errorf("script %s: compute returned %s, want a list", cs.Name, v.Type())
+	}
+	if seq.Len() != n {
+		return nil, fmt.Errorf("script %s: compute returned %d values for %d bars", cs.Name, seq.Len(), n)
+	}
+	out := make([]float64, n)
+	for i := range out {
+		x := seq.Index(i)
+		if x == starlark.None {
+			out[i] = math.NaN()
+			continue
+		}
+		f, ok := starlark.AsFloat(x)
+		if !ok {
+			return nil, fmt.Errorf("script %s: value %d is %s, want a number or None", cs.Name, i, x.Type())
+		}
+		out[i] = f
+	}
+	return out, nil
+}
+
+// maxScriptWarmup bounds Script.Warmup, which sizes the history loaded ahead
+// of every run that uses the script.
+const maxScriptWarmup = 10000
+
+// Spec is the script as an indicator over the bars of its timeframe.
+func (cs *CompiledScript) Spec() IndicatorSpec {
+	return IndicatorSpec{
+		Category:    "Script",
+		Description: cs.Description,
+		Params:      paramSpecs(cs.Params),
+		Eval: func(ctx *EvalCtx, tf domain.Timeframe,
+			params map[string]float64, offset int, args ...Series) ([]float64, error) {
+			ohlcv := map[string]Series{}
+			if tf == "" || tf == ctx.BaseTF {
+				for _, k := range []string{"open", "high", "low", "close", "volume", "time"} {
+					ohlcv[k] = ctx.series(k)
+				}
+			} else {
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				ohlcv = candleSeries(bars)
+			}
+			out, err := cs.Run(ohlcv, params)
+			if err != nil {
+				return nil, err
+			}
+			return shiftBack(out, offset)
+		},
+		Lookback: func(map[string]float64) int { return cs.Warmup },
+	}
+}
+
+// WithScripts returns a copy of r with scripts added as indicators. Its Key
+// is derived from the scripts, so identical sets share cached series.
+func (r *Registry) WithScripts(scripts ...*CompiledScript) (*Registry, error) {
+	if len(scripts) == 0 {
+		return r, nil
+	}
+	out := &Registry{Indicators: maps.Clone(r.Indicators), Functions: r.Functions, Predicates: r.Predicates}
+	h := sha256.New()
+	for _, cs := range scripts {
+		if err := out.taken(cs.Name); err != nil {
+			return nil, err
+		}
+		out.Indicators[cs.Name] = cs.Spec()
+		params, _ := json.Marshal(cs.Params)
+		fmt.Fprintf(h, "%s\x00%d\x00%s\x00%s\x00", cs.Name, cs.Warmup, params, cs.Source)
+	}
+	out.Key = r.Key + "|scripts:" + hex.EncodeToString(h.Sum(nil))[:16]
+	return out, nil
+}
@@ -0,0 +1,575 @@
+// engine/script_alloc.go
+package engine
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strings"
+	"unsafe"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Starlark allocates without limit: "x" * 600000000 is one step. Scripts are
+// rewritten before they compile so that the operators, methods and built-ins
+// that build values larger than their operands go through the guards below,
+// which charge the size of each result to a per-run budget and fail before
+// allocating past it:
+//
+//	x + y   ->  $add(x, y)          x += y  ->  x += $iadd(x, y)
+//	x * y   ->  $mul(x, y)          x *= y  ->  x *= $imul(x, y)
+//	x % y   ->  $mod(x, y)          x %= y  ->  x %= $imod(x, y)
+//	x.join, x.replace, x.format, x.extend  ->  $attr(x, "join"), ...
+//
+// $attr, like getattr, returns the method wrapped in a guard when x is a
+// string (or a list, for extend), so the guard holds however the method is
+// called later. str, repr and print charge the text they build, and range
+// is capped at the budget so that list(range(n)) and the like cannot build
+// more than the budget in one go. The $ names cannot be written in a
+// script, so they cannot be shadowed.
+
+// valueSize is the size of a list or tuple element.
+const valueSize = uint64(unsafe.Sizeof(starlark.Value(nil)))
+
+const allocKey = "alloc"
+
+// allocBudget is the number of bytes a run may still allocate.
+type allocBudget struct{ left, limit uint64 }
+
+func (b *allocBudget) exceeded() error {
+	return fmt.Errorf("memory limit of %d MB exceeded", b.limit>>20)
+}
+
+// charge takes n bytes from the thread's budget, if it has one.
+func charge(thread *starlark.Thread, n uint64) error {
+	b, _ := thread.Local(allocKey).(*allocBudget)
+	if b == nil {
+		return nil
+	}
+	if n > b.left {
+		return b.exceeded()
+	}
+	b.left -= n
+	return nil
+}
+
+// budgetLeft is what the thread may still allocate, for estimates that can
+// stop counting once past it.
+func budgetLeft(thread *starlark.Thread) uint64 {
+	if b, _ := thread.Local(allocKey).(*allocBudget); b != nil {
+		return b.left
+	}
+	return math.MaxUint64
+}
+
+type builtinFunc = func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error)
+
+// withAllocGuards returns predeclared plus the guards scripts are rewritten to call.
+func withAllocGuards(predeclared starlark.StringDict) starlark.StringDict {
+	out := starlark.StringDict{
+		"$add":    starlark.NewBuiltin("+", binaryGuard(syntax.PLUS)),
+		"$mul":    starlark.NewBuiltin("*", binaryGuard(syntax.STAR)),
+		"$mod":    starlark.NewBuiltin("%", binaryGuard(syntax.PERCENT)),
+		"$iadd":   starlark.NewBuiltin("+=", inplaceGuard(syntax.PLUS)),
+		"$imul":   starlark.NewBuiltin("*=", inplaceGuard(syntax.STAR)),
+		"$imod":   starlark.NewBuiltin("%=", inplaceGuard(syntax.PERCENT)),
+		"$attr":   starlark.NewBuiltin("getattr", guardedGetattr),
+		"getattr": starlark.NewBuiltin("getattr", guardedGetattr),
+		"range":   starlark.NewBuiltin("range", guardedRange),
+		"str":     starlark.NewBuiltin("str", textGuard("str")),
+		"repr":    starlark.NewBuiltin("repr", textGuard("repr")),
+		"print":   starlark.NewBuiltin("print", textGuard("print")),
+	}
+	for k, v := range predeclared {
+		out[k] = v
+	}
+	return out
+}
+
+// seqSize is the size in bytes of a string, bytes, list or tuple.
+func seqSize(v starlark.Value) (uint64, bool) {
+	switch v := v.(type) {
+	case starlark.String:
+		return uint64(len(v)), true
+	case starlark.Bytes:
+		return uint64(len(v)), true
+	case *starlark.List:
+		return uint64(v.Len()) * valueSize, true
+	case starlark.Tuple:
+		return uint64(len(v)) * valueSize, true
+	}
+	return 0, false
+}
+
+// maxTextDepth bounds how deep textSize looks into nested values; deeper
+// nesting counts as over any budget.
+const maxTextDepth = 100
+
+// textSize is an upper bound on the length of str(v) or repr(v), counted
+// until it passes limit. Nested strings count four bytes per byte for
+// escapes, and values seen on the current path (cycles) as "[...]".
+func textSize(v starlark.Value, limit uint64) uint64 {
+	var n uint64
+	var path []starlark.Value
+	var walk func(v starlark.Value)
+	walk = func(v starlark.Value) {
+		if n > limit {
+			return
+		}
+		if len(path) > maxTextDepth {
+			n = math.MaxUint64
+			return
+		}
+		for _, p := range path {
+			if p == v {
+				n += 5
+				return
+			}
+		}
+		switch v := v.(type) {
+		case starlark.String:
+			n += 4*uint64(len(v)) + 2
+		case starlark.Bytes:
+			n += 4*uint64(len(v)) + 3
+		case starlark.Int:
+			n += uint64(v.BigInt().BitLen())/3 + 2
+		case *starlark.List, starlark.Tuple, *starlark.Dict, *starlark.Set:
+			path = append(path, v)
+			n += 2
+			iter := starlark.Iterate(v)
+			var x starlark.Value
+			for iter.Next(&x) && n <= limit {
+				n += 2
+				walk(x)
+				if d, ok := v.(*starlark.Dict); ok {
+					val, _, _ := d.Get(x)
+					n += 2
+					walk(val)
+				}
+			}
+			iter.Done()
+			path = path[:len(path)-1]
+		case starlark.HasAttrs:
+			path = append(path, v)
+			n += 16
+			for _, name := range v.AttrNames() {
+				if x, err := v.Attr(name); err == nil && x != nil {
+					n += uint64(len(name)) + 3
+					walk(x)
+				}
+			}
+			path = path[:len(path)-1]
+		default:
+			n += 32
+		}
+	}
+	walk(v)
+	return n
+}
+
+// formatSize bounds fmt formatted with vals: each of up to fields
+// placeholders may take the largest value.
+func formatSize(format string, fields int, vals []starlark.Value, limit uint64) uint64 {
+	var widest uint64
+	for _, v := range vals {
+		widest = maxU64(widest, textSize(v, limit))
+	}
+	return satAdd(uint64(len(format)), satMul(uint64(fields), widest))
+}
+
+func maxU64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func satAdd(a, b uint64) uint64 {
+	if s, carry := bits.Add64(a, b, 0); carry == 0 {
+		return s
+	}
+	return math.MaxUint64
+}
+
+func satMul(a, b uint64) uint64 {
+	if hi, lo := bits.Mul64(a, b); hi == 0 {
+		return lo
+	}
+	return math.MaxUint64
+}
+
+// resultSize is the size of x op y where that builds a sequence, a big
+// integer or formatted text; MaxUint64 stands for anything too large to
+// count.
+func resultSize(thread *starlark.Thread, op syntax.Token, x, y starlark.Value) uint64 {
+	switch op {
+	case syntax.PLUS:
+		sx, okx := seqSize(x)
+		sy, oky := seqSize(y)
+		if okx && oky {
+			return sx + sy
+		}
+	case syntax.STAR:
+		if xi, ok := x.(starlark.Int); ok {
+			if yi, ok := y.(starlark.Int); ok {
+				_, smallX := xi.Int64()
+				_, smallY := yi.Int64()
+				if smallX && smallY {
+					return 0
+				}
+				return uint64(xi.BigInt().BitLen()+yi.BigInt().BitLen()) / 8
+			}
+			x, y = y, x
+		}
+		n, ok := y.(starlark.Int)
+		if !ok {
+			return 0
+		}
+		sx, ok := seqSize(x)
+		if !ok || sx == 0 || n.Sign() <= 0 {
+			return 0
+		}
+		k, ok := n.Uint64()
+		if !ok {
+			return math.MaxUint64
+		}
+		return satMul(sx, k)
+	case syntax.PERCENT:
+		format, ok := x.(starlark.String)
+		if !ok {
+			return 0
+		}
+		vals := []starlark.Value{y}
+		switch y := y.(type) {
+		case starlark.Tuple:
+			vals = y
+		case *starlark.Dict:
+			vals = nil
+			for _, kv := range y.Items() {
+				vals = append(vals, kv[1])
+			}
+		}
+		return formatSize(string(format), strings.Count(string(format), "%"), vals, budgetLeft(thread))
+	}
+	return 0
+}
+
+func binaryGuard(op syntax.Token) builtinFunc {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		x, y := args[0], args[1]
+		if err := charge(thread, resultSize(thread, op, x, y)); err != nil {
+			return nil, err
+		}
+		return starlark.Binary(op, x, y)
+	}
+}
+
+// inplaceGuard checks x op= y and returns y, leaving the operation itself,
+// and so the in-place extension of lists by +=, to the interpreter.
+func inplaceGuard(op syntax.Token) builtinFunc {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		x, y := args[0], args[1]
+		n := resultSize(thread, op, x, y)
+		if _, ok := x.(*starlark.List); ok && op == syntax.PLUS {
+			// += extends the list by y
+			n = extendSize(y)
+		}
+		if err := charge(thread, n); err != nil {
+			return nil, err
+		}
+		return y, nil
+	}
+}
+
+// extendSize is what extending a list by the iterable y adds to it.
+func extendSize(y starlark.Value) uint64 {
+	if s, ok := y.(starlark.Sequence); ok {
+		return uint64(s.Len()) * valueSize
+	}
+	return 0
+}
+
+// methodSize estimates the result of the methods that can build more than
+// their receiver and arguments hold, by method name; ok is false for other
+// methods and receivers, which are left alone.
+func methodSize(thread *starlark.Thread, recv starlark.Value, name string, args starlark.Tuple, kwargs []starlark.Tuple) (n uint64, ok bool) {
+	if _, isList := recv.(*starlark.List); isList && name == "extend" {
+		if len(args) == 1 {
+			n = extendSize(args[0])
+		}
+		return n, true
+	}
+	s, isStr := recv.(starlark.String)
+	if !isStr {
+		return 0, false
+	}
+	switch name {
+	case "join":
+		return joinSize(string(s), args), true
+	case "replace":
+		return replaceSize(string(s), args), true
+	case "format":
+		vals := append([]starlark.Value(nil), args...)
+		for _, kv := range kwargs {
+			vals = append(vals, kv[1])
+		}
+		return formatSize(string(s), strings.Count(string(s), "{"), vals, budgetLeft(thread)), true
+	}
+	return 0, false
+}
+
+// guardedAttrs are the methods methodSize knows; the rewriter routes their
+// selection through $attr.
+var guardedAttrs = map[string]bool{"join": true, "replace": true, "format": true, "extend": true}
+
+// guardedGetattr is getattr, returning the methods methodSize knows wrapped
+// so that they charge the budget before building their result.
+func guardedGetattr(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	m, err := starlark.Call(thread, starlark.Universe["getattr"], args, kwargs)
+	if err != nil || len(args) < 2 {
+		return m, err
+	}
+	name, ok := args[1].(starlark.String)
+	if !ok || !guardedAttrs[string(name)] {
+		return m, nil
+	}
+	recv := args[0]
+	if _, ok := methodSize(thread, recv, string(name), nil, nil); !ok {
+		return m, nil
+	}
+	return starlark.NewBuiltin(string(name), func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		n, _ := methodSize(thread, recv, string(name), args, kwargs)
+		if err := charge(thread, n); err != nil {
+			return nil, err
+		}
+		return starlark.Call(thread, m, args, kwargs)
+	}), nil
+}
+
+func joinSize(sep string, args starlark.Tuple) uint64 {
+	if len(args) != 1 {
+		return 0
+	}
+	iter := starlark.Iterate(args[0])
+	if iter == nil {
+		return 0
+	}
+	defer iter.Done()
+	var n, k uint64
+	var x starlark.Value
+	for iter.Next(&x) {
+		if s, ok := x.(starlark.String); ok {
+			n += uint64(len(s))
+		}
+		k++
+	}
+	if k > 0 {
+		n = satAdd(n, satMul(uint64(len(sep)), k-1))
+	}
+	return n
+}
+
+func replaceSize(s string, args starlark.Tuple) uint64 {
+	if len(args) < 2 {
+		return 0
+	}
+	old, ok1 := args[0].(starlark.String)
+	repl, ok2 := args[1].(starlark.String)
+	if !ok1 || !ok2 || len(repl) <= len(old) {
+		return uint64(len(s))
+	}
+	return satAdd(uint64(len(s)), satMul(uint64(strings.Count(s, string(old))), uint64(len(repl)-len(old))))
+}
+
+// textGuard is the universal str, repr or print, charging the text it
+// builds first.
+func textGuard(name string) builtinFunc {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var n uint64
+		for _, a := range args {
+			if s, ok := a.(starlark.String); ok && name != "repr" {
+				n += uint64(len(s)) + 1 // str and print use a string as it is
+				continue
+			}
+			n = satAdd(n, textSize(a, budgetLeft(thread))+1)
+		}
+		if err := charge(thread, n); err != nil {
+			return nil, err
+		}
+		return starlark.Call(thread, starlark.Universe[name], args, kwargs)
+	}
+}
+
+func guardedRange(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	r, err := starlark.Call(thread, starlark.Universe["range"], args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	// a range is lazy, but anything built from it holds one value per element
+	if bud, _ := thread.Local(allocKey).(*allocBudget); bud != nil {
+		if s, ok := r.(starlark.Sequence); ok && uint64(s.Len())*valueSize > bud.limit {
+			return nil, bud.exceeded()
+		}
+	}
+	return r, nil
+}
+
+// guardAllocs rewrites f to call the guards; see above.
+func guardAllocs(f *syntax.File) error {
+	var g allocRewriter
+	g.stmts(f.Stmts)
+	return g.err
+}
+
+type allocRewriter struct{ err error }
+
+func guardCall(name string, pos syntax.Position, args ...syntax.Expr) *syntax.CallExpr {
+	return &syntax.CallExpr{
+		Fn:     &syntax.Ident{NamePos: pos, Name: name},
+		Lparen: pos,
+		Args:   args,
+		Rparen: pos,
+	}
+}
+
+func (g *allocRewriter) stmts(ss []syntax.Stmt) {
+	for _, s := range ss {
+		g.stmt(s)
+	}
+}
+
+var inplaceGuards = map[syntax.Token]string{syntax.PLUS_EQ: "$iadd", syntax.STAR_EQ: "$imul", syntax.PERCENT_EQ: "$imod"}
+
+func (g *allocRewriter) stmt(s syntax.Stmt) {
+	switch s := s.(type) {
+	case *syntax.AssignStmt:
+		guard := inplaceGuards[s.Op]
+		if guard != "" && hasCall(s.LHS) {
+			// the guard reads the target a second time
+			if g.err == nil {
+				g.err = syntax.Error{Pos: s.OpPos, Msg: fmt.Sprintf("the target of %s must not call functions", s.Op)}
+			}
+			return
+		}
+		s.LHS = g.target(s.LHS)
+		s.RHS = g.expr(s.RHS)
+		if guard != "" {
+			s.RHS = guardCall(guard, s.OpPos, s.LHS, s.RHS)
+		}
+	case *syntax.DefStmt:
+		for i, p := range s.Params {
+			s.Params[i] = g.expr(p)
+		}
+		g.stmts(s.Body)
+	case *syntax.ExprStmt:
+		s.X = g.expr(s.X)
+	case *syntax.ForStmt:
+		s.X = g.expr(s.X)
+		g.stmts(s.Body)
+	case *syntax.WhileStmt:
+		s.Cond = g.expr(s.Cond)
+		g.stmts(s.Body)
+	case *syntax.IfStmt:
+		s.Cond = g.expr(s.Cond)
+		g.stmts(s.True)
+		g.stmts(s.False)
+	case *syntax.ReturnStmt:
+		s.Result = g.expr(s.Result)
+	}
+}
+
+// target rewrites inside an assignment target, which must stay a target.
+func (g *allocRewriter) target(e syntax.Expr) syntax.Expr {
+	switch e := e.(type) {
+	case *syntax.DotExpr:
+		e.X = g.expr(e.X)
+	case *syntax.IndexExpr:
+		e.X, e.Y = g.expr(e.X), g.expr(e.Y)
+	case *syntax.ListExpr:
+		for i, x := range e.List {
+			e.List[i] = g.target(x)
+		}
+	case *syntax.TupleExpr:
+		for i, x := range e.List {
+			e.List[i] = g.target(x)
+		}
+	case *syntax.ParenExpr:
+		e.X = g.target(e.X)
+	}
+	return e
+}
+
+func (g *allocRewriter) exprs(es []syntax.Expr) {
+	for i, e := range es {
+		es[i] = g.expr(e)
+	}
+}
+
+func (g *allocRewriter) expr(e syntax.Expr) syntax.Expr {
+	switch e := e.(type) {
+	case *syntax.BinaryExpr:
+		e.X, e.Y = g.expr(e.X), g.expr(e.Y)
+		switch e.Op {
+		case syntax.PLUS:
+			return guardCall("$add", e.OpPos, e.X, e.Y)
+		case syntax.STAR:
+			return guardCall("$mul", e.OpPos, e.X, e.Y)
+		case syntax.PERCENT:
+			return guardCall("$mod", e.OpPos, e.X, e.Y)
+		}
+	case *syntax.CallExpr:
+		e.Fn = g.expr(e.Fn)
+		g.exprs(e.Args)
+	case *syntax.DotExpr:
+		e.X = g.expr(e.X)
+		if guardedAttrs[e.Name.Name] {
+			name := &syntax.Literal{Token: syntax.STRING, TokenPos: e.Name.NamePos, Raw: `"` + e.Name.Name + `"`, Value: e.Name.Name}
+			return guardCall("$attr", e.Dot, e.X, name)
+		}
+	case *syntax.Comprehension:
+		e.Body = g.expr(e.Body)
+		for _, c := range e.Clauses {
+			switch c := c.(type) {
+			case *syntax.ForClause:
+				c.X = g.expr(c.X)
+			case *syntax.IfClause:
+				c.Cond = g.expr(c.Cond)
+			}
+		}
+	case *syntax.CondExpr:
+		e.Cond, e.True, e.False = g.expr(e.Cond), g.expr(e.True), g.expr(e.False)
+	case *syntax.DictExpr:
+		g.exprs(e.List)
+	case *syntax.DictEntry:
+		e.Key, e.Value = g.expr(e.Key), g.expr(e.Value)
+	case *syntax.IndexExpr:
+		e.X, e.Y = g.expr(e.X), g.expr(e.Y)
+	case *syntax.SliceExpr:
+		e.X, e.Lo, e.Hi, e.Step = g.expr(e.X), g.expr(e.Lo), g.expr(e.Hi), g.expr(e.Step)
+	case *syntax.LambdaExpr:
+		g.exprs(e.Params)
+		e.Body = g.expr(e.Body)
+	case *syntax.ListExpr:
+		g.exprs(e.List)
+	case *syntax.TupleExpr:
+		g.exprs(e.List)
+	case *syntax.ParenExpr:
+		e.X = g.expr(e.X)
+	case *syntax.UnaryExpr:
+		e.X = g.expr(e.X)
+	}
+	return e
+}
+
+func hasCall(e syntax.Expr) bool {
+	found := false
+	syntax.Walk(e, func(n syntax.Node) bool {
+		if _, ok := n.(*syntax.CallExpr); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func runScript(t *testing.T, body string) ([]float64, error) {
+	t.Helper()
+	src := "def compute(bars, params):\n" + body
+	cs, err := CompileScript(Script{Name: "Test", Source: src}, DefaultScriptLimits)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	return cs.Run(map[string]Series{"close": {1, 2, 3}}, nil)
+}
+
+func TestScriptRejectsLargeAllocations(t *testing.T) {
+	for _, body := range []string{
+		"    x = [0] * 40000000\n    return [1] * bars.len\n",
+		"    x = \"x\" * 600000000\n    return [1] * bars.len\n",
+		"    x = 40000000 * [0]\n    return [1] * bars.len\n",
+		// each step is under the limit, together they are not
+		"    x = \"x\" * 100000000\n    x += x\n    x = x + x\n    return [1] * bars.len\n",
+		"    x = \"-\" * 1000000\n    y = x.join([\"a\"] * 1000)\n    return [1] * bars.len\n",
+		"    x = list(range(1 << 30))\n    return [1] * bars.len\n",
+		// methods taken without calling them, or through getattr
+		"    j = \"-\".join\n    x = \"y\" * 1000000\n    s = j([x] * 500)\n    return [1] * bars.len\n",
+		"    j = getattr(\"-\", \"join\")\n    x = \"y\" * 1000000\n    s = j([x] * 500)\n    return [1] * bars.len\n",
+		"    a = [0] * 10000000\n    b = []\n    for i in range(40):\n        b.extend(a)\n    return [1] * bars.len\n",
+		"    x = \"y\" * 1000000\n    s = (\"{0}\" * 300).format(x)\n    return [1] * bars.len\n",
+		"    x = \"y\" * 1000000\n    s = (\"%s\" * 300) % tuple([x] * 300)\n    return [1] * bars.len\n",
+		"    x = \"y\" * 1000000\n    s = str([x] * 300)\n    return [1] * bars.len\n",
+	} {
+		_, err := runScript(t, body)
+		if err == nil || !strings.Contains(err.Error(), "memory limit") {
+			t.Errorf("%q: got %v, want the memory limit error", body, err)
+		}
+	}
+}
+
+func TestScriptGuardedOperators(t *testing.T) {
+	out, err := runScript(t, `
+    a = []
+    b = a
+    b += [1.0, 2.0]
+    b *= 1
+    s = "ab" + "c"
+    n = len(s) * 2 + len("-".join(["x", "y"]).replace("-", "--"))
+    j = getattr(",", "join")
+    a.extend([0.5])
+    m = len(j(["a", "b"])) + len("{}-{}".format(1, 2)) + len("%d%%" % 7) + len(str([1, "x"]))
+    return [a[0] + a[1] + a[2], n + m, 10 % 4 * 1.0]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{3.5, 26, 2}
+	for i := range want {
+		if out[i] != want[i] {
+			t.Fatalf("got %v, want %v", out, want)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+// engine/session.go
+package engine
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// Trading session of the stored candles, on the exchange clock. The data
+// layer only loads bars inside it and buckets them from SessionOpen.
+const (
+	SessionOpen    = "09:15"
+	SessionClose   = "15:30"
+	SessionMinutes = 375
+)
+
+// Session resets for VWAP, given as the "reset" param in days.
+const (
+	ResetDaily   = 1
+	ResetWeekly  = 7
+	ResetMonthly = 30
+)
+
+// sessionKey identifies the period t belongs to. A session never spans
+// midnight in either UTC or exchange time, so the UTC date is the session.
+func sessionKey(t time.Time, reset int) int {
+	t = t.UTC()
+	switch reset {
+	case ResetWeekly:
+		y, w := t.ISOWeek()
+		return y*100 + w
+	case ResetMonthly:
+		return t.Year()*100 + int(t.Month())
+	}
+	return t.Year()*1000 + t.YearDay()
+}
+
+// sessionTracker tells, one bar at a time, whether a bar opens a new period.
+type sessionTracker struct {
+	reset, key int
+	started    bool
+}
+
+func (st *sessionTracker) next(t time.Time) bool {
+	k := sessionKey(t, st.reset)
+	opens := !st.started || k != st.key
+	st.started, st.key = true, k
+	return opens
+}
+
+// sessionIndex numbers the periods of bars from 0; bars of one period share
+// a number.
+func sessionIndex(bars []domain.Candle, reset int) []int {
+	out := make([]int, len(bars))
+	st := sessionTracker{reset: reset}
+	n := -1
+	for i, b := range bars {
+		if st.next(b.Time) {
+			n++
+		}
+		out[i] = n
+	}
+	return out
+}
+
+// vwapAcc accumulates volume-weighted typical price one bar at a time,
+// restarting on bars where restart is true. Bands are mult volume-weighted
+// standard deviations.
+type vwapAcc struct{ pv, pv2, v float64 }
+
+func (a *vwapAcc) push(b domain.Candle, restart bool, mult float64) (vwap, upper, lower float64) {
+	if restart {
+		*a = vwapAcc{}
+	}
+	tp := (b.High + b.Low + b.Close) / 3
+	a.pv += tp * b.Volume
+	a.pv2 += tp * tp * b.Volume
+	a.v += b.Volume
+	if a.v == 0 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	m := a.pv / a.v
+	sd := math.Sqrt(math.Max(0, a.pv2/a.v-m*m))
+	return m, m + mult*sd, m - mult*sd
+}
+
+// SessionVWAP is VWAP with bands that resets at each daily, weekly or
+// monthly session boundary.
+func SessionVWAP(bars []domain.Candle, reset int, mult float64) (vwap, upper, lower []float64) {
+	n := len(bars)
+	vwap, upper, lower = make([]float64, n), make([]float64, n), make([]float64, n)
+	st := sessionTracker{reset: reset}
+	var acc vwapAcc
+	for i, b := range bars {
+		vwap[i], upper[i], lower[i] = acc.push(b, st.next(b.Time), mult)
+	}
+	return
+}
+
+// anchoredVWAP is a VWAP that starts on the first bar at or after anchor and
+// restarts wherever the event value is 0; NaN before it first starts.
+type anchoredVWAP struct {
+	acc            vwapAcc
+	anchor         time.Time
+	anchored, live bool
+}
+
+func (a *anchoredVWAP) push(b domain.Candle, event, mult float64) (vwap, upper, lower float64) {
+	restart := event == 0
+	if !a.anchored && !b.Time.Before(a.anchor) {
+		restart, a.anchored = true, true
+	}
+	a.live = a.live || restart
+	vwap, upper, lower = a.acc.push(b, restart, mult)
+	if !a.live {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	return
+}
+
+// prevSession gives each bar, one at a time, the high, low and close of
+// the previous daily session; NaN during the first session.
+type prevSession struct {
+	sess                 sessionTracker
+	ph, pl, pc           float64
+	high, low, lastClose float64
+	seen                 bool
+}
+
+func newPrevSession() *prevSession {
+	return &prevSession{sess: sessionTracker{reset: ResetDaily}, ph: math.NaN(), pl: math.NaN(), pc: math.NaN()}
+}
+
+func (p *prevSession) push(b domain.Candle) (high, low, close float64) {
+	if p.sess.next(b.Time) {
+		if p.seen {
+			p.ph, p.pl, p.pc = p.high, p.low, p.lastClose
+		}
+		p.high, p.low = b.High, b.Low
+	} else {
+		p.high, p.low = math.Max(p.high, b.High), math.Min(p.low, b.Low)
+	}
+	p.seen, p.lastClose = true, b.Close
+	return p.ph, p.pl, p.pc
+}
+
+// PrevSessionHLC gives every bar the high, low and close of the previous
+// daily session; NaN during the first session.
+func PrevSessionHLC(bars []domain.Candle) (high, low, close []float64) {
+	n := len(bars)
+	high, low, close = make([]float64, n), make([]float64, n), make([]float64, n)
+	p := newPrevSession()
+	for i, b := range bars {
+		high[i], low[i], close[i] = p.push(b)
+	}
+	return
+}
+
+// openingRange is SessionOpeningRange one bar at a time.
+type openingRange struct {
+	sess        sessionTracker
+	bar, window time.Duration
+	end         time.Time
+	high, low   float64
+}
+
+func newOpeningRange(barMinutes, minutes int) *openingRange {
+	return &openingRange{
+		sess:   sessionTracker{reset: ResetDaily},
+		bar:    time.Duration(barMinutes) * time.Minute,
+		window: time.Duration(minutes) * time.Minute,
+	}
+}
+
+func (o *openingRange) push(b domain.Candle) (high, low float64) {
+	if o.sess.next(b.Time) {
+		o.end, o.high, o.low = b.Time.Add(o.window), b.High, b.Low
+	}
+	inside := b.Time.Before(o.end)
+	if inside {
+		o.high, o.low = math.Max(o.high, b.High), math.Min(o.low, b.Low)
+	}
+	if inside && b.Time.Add(o.bar).Before(o.end) {
+		return math.NaN(), math.NaN()
+	}
+	return o.high, o.low
+}
+
+// SessionOpeningRange is the high and low of each session's first minutes,
+// for bars barMinutes long. Values appear on the bar whose end reaches the
+// end of the window and hold for the rest of the session; earlier bars are
+// NaN. A bar's value never depends on the bars after it.
+func SessionOpeningRange(bars []domain.Candle, barMinutes, minutes int) (high, low []float64) {
+	n := len(bars)
+	high, low = make([]float64, n), make([]float64, n)
+	o := newOpeningRange(barMinutes, minutes)
+	for i, b := range bars {
+		high[i], low[i] = o.push(b)
+	}
+	return
+}
+
+// pivotLevel picks one level of a pivot family: 0 is the pivot, +k the k-th
+// resistance and -k the k-th support.
+func pivotLevel(family string, level int, h, l, c float64) (float64, error) {
+	switch family {
+	case "classic":
+		pp, r1, r2, r3, s1, s2, s3 := PivotClassic(h, l, c)
+		if v, ok := map[int]float64{0: pp, 1: r1, 2: r2, 3: r3, -1: s1, -2: s2, -3: s3}[level]; ok {
+			return v, nil
+		}
+	case "camarilla":
+		h1, h2, h3, h4, l1, l2, l3, l4 := CamarillaPivots(h, l, c)
+		if v, ok := map[int]float64{1: h1, 2: h2, 3: h3, 4: h4, -1: l1, -2: l2, -3: l3, -4: l4}[level]; ok {
+			return v, nil
+		}
+	case "cpr":
+		pivot, tc, bc := CentralPivotRange(h, l, c)
+		if v, ok := map[int]float64{0: pivot, 1: tc, -1: bc}[level]; ok {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("%s pivot has no level %d", family, level)
+}
+
+// sessionPivots computes a pivot level per bar from the previous session.
+func sessionPivots(bars []domain.Candle, family string, level int) ([]float64, error) {
+	if _, err := pivotLevel(family, level, 0, 0, 0); err != nil {
+		return nil, err
+	}
+	p := newPrevSession()
+	out := make([]float64, len(bars))
+	for i, b := range bars {
+		h, l, c := p.push(b)
+		out[i], _ = pivotLevel(family, level, h, l, c)
+	}
+	return out, nil
+}
+
+func floatParam(params map[string]float64, k string, def float64) float64 {
+	if v, ok := params[k]; ok {
+		return v
+	}
+	return def
+}
+
+func registerSession(reg *Registry) {
+	bands := func(desc string, pick int) IndicatorSpec {
+		return IndicatorSpec{
+			Category:    "Volume",
+			Description: desc,
+			Params: []ArgSpec{
+				{Name: "reset", Type: "int"},  // 1 daily (default), 7 weekly, 30 monthly
+				{Name: "mult", Type: "float"}, // band width in std devs, default 1
+			},
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe,
+				params map[string]float64, offset int, args ...Series) ([]float64, error) {
+				reset := int(floatParam(params, "reset", ResetDaily))
+				if reset != ResetDaily && reset != ResetWeekly && reset != ResetMonthly {
+					return nil, fmt.Errorf("VWAP reset must be %d, %d or %d", ResetDaily, ResetWeekly, ResetMonthly)
+				}
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				v, u, l := SessionVWAP(bars, reset, floatParam(params, "mult", 1))
+				return shiftBack([][]float64{v, u, l}[pick], offset)
+			},
+			Stream: func(_ *EvalCtx, params map[string]float64) IndicatorStep {
+				reset := int(floatParam(params, "reset", ResetDaily))
+				if reset != ResetDaily && reset != ResetWeekly && reset != ResetMonthly {
+					return nil
+				}
+				mult := floatParam(params, "mult", 1)
+				st := sessionTracker{reset: reset}
+				var acc vwapAcc
+				return func(bar domain.Candle, _ []float64) float64 {
+					v, u, l := acc.push(bar, st.next(bar.Time), mult)
+					return [3]float64{v, u, l}[pick]
+				}
+			},
+		}
+	}
+	reg.Indicators["VWAP"] = bands("VWAP reset each session (reset=1 daily, 7 weekly, 30 monthly)", 0)
+	reg.Indicators["VWAPUpper"] = bands("Session VWAP + mult standard deviations", 1)
+	reg.Indicators["VWAPLower"] = bands("Session VWAP - mult standard deviations", 2)
+
+	anchored := func(desc string, pick int) IndicatorSpec {
+		return IndicatorSpec{
+			Category:    "Volume",
+			Description: desc,
+			Params: []ArgSpec{
+				{Name: "anchor", Type: "int"}, // unix seconds; the bar at or after it starts the VWAP
+				{Name: "mult", Type: "float"},
+			},
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe,
+				params map[string]float64, offset int, args ...Series) ([]float64, error) {
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				if len(args) > 0 && len(args[0]) != len(bars) {
+					return nil, fmt.Errorf("anchored VWAP event series has %d bars, %s has %d", len(args[0]), tf, len(bars))
+				}
+				a := anchoredVWAP{anchor: time.Unix(int64(params["anchor"]), 0)}
+				mult := floatParam(params, "mult", 1)
+				out := make(Series, len(bars))
+				for i, b := range bars {
+					// an event series restarts the VWAP wherever it is 0,
+					// e.g. bars_since(<condition>)
+					event := math.NaN()
+					if len(args) > 0 {
+						event = args[0][i]
+					}
+					v, u, l := a.push(b, event, mult)
+					out[i] = [3]float64{v, u, l}[pick]
+				}
+				return shiftBack(out, offset)
+			},
+			Stream: func(_ *EvalCtx, params map[string]float64) IndicatorStep {
+				a := anchoredVWAP{anchor: time.Unix(int64(params["anchor"]), 0)}
+				mult := floatParam(params, "mult", 1)
+				return func(bar domain.Candle, args []float64) float64 {
+					event := math.NaN()
+					if len(args) > 0 {
+						event = args[0]
+					}
+					v, u, l := a.push(bar, event, mult)
+					return [3]float64{v, u, l}[pick]
+				}
+			},
+		}
+	}
+	reg.Indicators["AVWAP"] = anchored("VWAP anchored at a timestamp, or restarted where the arg series is 0", 0)
+	reg.Indicators["AVWAPUpper"] = anchored("Anchored VWAP + mult standard deviations", 1)
+	reg.Indicators["AVWAPLower"] = anchored("Anchored VWAP - mult standard deviations", 2)
+
+	prev := func(desc string, pick int) IndicatorSpec {
+		return IndicatorSpec{
+			Category:    "Price",
+			Description: desc,
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe,
+				_ map[string]float64, offset int, args ...Series) ([]float64, error) {
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				h, l, c := PrevSessionHLC(bars)
+				return shiftBack([][]float64{h, l, c}[pick], offset)
+			},
+			Stream: func(*EvalCtx, map[string]float64) IndicatorStep {
+				p := newPrevSession()
+				return func(bar domain.Candle, _ []float64) float64 {
+					h, l, c := p.push(bar)
+					return [3]float64{h, l, c}[pick]
+				}
+			},
+		}
+	}
+	reg.Indicators["PrevDayHigh"] = prev("Previous session high", 0)
+	reg.Indicators["PrevDayLow"] = prev("Previous session low", 1)
+	reg.Indicators["PrevDayClose"] = prev("Previous session close", 2)
+
+	pivots := func(family, desc string) IndicatorSpec {
+		return IndicatorSpec{
+			Category:    "Pivot",
+			Description: desc,
+			Params:      []ArgSpec{{Name: "level", Type: "int"}},
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe,
+				params map[string]float64, offset int, args ...Series) ([]float64, error) {
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				s, err := sessionPivots(bars, family, int(params["level"]))
+				if err != nil {
+					return nil, err
+				}
+				return shiftBack(s, offset)
+			},
+			Stream: func(_ *EvalCtx, params map[string]float64) IndicatorStep {
+				level := int(params["level"])
+				if _, err := pivotLevel(family, level, 0, 0, 0); err != nil {
+					return nil
+				}
+				p := newPrevSession()
+				return func(bar domain.Candle, _ []float64) float64 {
+					h, l, c := p.push(bar)
+					v, _ := pivotLevel(family, level, h, l, c)
+					return v
+				}
+			},
+		}
+	}
+	reg.Indicators["Pivot"] = pivots("classic", "Classic pivot from the previous session: level 0 PP, 1..3 R1..R3, -1..-3 S1..S3")
+	reg.Indicators["Camarilla"] = pivots("camarilla", "Camarilla level from the previous session: 1..4 H1..H4, -1..-4 L1..L4")
+	reg.Indicators["CPR"] = pivots("cpr", "Central pivot range from the previous session: level 0 pivot, 1 top, -1 bottom")
+
+	orange := func(desc string, pick int) IndicatorSpec {
+		return IndicatorSpec{
+			Category:    "Price",
+			Description: desc,
+			Params:      []ArgSpec{{Name: "minutes", Type: "int"}}, // default 15
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe,
+				params map[string]float64, offset int, args ...Series) ([]float64, error) {
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				h, l := SessionOpeningRange(bars, domain.TimeframeToMinutes[tf],
+					int(floatParam(params, "minutes", 15)))
+				return shiftBack([][]float64{h, l}[pick], offset)
+			},
+			Stream: func(ctx *EvalCtx, params map[string]float64) IndicatorStep {
+				o := newOpeningRange(domain.TimeframeToMinutes[ctx.BaseTF], int(floatParam(params, "minutes", 15)))
+				return func(bar domain.Candle, _ []float64) float64 {
+					h, l := o.push(bar)
+					return [2]float64{h, l}[pick]
+				}
+			},
+		}
+	}
+	reg.Indicators["ORHigh"] = orange("High of today's first minutes (opening range)", 0)
+	reg.Indicators["ORLow"] = orange("Low of today's first minutes (opening range)", 1)
+}
@@ -0,0 +1,438 @@
+// engine/stats.go
+package engine
+
+import (
+	"fmt"
+	"math"
+)
+
+// Rolling statistics over the last p values of any expression. A window is
+// NaN until it is full and whenever it holds a NaN.
+
+// rolling applies f to every full window of values.
+func rolling(values []float64, p int, f func(win []float64) float64) []float64 {
+	out := make([]float64, len(values))
+	if p <= 0 {
+		for i := range out {
+			out[i] = math.NaN()
+		}
+		return out
+	}
+	bad := 0
+	for i, v := range values {
+		if math.IsNaN(v) {
+			bad++
+		}
+		if i >= p && math.IsNaN(values[i-p]) {
+			bad--
+		}
+		if i+1 < p || bad > 0 {
+			out[i] = math.NaN()
+			continue
+		}
+		out[i] = f(values[i-p+1 : i+1])
+	}
+	return out
+}
+
+// rolling2 is rolling over two aligned series.
+func rolling2(x, y []float64, p int, f func(xw, yw []float64) float64) []float64 {
+	n := minInt(len(x), len(y))
+	out := make([]float64, n)
+	for i := 0; i < n; i++ {
+		out[i] = math.NaN()
+		if p <= 0 || i+1 < p {
+			continue
+		}
+		xw, yw := x[i-p+1:i+1], y[i-p+1:i+1]
+		if hasNaN(xw) || hasNaN(yw) {
+			continue
+		}
+		out[i] = f(xw, yw)
+	}
+	return out
+}
+
+func hasNaN(xs []float64) bool {
+	for _, v := range xs {
+		if math.IsNaN(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Highest is the rolling maximum.
+func Highest(values []float64, p int) []float64 { return rolling(values, p, highestOf) }
+
+// Lowest is the rolling minimum.
+func Lowest(values []float64, p int) []float64 { return rolling(values, p, lowestOf) }
+
+// HighestIndex is how many bars ago the rolling maximum was, 0 for the
+// current bar. Ties go to the most recent bar.
+func HighestIndex(values []float64, p int) []float64 { return rolling(values, p, highestIndexOf) }
+
+// LowestIndex is how many bars ago the rolling minimum was.
+func LowestIndex(values []float64, p int) []float64 { return rolling(values, p, lowestIndexOf) }
+
+func highestOf(w []float64) float64      { return w[argExtreme(w, true)] }
+func lowestOf(w []float64) float64       { return w[argExtreme(w, false)] }
+func highestIndexOf(w []float64) float64 { return float64(len(w) - 1 - argExtreme(w, true)) }
+func lowestIndexOf(w []float64) float64  { return float64(len(w) - 1 - argExtreme(w, false)) }
+
+// argExtreme is the index of the last maximum (or minimum) of w.
+func argExtreme(w []float64, highest bool) int {
+	k := 0
+	for i, v := range w {
+		if (highest && v >= w[k]) || (!highest && v <= w[k]) {
+			k = i
+		}
+	}
+	return k
+}
+
+// windowSums keeps what Sum, StdDev, ZScore and the LinReg functions need
+// about the last p values, updated as one value enters the window and
+// another leaves, so a bar costs O(1) rather than O(p). The sums are
+// recomputed from the window every p values, and when an infinity leaves
+// it, so that rounding cannot build up. The batch functions and their
+// stream steps run the same updates and so agree exactly.
+type windowSums struct {
+	p, n int
+	ring []float64
+	// bad counts the NaNs in the window, which the sums skip; run is how
+	// many of the latest values are equal, so a flat window is known to be
+	// flat whatever the rounding
+	bad, run int
+	// sum and sxy are the sums of y and i*y with i the position in the
+	// window, oldest 0
+	sum, sxy float64
+	// mean and m2 are Welford's mean and sum of squared deviations over
+	// the k values that are not NaN
+	k        int
+	mean, m2 float64
+}
+
+func newWindowSums(p int) *windowSums { return &windowSums{p: p, ring: make([]float64, maxInt(p, 1))} }
+
+func (w *windowSums) push(v float64) {
+	if w.p <= 0 {
+		return
+	}
+	i := w.n % w.p
+	old := w.ring[i]
+	if w.n > 0 && v == w.ring[(w.n-1)%w.p] {
+		w.run++
+	} else {
+		w.run = 1
+	}
+	w.ring[i] = v
+	w.n++
+	switch {
+	case w.n%w.p == 0 || (w.n > w.p && math.IsInf(old, 0)):
+		w.recompute()
+	case w.n > w.p:
+		w.remove(old)
+		w.add(v, w.p-1)
+	default:
+		w.add(v, w.n-1)
+	}
+}
+
+// add counts v at position pos of the window.
+func (w *windowSums) add(v float64, pos int) {
+	if math.IsNaN(v) {
+		w.bad++
+		return
+	}
+	w.sum, w.sxy = w.sum+v, w.sxy+float64(pos)*v
+	w.k++
+	d := v - w.mean
+	w.mean += d / float64(w.k)
+	w.m2 += d * (v - w.mean)
+}
+
+// remove takes the oldest value v out of a full window, moving the others
+// one position down.
+func (w *windowSums) remove(v float64) {
+	if math.IsNaN(v) {
+		w.bad--
+		w.sxy -= w.sum
+		return
+	}
+	w.sum -= v
+	w.sxy -= w.sum
+	w.k--
+	if w.k == 0 {
+		w.mean, w.m2 = 0, 0
+		return
+	}
+	d := v - w.mean
+	w.mean -= d / float64(w.k)
+	w.m2 -= d * (v - w.mean)
+}
+
+func (w *windowSums) recompute() {
+	*w = windowSums{p: w.p, n: w.n, ring: w.ring, run: w.run}
+	for j := 0; j < w.p; j++ {
+		w.add(w.ring[(w.n+j)%w.p], j)
+	}
+}
+
+// full is whether the window holds p values and no NaN.
+func (w *windowSums) full() bool { return w.p > 0 && w.n >= w.p && w.bad == 0 }
+
+func (w *windowSums) total() float64 {
+	if !w.full() {
+		return math.NaN()
+	}
+	return w.sum
+}
+
+// std is the population standard deviation, as StdDev.
+func (w *windowSums) std() float64 {
+	if !w.full() {
+		return math.NaN()
+	}
+	if w.run >= w.p {
+		return 0
+	}
+	return math.Sqrt(math.Max(0, w.m2) / float64(w.p))
+}
+
+func (w *windowSums) zscore() float64 {
+	sd := w.std()
+	if sd == 0 || math.IsNaN(sd) {
+		return math.NaN()
+	}
+	return (w.ring[(w.n-1)%w.p] - w.mean) / sd
+}
+
+// linReg fits y = intercept + slope*x by least squares with x = 0..p-1, so
+// the intercept is the line's value p-1 bars ago and the fitted value on
+// the current bar is intercept + slope*(p-1).
+func (w *windowSums) linReg() (slope, intercept, r2 float64) {
+	if !w.full() {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	n := float64(w.p)
+	sx, sxx := n*(n-1)/2, (n-1)*n*(2*n-1)/6
+	// n*syy - sum² loses the variance of prices far from zero; m2 keeps it
+	vx, vy, cxy := n*sxx-sx*sx, n*math.Max(0, w.m2), n*w.sxy-sx*w.sum
+	if w.run >= w.p {
+		vy, cxy = 0, 0
+	}
+	if vx == 0 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	slope = cxy / vx
+	intercept = (w.sum - slope*sx) / n
+	r2 = math.NaN()
+	if vy > 0 {
+		r2 = cxy * cxy / (vx * vy)
+	}
+	return
+}
+
+func (w *windowSums) slope() float64     { s, _, _ := w.linReg(); return s }
+func (w *windowSums) intercept() float64 { _, c, _ := w.linReg(); return c }
+func (w *windowSums) r2() float64        { _, _, r2 := w.linReg(); return r2 }
+
+// forecast extends the line ahead bars past the current bar.
+func (w *windowSums) forecast(ahead int) float64 {
+	s, c, _ := w.linReg()
+	return c + s*float64(w.p-1+ahead)
+}
+
+// runSums feeds values through a windowSums and reads f after each.
+func runSums(values []float64, p int, f func(w *windowSums) float64) []float64 {
+	w := newWindowSums(p)
+	out := make([]float64, len(values))
+	for i, v := range values {
+		w.push(v)
+		out[i] = f(w)
+	}
+	return out
+}
+
+// sumsStep is runSums one value at a time.
+func sumsStep(p int, f func(w *windowSums) float64) FunctionStep {
+	w := newWindowSums(p)
+	return func(args []float64) float64 {
+		w.push(args[0])
+		return f(w)
+	}
+}
+
+// Sum is the rolling sum.
+func Sum(values []float64, p int) []float64 { return runSums(values, p, (*windowSums).total) }
+
+// RollingStdDev is StdDev that skips windows holding a NaN instead of
+// carrying it forward, so it can follow an indicator's warm-up.
+func RollingStdDev(values []float64, p int) []float64 { return runSums(values, p, (*windowSums).std) }
+
+// ZScore is how many rolling standard deviations the value is from the
+// rolling mean; NaN when the window is flat.
+func ZScore(values []float64, p int) []float64 { return runSums(values, p, (*windowSums).zscore) }
+
+// RollingPercentRank is the percentage (0..100) of the previous p values
+// that are at or below the current value.
+func RollingPercentRank(values []float64, p int) []float64 {
+	return rolling(values, p+1, percentRankOf)
+}
+
+func percentRankOf(w []float64) float64 { return 100 * PercentRank(w[:len(w)-1], w[len(w)-1]) }
+
+// LinRegSlope is the per-bar slope of the rolling least-squares line.
+func LinRegSlope(values []float64, p int) []float64 { return runSums(values, p, (*windowSums).slope) }
+
+// LinRegIntercept is the rolling line's value on the oldest bar of the window.
+func LinRegIntercept(values []float64, p int) []float64 {
+	return runSums(values, p, (*windowSums).intercept)
+}
+
+// LinRegR2 is the coefficient of determination of the rolling line.
+func LinRegR2(values []float64, p int) []float64 { return runSums(values, p, (*windowSums).r2) }
+
+// LinRegForecast extends the rolling line ahead bars past the current bar;
+// ahead 0 is the fitted value on the current bar.
+func LinRegForecast(values []float64, p, ahead int) []float64 {
+	return runSums(values, p, func(w *windowSums) float64 { return w.forecast(ahead) })
+}
+
+// covariance returns the sample covariance of x and y and their variances.
+func covariance(x, y []float64) (cov, vx, vy float64) {
+	n := float64(len(x))
+	var mx, my float64
+	for i := range x {
+		mx, my = mx+x[i], my+y[i]
+	}
+	mx, my = mx/n, my/n
+	for i := range x {
+		dx, dy := x[i]-mx, y[i]-my
+		cov, vx, vy = cov+dx*dy, vx+dx*dx, vy+dy*dy
+	}
+	return cov / (n - 1), vx / (n - 1), vy / (n - 1)
+}
+
+// Correlation is the rolling Pearson correlation of x and y.
+func Correlation(x, y []float64, p int) []float64 { return rolling2(x, y, p, correlationOf) }
+
+// Beta is the rolling regression coefficient of x on y: cov(x, y) / var(y).
+// Pass returns for a market beta.
+func Beta(x, y []float64, p int) []float64 { return rolling2(x, y, p, betaOf) }
+
+func correlationOf(xw, yw []float64) float64 {
+	cov, vx, vy := covariance(xw, yw)
+	if vx == 0 || vy == 0 {
+		return math.NaN()
+	}
+	return cov / math.Sqrt(vx*vy)
+}
+
+func betaOf(xw, yw []float64) float64 {
+	cov, _, vy := covariance(xw, yw)
+	if vy == 0 {
+		return math.NaN()
+	}
+	return cov / vy
+}
+
+func registerStats(reg *Registry) {
+	period := []ArgSpec{{Name: "period", Type: "int", Req: true}}
+	window := func(params map[string]any) int { return intParam(params, "period") - 1 }
+
+	unary := func(name, desc string, f func(values []float64, p int) []float64,
+		step func(p int) FunctionStep, lookback func(params map[string]any) int) {
+		reg.Functions[name] = FunctionSpec{
+			Category:    "Statistics",
+			Description: desc,
+			Params:      period,
+			Eval: func(ctx *EvalCtx, params map[string]any, args ...Series) ([]float64, error) {
+				if len(args) != 1 {
+					return nil, fmt.Errorf("%s requires one input series", name)
+				}
+				p := intParam(params, "period")
+				if p < 1 {
+					return nil, fmt.Errorf("%s period must be at least 1", name)
+				}
+				return f(args[0], p), nil
+			},
+			Lookback: lookback,
+			Stream:   func(params map[string]any) FunctionStep { return step(intParam(params, "period")) },
+		}
+	}
+	// kernel steps recompute f over the window, sums steps update windowSums
+	kernel := func(f func(w []float64) float64, extra int) func(p int) FunctionStep {
+		return func(p int) FunctionStep {
+			return windowStep(p+extra, 1, func(w ...[]float64) float64 { return f(w[0]) })
+		}
+	}
+	sums := func(f func(w *windowSums) float64) func(p int) FunctionStep {
+		return func(p int) FunctionStep { return sumsStep(p, f) }
+	}
+	unary("Highest", "Highest value over period bars", Highest, kernel(highestOf, 0), window)
+	unary("Lowest", "Lowest value over period bars", Lowest, kernel(lowestOf, 0), window)
+	unary("HighestIndex", "Bars since the highest value of the last period bars (0 = this bar)", HighestIndex,
+		kernel(highestIndexOf, 0), window)
+	unary("LowestIndex", "Bars since the lowest value of the last period bars (0 = this bar)", LowestIndex,
+		kernel(lowestIndexOf, 0), window)
+	unary("Sum", "Sum over period bars", Sum, sums((*windowSums).total), window)
+	unary("StdDev", "Standard deviation over period bars", RollingStdDev, sums((*windowSums).std), window)
+	unary("ZScore", "Distance from the period mean in standard deviations", ZScore, sums((*windowSums).zscore), window)
+	unary("PercentRank", "Percent (0-100) of the previous period values at or below this one", RollingPercentRank,
+		kernel(percentRankOf, 1), func(params map[string]any) int { return intParam(params, "period") })
+	unary("LinRegSlope", "Slope per bar of the least-squares line over period bars", LinRegSlope,
+		sums((*windowSums).slope), window)
+	unary("LinRegIntercept", "Least-squares line value at the oldest bar of the window", LinRegIntercept,
+		sums((*windowSums).intercept), window)
+	unary("LinRegR2", "R² of the least-squares line over period bars", LinRegR2, sums((*windowSums).r2), window)
+
+	reg.Functions["LinReg"] = FunctionSpec{
+		Category:    "Statistics",
+		Description: "Least-squares line over period bars, projected ahead bars past this one (default 0)",
+		Params: []ArgSpec{
+			{Name: "period", Type: "int", Req: true},
+			{Name: "ahead", Type: "int"},
+		},
+		Eval: func(ctx *EvalCtx, params map[string]any, args ...Series) ([]float64, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("LinReg requires one input series")
+			}
+			p := intParam(params, "period")
+			if p < 2 {
+				return nil, fmt.Errorf("LinReg period must be at least 2")
+			}
+			return LinRegForecast(args[0], p, intParam(params, "ahead")), nil
+		},
+		Lookback: window,
+		Stream: func(params map[string]any) FunctionStep {
+			ahead := intParam(params, "ahead")
+			return sumsStep(intParam(params, "period"), func(w *windowSums) float64 { return w.forecast(ahead) })
+		},
+	}
+
+	binary := func(name, desc string, f func(x, y []float64, p int) []float64, kernel func(xw, yw []float64) float64) {
+		reg.Functions[name] = FunctionSpec{
+			Category:    "Statistics",
+			Description: desc,
+			Params:      period,
+			Eval: func(ctx *EvalCtx, params map[string]any, args ...Series) ([]float64, error) {
+				if len(args) != 2 {
+					return nil, fmt.Errorf("%s requires two input series", name)
+				}
+				p := intParam(params, "period")
+				if p < 2 {
+					return nil, fmt.Errorf("%s period must be at least 2", name)
+				}
+				return f(args[0], args[1], p), nil
+			},
+			Lookback: window,
+			Stream: func(params map[string]any) FunctionStep {
+				return windowStep(intParam(params, "period"), 2, func(w ...[]float64) float64 { return kernel(w[0], w[1]) })
+			},
+		}
+	}
+	binary("Correlation", "Pearson correlation of two series over period bars", Correlation, correlationOf)
+	binary("Beta", "Beta of the first series against the second over period bars", Beta, betaOf)
+}
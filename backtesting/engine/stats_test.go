@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"math"
+	"testing"
+)
+
+// TestRunningStatsMatchWindows checks the statistics kept as running sums
+// against the same statistics computed over each window afresh, on prices
+// far from zero with NaNs, an infinity and a flat stretch.
+func TestRunningStatsMatchWindows(t *testing.T) {
+	xs := candleSeries(streamCandles(600))["close"]
+	for i := range xs {
+		xs[i] *= 200
+	}
+	xs[40], xs[300] = math.NaN(), math.Inf(1)
+	for i := 400; i < 460; i++ {
+		xs[i] = xs[400]
+	}
+	for _, p := range []int{2, 5, 20} {
+		sum, sd, z, slope := Sum(xs, p), RollingStdDev(xs, p), ZScore(xs, p), LinRegSlope(xs, p)
+		for i := p - 1; i < len(xs); i++ {
+			w := xs[i-p+1 : i+1]
+			if hasNaN(w) || math.IsInf(sum[i], 0) {
+				continue
+			}
+			var s, sxy float64
+			for j, v := range w {
+				s, sxy = s+v, sxy+float64(j)*v
+			}
+			mean := s / float64(p)
+			var ss float64
+			for _, v := range w {
+				ss += (v - mean) * (v - mean)
+			}
+			n := float64(p)
+			wantSlope := (n*sxy - n*(n-1)/2*s) / (n * n * (n*n - 1) / 12)
+			wantZ := (w[p-1] - mean) / math.Sqrt(ss/n)
+			if flat := i >= 400+p-1 && i < 460; flat {
+				wantZ = math.NaN()
+			}
+			for _, c := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"Sum", sum[i], s},
+				{"StdDev", sd[i], math.Sqrt(ss / n)},
+				{"LinRegSlope", slope[i], wantSlope},
+			} {
+				if math.Abs(c.got-c.want) > 1e-6*math.Max(1, math.Abs(c.want)) {
+					t.Fatalf("%s(%d) at %d: %v, want %v", c.name, p, i, c.got, c.want)
+				}
+			}
+			if math.IsNaN(wantZ) != math.IsNaN(z[i]) || math.Abs(z[i]-wantZ) > 1e-3 {
+				t.Fatalf("ZScore(%d) at %d: %v, want %v", p, i, z[i], wantZ)
+			}
+		}
+	}
+}
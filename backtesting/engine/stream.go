@@ -0,0 +1,568 @@
+// engine/stream.go
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// Stream evaluates a plan one bar at a time for live signals. Each node
+// keeps the state it needs (EMA value, RSI averages, the previous operands
+// of a cross, a rolling window) and advances it once per bar, so a bar costs
+// O(1) per node, O(window) for rolling statistics and patterns.
+//
+// After each Push the values of every node on the new bar equal those of
+// ExecPlan over all bars pushed so far. Nodes whose spec has no Stream step,
+// and nodes on another timeframe, are re-evaluated with Eval over that
+// history on every bar instead: still equal, but O(history); see Fallbacks.
+// Where the batch run would fail only because the history is shorter than
+// an offset or warm-up, the stream gives NaN (false) instead.
+type Stream struct {
+	ctx   *EvalCtx
+	plan  *Plan
+	nodes []*streamNode
+	index map[string]int
+	// bars is the history, kept only when some node falls back
+	bars     []domain.Candle
+	keepBars bool
+	n        int
+}
+
+type streamNode struct {
+	n    *PlanNode
+	deps []int
+	val  float64
+	bval bool
+	// step advances the node to the bar and sets val or bval
+	step func(s *Stream, bar domain.Candle) error
+	// sub and lookback are set for nodes evaluated over the history
+	sub      *Plan
+	lookback int
+}
+
+// NewStream prepares pl for incremental evaluation with the symbol, base
+// timeframe, data provider, registry and policy of ctx.
+func NewStream(ctx *EvalCtx, pl *Plan) (*Stream, error) {
+	s := &Stream{ctx: ctx, plan: pl, index: make(map[string]int, len(pl.Order))}
+	lookback := planLookbacks(pl.Order)
+	for i, n := range pl.Order {
+		sn := &streamNode{n: n}
+		for _, d := range n.Deps {
+			k, ok := s.index[d.ID]
+			if !ok {
+				return nil, fmt.Errorf("plan order: %s before its dependency %s", n.ID, d.ID)
+			}
+			sn.deps = append(sn.deps, k)
+		}
+		step, err := s.stepFor(sn)
+		if err != nil {
+			return nil, err
+		}
+		if step == nil {
+			sn.sub = subPlan(pl, n)
+			sn.lookback = lookback[n.ID]
+			step = fallbackStep(sn)
+			s.keepBars = true
+		}
+		sn.step = step
+		s.nodes = append(s.nodes, sn)
+		s.index[n.ID] = i
+	}
+	return s, nil
+}
+
+// Push evaluates the plan on the next bar and returns the root's value.
+func (s *Stream) Push(bar domain.Candle) (bool, error) {
+	// the batch path sees times as whole unix seconds
+	bar.Time = time.Unix(bar.Time.Unix(), 0)
+	if s.keepBars {
+		s.bars = append(s.bars, bar)
+	}
+	for _, sn := range s.nodes {
+		if err := sn.step(s, bar); err != nil {
+			return false, fmt.Errorf("%s: %w", sn.n.Label(), err)
+		}
+	}
+	s.n++
+	return s.nodes[s.index[s.plan.Roots[0].ID]].bval, nil
+}
+
+// Len is the number of bars pushed.
+func (s *Stream) Len() int { return s.n }
+
+// Value returns a series node's value on the last bar.
+func (s *Stream) Value(id string) (float64, bool) {
+	k, ok := s.index[id]
+	if !ok || s.nodes[k].n.Kind == NodeBool {
+		return 0, false
+	}
+	return s.nodes[k].val, true
+}
+
+// Bool returns a bool node's value on the last bar.
+func (s *Stream) Bool(id string) (bool, bool) {
+	k, ok := s.index[id]
+	if !ok || s.nodes[k].n.Kind != NodeBool {
+		return false, false
+	}
+	return s.nodes[k].bval, true
+}
+
+// Fallbacks lists the labels of nodes re-evaluated over the whole history on
+// every bar.
+func (s *Stream) Fallbacks() []string {
+	var out []string
+	for _, sn := range s.nodes {
+		if sn.sub != nil {
+			out = append(out, sn.n.Label())
+		}
+	}
+	return out
+}
+
+func (sn *streamNode) args(s *Stream) []float64 {
+	out := make([]float64, len(sn.deps))
+	for i, k := range sn.deps {
+		out[i] = s.nodes[k].val
+	}
+	return out
+}
+
+func (s *Stream) dep(sn *streamNode, i int) *streamNode { return s.nodes[sn.deps[i]] }
+
+// stepFor returns the incremental step of a node, or nil when it must be
+// evaluated over the history.
+func (s *Stream) stepFor(sn *streamNode) (func(*Stream, domain.Candle) error, error) {
+	n := sn.n
+	switch n.Op {
+	case "const":
+		v := n.Meta["value"].(float64)
+		return func(*Stream, domain.Candle) error { sn.val = v; return nil }, nil
+
+	case "bconst":
+		v := n.Meta["value"].(bool)
+		return func(*Stream, domain.Candle) error { sn.bval = v; return nil }, nil
+
+	case "indicator":
+		spec, ok := s.ctx.Reg.Indicators[n.Meta["name"].(string)]
+		if !ok {
+			return nil, fmt.Errorf("unknown indicator %s", n.Meta["name"])
+		}
+		if spec.Stream == nil || n.Meta["tf"].(domain.Timeframe) != s.ctx.BaseTF {
+			return nil, nil
+		}
+		step := spec.Stream(s.ctx, n.Meta["params"].(map[string]float64))
+		if step == nil {
+			return nil, nil
+		}
+		delay := newDelay(n.Meta["offset"].(int))
+		return func(s *Stream, bar domain.Candle) error {
+			sn.val = delay.push(step(bar, sn.args(s)))
+			return nil
+		}, nil
+
+	case "function":
+		spec, ok := s.ctx.Reg.Functions[n.Meta["name"].(string)]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s", n.Meta["name"])
+		}
+		if spec.Stream == nil {
+			return nil, nil
+		}
+		step := spec.Stream(n.Meta["params"].(map[string]any))
+		return func(s *Stream, _ domain.Candle) error {
+			sn.val = step(sn.args(s))
+			return nil
+		}, nil
+
+	case "pattern":
+		spec, ok := s.ctx.Reg.Predicates[n.Meta["name"].(string)]
+		if !ok {
+			return nil, fmt.Errorf("unknown predicate %s", n.Meta["name"])
+		}
+		if spec.Stream == nil || n.Meta["tf"].(domain.Timeframe) != s.ctx.BaseTF {
+			return nil, nil
+		}
+		step := spec.Stream(s.ctx, n.Meta["params"].(map[string]float64))
+		if step == nil {
+			return nil, nil
+		}
+		delay := newDelay(n.Meta["offset"].(int))
+		return func(_ *Stream, bar domain.Candle) error {
+			sn.bval = delay.push(boolValue(step(bar))) == 1
+			return nil
+		}, nil
+
+	case "+", "-", "*", "/", "%", "^":
+		op := n.Op
+		return func(s *Stream, _ domain.Candle) error {
+			sn.val = foldMath(op, s.dep(sn, 0).val, s.dep(sn, 1).val)
+			return nil
+		}, nil
+
+	case "bars_since":
+		last := -1
+		return func(s *Stream, _ domain.Candle) error {
+			if s.dep(sn, 0).bval {
+				last = s.n
+			}
+			sn.val = math.NaN()
+			if last >= 0 {
+				sn.val = float64(s.n - last)
+			}
+			return nil
+		}, nil
+
+	case "count_true":
+		w := n.Meta["n"].(int)
+		ring := make([]bool, maxInt(w, 1))
+		count := 0
+		return func(s *Stream, _ domain.Candle) error {
+			v := s.dep(sn, 0).bval
+			if v {
+				count++
+			}
+			if s.n >= w && ring[s.n%len(ring)] {
+				count--
+			}
+			ring[s.n%len(ring)] = v
+			sn.val = math.NaN()
+			if s.n+1 >= w {
+				sn.val = float64(count)
+			}
+			return nil
+		}, nil
+
+	case "NOT":
+		return func(s *Stream, _ domain.Candle) error { sn.bval = !s.dep(sn, 0).bval; return nil }, nil
+
+	case "AND":
+		return func(s *Stream, _ domain.Candle) error {
+			sn.bval = s.dep(sn, 0).bval && s.dep(sn, 1).bval
+			return nil
+		}, nil
+
+	case "OR":
+		return func(s *Stream, _ domain.Candle) error {
+			sn.bval = s.dep(sn, 0).bval || s.dep(sn, 1).bval
+			return nil
+		}, nil
+
+	case "held_for":
+		w, run := n.Meta["n"].(int), 0
+		return func(s *Stream, _ domain.Candle) error {
+			if s.dep(sn, 0).bval {
+				run++
+			} else {
+				run = 0
+			}
+			sn.bval = run >= w
+			return nil
+		}, nil
+
+	case "within":
+		w, last := n.Meta["n"].(int), -1
+		return func(s *Stream, _ domain.Candle) error {
+			if s.dep(sn, 0).bval {
+				last = s.n
+			}
+			sn.bval = last >= 0 && s.n-last < w
+			return nil
+		}, nil
+
+	case "sequence":
+		return sequenceStep(sn, n.Meta["gaps"].([]int), n.Meta["reset"].(bool), n.Meta["restart"].(bool)), nil
+	}
+
+	if op, ok := strings.CutPrefix(n.Op, "cmp:"); ok {
+		return s.compareStep(sn, op)
+	}
+	return nil, nil
+}
+
+func (s *Stream) compareStep(sn *streamNode, op string) (func(*Stream, domain.Candle) error, error) {
+	nanValue := !s.ctx.Policy.NaNIsFalse
+	var prevL, prevR float64
+	switch op {
+	case ">", ">=", "<", "<=", "==", "!=":
+		return func(s *Stream, _ domain.Candle) error {
+			l, r := s.dep(sn, 0).val, s.dep(sn, 1).val
+			sn.bval = foldCompare(op, l, r, !nanValue)
+			return nil
+		}, nil
+	case "crosses_above", "crosses_below":
+		return func(s *Stream, _ domain.Candle) error {
+			l, r := s.dep(sn, 0).val, s.dep(sn, 1).val
+			switch {
+			case s.n == 0:
+				sn.bval = false
+			case math.IsNaN(prevL) || math.IsNaN(prevR) || math.IsNaN(l) || math.IsNaN(r):
+				sn.bval = nanValue
+			case op == "crosses_above":
+				sn.bval = prevL <= prevR && l > r
+			default:
+				sn.bval = prevL >= prevR && l < r
+			}
+			prevL, prevR = l, r
+			return nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown comparison %s", op)
+}
+
+// sequenceStep is Sequence one bar at a time.
+func sequenceStep(sn *streamNode, gaps []int, hasReset, restart bool) func(*Stream, domain.Candle) error {
+	stage, last := 0, -1
+	return func(s *Stream, _ domain.Candle) error {
+		i := s.n
+		sn.bval = false
+		if hasReset && s.dep(sn, len(gaps)).bval {
+			stage = 0
+			return nil
+		}
+		if stage > 0 && gaps[stage] > 0 && i-last > gaps[stage] {
+			stage = 0
+		}
+		switch {
+		case stage > 0 && s.dep(sn, stage).bval:
+			stage++
+			last = i
+		case s.dep(sn, 0).bval && (stage == 0 || restart):
+			stage = 1
+			last = i
+		}
+		if stage == len(gaps) {
+			sn.bval = true
+			stage = 0
+		}
+		return nil
+	}
+}
+
+// subPlan is the part of pl that n depends on, with n as its root.
+func subPlan(pl *Plan, n *PlanNode) *Plan {
+	need := map[string]bool{n.ID: true}
+	for i := len(pl.Order) - 1; i >= 0; i-- {
+		if m := pl.Order[i]; need[m.ID] {
+			for _, d := range m.Deps {
+				need[d.ID] = true
+			}
+		}
+	}
+	sub := &Plan{Roots: []*PlanNode{n}}
+	for _, m := range pl.Order {
+		if need[m.ID] {
+			sub.Order = append(sub.Order, m)
+		}
+		if m.ID == n.ID {
+			break
+		}
+	}
+	return sub
+}
+
+// fallbackStep evaluates the node's sub-plan over the history and keeps its
+// last value. Failures while the history is still within the node's warm-up
+// give NaN (false), like the bars a longer history would have there.
+func fallbackStep(sn *streamNode) func(*Stream, domain.Candle) error {
+	return func(s *Stream, _ domain.Candle) error {
+		sn.val, sn.bval = math.NaN(), false
+		ctx := NewEvalCtx(s.ctx.Symbol, s.ctx.BaseTF, s.ctx.Data, s.ctx.Reg)
+		ctx.Policy = s.ctx.Policy
+		ctx.SetCache(candleSeries(s.bars))
+		rt := NewRuntime(ctx)
+		if err := rt.execSequential(sn.sub); err != nil {
+			if len(s.bars) <= sn.lookback || errors.Is(err, errBadOffset) {
+				return nil
+			}
+			return err
+		}
+		last := len(s.bars) - 1
+		if sn.n.Kind == NodeBool {
+			if bs, ok := ctx.BoolOf(sn.n.ID); ok && len(bs) == len(s.bars) {
+				sn.bval = bs[last]
+			}
+		} else if ser, ok := ctx.SeriesOf(sn.n.ID); ok && len(ser) == len(s.bars) {
+			sn.val = ser[last]
+		}
+		return nil
+	}
+}
+
+func candleSeries(bars []domain.Candle) map[string]Series {
+	out := map[string]Series{}
+	for _, k := range []string{"time", "open", "high", "low", "close", "volume"} {
+		out[k] = make(Series, len(bars))
+	}
+	for i, b := range bars {
+		out["time"][i] = float64(b.Time.Unix())
+		out["open"][i], out["high"][i], out["low"][i] = b.Open, b.High, b.Low
+		out["close"][i], out["volume"][i] = b.Close, b.Volume
+	}
+	return out
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// delay returns values offset bars late, NaN until it has seen offset bars:
+// shiftBack one bar at a time.
+type delay struct {
+	ring []float64
+	n    int
+}
+
+func newDelay(offset int) *delay { return &delay{ring: make([]float64, offset)} }
+
+func (d *delay) push(v float64) float64 {
+	if len(d.ring) == 0 {
+		return v
+	}
+	k := d.n % len(d.ring)
+	out := d.ring[k]
+	if d.n < len(d.ring) {
+		out = math.NaN()
+	}
+	d.ring[k] = v
+	d.n++
+	return out
+}
+
+// Steps shared by the specs' Stream constructors.
+
+// emaStep is EMA one value at a time.
+func emaStep(p int) func(v float64) float64 {
+	k := 2.0 / (float64(p) + 1.0)
+	var prev float64
+	first := true
+	return func(v float64) float64 {
+		if p <= 0 {
+			return math.NaN()
+		}
+		if first {
+			if math.IsNaN(v) {
+				// leading NaNs are skipped, as by EMA
+				return v
+			}
+			first = false
+			prev = v
+			return v
+		}
+		prev = v*k + prev*(1-k)
+		return prev
+	}
+}
+
+// smaStep is SMA one value at a time.
+func smaStep(p int) func(v float64) float64 {
+	ring := make([]float64, maxInt(p, 1))
+	var sum float64
+	i := 0
+	return func(v float64) float64 {
+		if p <= 0 || (i == 0 && math.IsNaN(v)) {
+			return math.NaN()
+		}
+		sum += v
+		if i >= p {
+			sum -= ring[i%p]
+		}
+		ring[i%p] = v
+		i++
+		if i >= p {
+			return sum / float64(p)
+		}
+		return math.NaN()
+	}
+}
+
+// rsiStep is RSI one value at a time.
+func rsiStep(period int) func(v float64) float64 {
+	i := -1
+	var prev, sumGain, sumLoss, avgGain, avgLoss float64
+	return func(v float64) float64 {
+		if i < 0 && math.IsNaN(v) {
+			return math.NaN()
+		}
+		i++
+		change := v - prev
+		prev = v
+		if period <= 0 || i == 0 {
+			return math.NaN()
+		}
+		if i <= period {
+			if change > 0 {
+				sumGain += change
+			} else {
+				sumLoss += -change
+			}
+			if i < period {
+				return math.NaN()
+			}
+			avgGain = sumGain / float64(period)
+			avgLoss = sumLoss / float64(period)
+		} else {
+			var gain, loss float64
+			if change > 0 {
+				gain = change
+			} else {
+				loss = -change
+			}
+			avgGain = (avgGain*float64(period-1) + gain) / float64(period)
+			avgLoss = (avgLoss*float64(period-1) + loss) / float64(period)
+		}
+		if avgLoss == 0 {
+			return 100
+		}
+		rs := avgGain / avgLoss
+		return 100 - (100 / (1 + rs))
+	}
+}
+
+// windowStep feeds the last n values of each arg to f and returns its
+// result; NaN until n values have been seen and while a window holds a NaN,
+// as rolling and rolling2. Each buffer holds every value twice, so the
+// window is always a contiguous slice of it and a bar copies nothing.
+func windowStep(n, args int, f func(wins ...[]float64) float64) FunctionStep {
+	bufs := make([][]float64, args)
+	for i := range bufs {
+		bufs[i] = make([]float64, 2*maxInt(n, 1))
+	}
+	wins := make([][]float64, args)
+	bad := make([]int, args)
+	count := 0
+	return func(vals []float64) float64 {
+		if n <= 0 || len(vals) != args {
+			return math.NaN()
+		}
+		k := count % n
+		for i, v := range vals {
+			if count >= n && math.IsNaN(bufs[i][k]) {
+				bad[i]--
+			}
+			if math.IsNaN(v) {
+				bad[i]++
+			}
+			bufs[i][k], bufs[i][k+n] = v, v
+		}
+		count++
+		if count < n {
+			return math.NaN()
+		}
+		for i, b := range bufs {
+			if bad[i] > 0 {
+				return math.NaN()
+			}
+			wins[i] = b[k+1 : k+1+n]
+		}
+		return f(wins...)
+	}
+}
@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// streamCandles is n 5m bars of a random walk over 09:15-15:30 IST on
+// weekdays, with a few flat bars.
+func streamCandles(n int) []domain.Candle {
+	rng := rand.New(rand.NewSource(7))
+	var cs []domain.Candle
+	t0 := time.Date(2025, 1, 6, 3, 45, 0, 0, time.UTC) // 09:15 IST
+	px := 100.0
+	for i := 0; len(cs) < n; i++ {
+		t := t0.Add(time.Duration(i*5) * time.Minute)
+		if m := t.Hour()*60 + t.Minute(); m > 10*60 || t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+			continue
+		}
+		o := px
+		px *= math.Exp(rng.NormFloat64() * 0.004)
+		if rng.Intn(40) == 0 {
+			px = o
+		}
+		h, l := math.Max(o, px)*(1+rng.Float64()*0.002), math.Min(o, px)*(1-rng.Float64()*0.002)
+		cs = append(cs, domain.Candle{Time: t, Open: o, High: h, Low: l, Close: px, Volume: float64(1000 + rng.Intn(500))})
+	}
+	return cs
+}
+
+// streamData serves the second symbol of pair conditions and an expiry
+// calendar that ends partway through the bars.
+type streamData struct {
+	other    []domain.Candle
+	expiries []time.Time
+}
+
+func (d streamData) LoadOHLCV(string, domain.Timeframe) ([]domain.Candle, error) {
+	return d.other, nil
+}
+
+func (d streamData) AlignTo(_ domain.Timeframe, s Series, _ domain.Timeframe) (Series, error) {
+	return s, nil
+}
+
+func (d streamData) Expiries(string) ([]time.Time, error) { return d.expiries, nil }
+
+// pairCandles is a second symbol following cs loosely, starting later and
+// missing some bars.
+func pairCandles(cs []domain.Candle) []domain.Candle {
+	rng := rand.New(rand.NewSource(11))
+	var out []domain.Candle
+	for i, c := range cs {
+		if i < 20 || i%13 == 0 {
+			continue
+		}
+		c.Close = c.Close*0.5 + rng.NormFloat64()*0.3
+		out = append(out, c)
+	}
+	return out
+}
+
+func planText(t *testing.T, text string) *Plan {
+	t.Helper()
+	return planTextWith(t, BuildRegistry(), text)
+}
+
+func planTextWith(t *testing.T, reg *Registry, text string) *Plan {
+	t.Helper()
+	toks, _, err := (&DSLParser{Reg: reg, DefaultTF: "5m"}).Parse(text)
+	if err != nil {
+		t.Fatalf("%s: %v", text, err)
+	}
+	pred, err := (&Parser{Reg: reg}).ParsePredicate(toks)
+	if err != nil {
+		t.Fatalf("%s: %v", text, err)
+	}
+	pl, err := NewPlanner("5m", reg).Build(pred)
+	if err != nil {
+		t.Fatalf("%s: %v", text, err)
+	}
+	return pl
+}
+
+func sameFloat(a, b float64) bool { return a == b || (math.IsNaN(a) && math.IsNaN(b)) }
+
+// TestStreamMatchesBatch pushes the bars one at a time and checks every node
+// of every plan against ExecPlan over all the bars.
+func TestStreamMatchesBatch(t *testing.T) {
+	conds := []string{
+		"EMA(Close, 9) crosses_above SMA(Close, 21)",
+		"RSI(Close, 14) < 40 AND Close > Open",
+		"held_for(RSI(Close, 14) > 50, 3) OR within(Close crosses_below EMA(Close, 20), 5)",
+		"Highest(High, 20) <= Close",
+		"Close >= Highest(High, 20) AND Low <= Lowest(Low, 10)",
+		"HighestIndex(High, 10) < LowestIndex(Low, 10)",
+		"ZScore(Close, 30) > 1.5 OR PercentRank(Close - Open, 50) > 90",
+		"LinRegSlope(Close, 20) > 0 AND LinReg(Close, 20, ahead=3) > Close AND LinRegR2(Close, 20) > 0.5",
+		"LinRegIntercept(Close, 20) > Open",
+		"Correlation(Close, EMA(Close, 5), 30) > 0.9 AND Beta(Close, Open, 30) > 0.5",
+		"count_true(Close > Open, n=5) >= 3 AND bars_since(Close < Open) < 2",
+		"sequence((Close > Open), (Close < Open){max_gap=5}, reset(Close > 1000), restart=true)",
+		"Close[-2] / (Close - Close) > 0 OR (Close - Open) / Open * 100 > 0.2",
+		"(Close % 7) ^ 2 > 10 AND Close crosses_below Open",
+		"not (Close > EMA(Close, 50)) AND RSI(Close, 7)[-3] > 55",
+		"BullishEngulfing OR BearishEngulfing OR BullishHarami OR BearishHarami OR Doji[-1]",
+		"Hammer OR ShootingStar OR MorningStar OR EveningStar OR ThreeWhiteSoldiers",
+		"InsideBar OR OutsideBar OR NR4 OR NR7",
+		"TimeOfDay >= 10:00 AND DayOfWeek == 1",
+		"DayOfMonth > 7 AND MinuteOfSession > 30 AND Time > 0",
+		"StdDev(RSI(Close, 5), 10) > 5 AND Sum(Close - Open, 10) > 0",
+		"SMA(Close[-1], 5) > EMA(Close[-1], 5) AND RSI(Close[-1], 14) > EMA(RSI(Close, 14), 9)",
+		"SMA(RSI(Close, 14), 9) > 50",
+		"VWAP > Close AND Supertrend(period=10, mult=3) < Close",
+		"VWAPUpper(mult=2) > Close OR VWAPLower(reset=7) < Close",
+		"AVWAP(anchor=1736400000) > Close OR AVWAPUpper(bars_since(Close < Open), mult=1.5) < High OR AVWAPLower < Low",
+		"PrevDayHigh < Close AND PrevDayLow < Close OR PrevDayClose > Open",
+		"Pivot(level=1) < Close OR Camarilla(level=-3) > Close OR CPR(level=0) > Open",
+		"ORHigh < Close OR ORLow(minutes=30) > Low",
+		"DaysToExpiry <= 2 AND (ExpiryDay OR ExpiryWeek)",
+		"FirstMinutes(30) OR LastMinutes(n=60)",
+		"HV(20) > ParkinsonVol(20) OR GarmanKlassVol(10) > RogersSatchellVol(10) OR YangZhangVol(15) > 0.2",
+		"IchimokuTenkan > IchimokuKijun AND Close > IchimokuSenkouA(conv=5, base=10, span_b=20, displacement=10) AND Close > IchimokuSenkouB",
+		"PairRatio > PairClose / 100 AND PairHedge(30) > 0 OR PairSpread(30) > 0 OR PairZScore(30, z=20) < -1 OR PairCoint(40) < -3",
+		// no incremental step: evaluated over the history
+		"Body(Close) > 0",
+	}
+	cs := streamCandles(900)
+	series := candleSeries(cs)
+	other := pairCandles(cs)
+	data := streamData{other: other, expiries: []time.Time{
+		time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC),
+	}}
+	body, err := CompileScript(Script{Name: "Body", Source: "def compute(bars, params):\n" +
+		"    return [bars.close[i] - bars.open[i] for i in range(bars.len)]\n"}, DefaultScriptLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := BuildRegistry().WithScripts(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed := map[string]bool{}
+	fallbacks := 0
+	for _, c := range conds {
+		pl := planTextWith(t, reg, c)
+		bctx := NewEvalCtx("X", "5m", data, reg)
+		bctx.SetCache(series)
+		bctx.SetPair("Y", AlignCloses(cs, other))
+		root, err := NewRuntime(bctx).ExecPlan(pl)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		sctx := NewEvalCtx("X", "5m", data, reg)
+		sctx.PairSymbol = "Y"
+		st, err := NewStream(sctx, pl)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		for _, sn := range st.nodes {
+			if name, ok := sn.n.Meta["name"].(string); ok && sn.sub == nil {
+				streamed[name] = true
+			}
+		}
+		fallbacks += len(st.Fallbacks())
+
+		mismatches := 0
+		for i, bar := range cs {
+			got, err := st.Push(bar)
+			if err != nil {
+				t.Fatalf("%s: bar %d: %v", c, i, err)
+			}
+			if got != root[i] {
+				t.Errorf("%s: root at %d: stream %v, batch %v", c, i, got, root[i])
+				mismatches++
+			}
+			for _, n := range pl.Order {
+				if v, ok := st.Value(n.ID); ok {
+					want, _ := bctx.SeriesOf(n.ID)
+					if !sameFloat(v, want[i]) {
+						t.Errorf("%s: %s at %d: stream %v, batch %v", c, n.Label(), i, v, want[i])
+						mismatches++
+					}
+				} else if b, ok := st.Bool(n.ID); ok {
+					want, _ := bctx.BoolOf(n.ID)
+					if b != want[i] {
+						t.Errorf("%s: %s at %d: stream %v, batch %v", c, n.Label(), i, b, want[i])
+						mismatches++
+					}
+				}
+			}
+			if mismatches > 5 {
+				t.Fatalf("%s: too many mismatches", c)
+			}
+		}
+	}
+
+	if fallbacks == 0 {
+		t.Error("no node was evaluated over the history")
+	}
+	for name, spec := range reg.Indicators {
+		if spec.Stream != nil && !streamed[name] {
+			t.Errorf("indicator %s has a stream step but is not covered", name)
+		}
+	}
+	for name, spec := range reg.Functions {
+		if spec.Stream != nil && !streamed[name] {
+			t.Errorf("function %s has a stream step but is not covered", name)
+		}
+	}
+	for name, spec := range reg.Predicates {
+		if spec.Stream != nil && !streamed[name] {
+			t.Errorf("predicate %s has a stream step but is not covered", name)
+		}
+	}
+}
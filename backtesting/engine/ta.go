@@ -41,11 +41,40 @@ func sliceLastN(bars []domain.Candle, n int) []domain.Candle {
 	return out
 }
 
-// SMA Simple Moving Average of closes for period p.
+// leadingNaN counts the NaNs values starts with: the warm-up of an offset
+// or of the indicator it was computed from.
+func leadingNaN(values []float64) int {
+	for i, v := range values {
+		if !math.IsNaN(v) {
+			return i
+		}
+	}
+	return len(values)
+}
+
+// padNaN prepends NaNs to s up to length n; a nil s is all NaN.
+func padNaN(s []float64, n int) []float64 {
+	out := make([]float64, n)
+	k := n - len(s)
+	if s == nil {
+		k = n
+	}
+	for i := 0; i < k; i++ {
+		out[i] = math.NaN()
+	}
+	copy(out[k:], s)
+	return out
+}
+
+// SMA Simple Moving Average of closes for period p. Leading NaNs are
+// skipped, so the first window starts at the first value.
 func SMA(values []float64, p int) []float64 {
 	if p <= 0 || len(values) == 0 {
 		return nil
 	}
+	if k := leadingNaN(values); k > 0 && k < len(values) {
+		return padNaN(SMA(values[k:], p), len(values))
+	}
 	out := make([]float64, len(values))
 	var sum float64
 	for i := range values {
@@ -62,11 +91,15 @@ func SMA(values []float64, p int) []float64 {
 	return out
 }
 
-// EMA Exponential Moving Average of values for period p.
+// EMA Exponential Moving Average of values for period p, seeded on the
+// first value after any leading NaNs.
 func EMA(values []float64, p int) []float64 {
 	if p <= 0 || len(values) == 0 {
 		return nil
 	}
+	if k := leadingNaN(values); k > 0 && k < len(values) {
+		return padNaN(EMA(values[k:], p), len(values))
+	}
 	out := make([]float64, len(values))
 	k := 2.0 / (float64(p) + 1.0)
 	var prev float64
@@ -298,13 +331,20 @@ func Momentum(values []float64, p int) []float64 {
 	return out
 }
 
-// RSI Relative Strength Index (Wilder's).
+// RSI Relative Strength Index (Wilder's), from the first value after any
+// leading NaNs.
 func RSI(values []float64, period int) []float64 {
+	if k := leadingNaN(values); period > 0 && k > 0 && k < len(values) {
+		return padNaN(RSI(values[k:], period), len(values))
+	}
 	if period <= 0 || len(values) <= period {
 		return nil
 	}
 
 	out := make([]float64, len(values))
+	for i := 0; i < period; i++ {
+		out[i] = math.NaN() // warm-up
+	}
 
 	// Calculate initial gains and losses for the first period
 	var sumGain, sumLoss float64
@@ -568,53 +608,50 @@ func Donchian(bars []domain.Candle, p int) (upper, lower, middle []float64) {
 
 // Supertrend: returns supertrend line and direction (1 uptrend, -1 downtrend)
 func Supertrend(bars []domain.Candle, atrPeriod int, multiplier float64) (trend []float64, dir []int) {
-	atr := ATR(bars, atrPeriod)
-	basicUpper := make([]float64, len(bars))
-	basicLower := make([]float64, len(bars))
-	finalUpper := make([]float64, len(bars))
-	finalLower := make([]float64, len(bars))
 	trend = make([]float64, len(bars))
 	dir = make([]int, len(bars))
-	for i := range bars {
-		mid := (bars[i].High + bars[i].Low) / 2
-		basicUpper[i] = mid + multiplier*atr[i]
-		basicLower[i] = mid - multiplier*atr[i]
-		if i == 0 {
-			finalUpper[i] = basicUpper[i]
-			finalLower[i] = basicLower[i]
-			trend[i] = finalLower[i]
-			dir[i] = 1
-			continue
-		}
-		if basicUpper[i] < finalUpper[i-1] || bars[i-1].Close > finalUpper[i-1] {
-			finalUpper[i] = basicUpper[i]
-		} else {
-			finalUpper[i] = finalUpper[i-1]
-		}
-		if basicLower[i] > finalLower[i-1] || bars[i-1].Close < finalLower[i-1] {
-			finalLower[i] = basicLower[i]
-		} else {
-			finalLower[i] = finalLower[i-1]
+	step := supertrendStep(atrPeriod, multiplier)
+	for i, b := range bars {
+		trend[i], dir[i] = step(b.High, b.Low, b.Close)
+	}
+	return
+}
+
+// supertrendStep is Supertrend one bar at a time.
+func supertrendStep(atrPeriod int, multiplier float64) func(high, low, close float64) (float64, int) {
+	atr := emaStep(atrPeriod)
+	first := true
+	var prevClose, finalUpper, finalLower, trend float64
+	return func(high, low, close float64) (float64, int) {
+		tr := high - low
+		if !first {
+			tr = max(tr, max(abs(high-prevClose), abs(low-prevClose)))
 		}
-		if trend[i-1] == finalUpper[i-1] {
-			if bars[i].Close > finalUpper[i] {
-				trend[i] = finalLower[i]
-				dir[i] = 1
-			} else {
-				trend[i] = finalUpper[i]
-				dir[i] = -1
+		a := atr(tr)
+		mid := (high + low) / 2
+		basicUpper, basicLower := mid+multiplier*a, mid-multiplier*a
+		dir := 1
+		switch {
+		case first:
+			finalUpper, finalLower, trend = basicUpper, basicLower, basicLower
+			first = false
+		default:
+			wasUpper := trend == finalUpper
+			if basicUpper < finalUpper || prevClose > finalUpper {
+				finalUpper = basicUpper
 			}
-		} else {
-			if bars[i].Close < finalLower[i] {
-				trend[i] = finalUpper[i]
-				dir[i] = -1
+			if basicLower > finalLower || prevClose < finalLower {
+				finalLower = basicLower
+			}
+			if (wasUpper && !(close > finalUpper)) || (!wasUpper && close < finalLower) {
+				trend, dir = finalUpper, -1
 			} else {
-				trend[i] = finalLower[i]
-				dir[i] = 1
+				trend = finalLower
 			}
 		}
+		prevClose = close
+		return trend, dir
 	}
-	return
 }
 
 // Ichimoku Cloud components: Tenkan (conversion), Kijun (base), Senkou A/B (leading), Chikou (lagging)
@@ -625,64 +662,49 @@ func Ichimoku(bars []domain.Candle, convPeriod, basePeriod, spanBPeriod, displac
 	senkouA = make([]float64, n)
 	senkouB = make([]float64, n)
 	chikou = make([]float64, n)
-	for i := range bars {
-		// Tenkan
-		if i+1 >= convPeriod {
-			h := bars[i].High
-			l := bars[i].Low
-			for j := i - convPeriod + 1; j <= i; j++ {
-				if bars[j].High > h {
-					h = bars[j].High
-				}
-				if bars[j].Low < l {
-					l = bars[j].Low
-				}
+	step := ichimokuStep(convPeriod, basePeriod, spanBPeriod)
+	for i, b := range bars {
+		tenkan[i], kijun[i], senkouA[i], senkouB[i] = step(b)
+		// Chikou lagging span
+		chikou[i] = b.Close // user can shift back by displacement when plotting
+	}
+	return
+}
+
+// ichimokuStep is Ichimoku one bar at a time, before displacement.
+func ichimokuStep(convPeriod, basePeriod, spanBPeriod int) func(b domain.Candle) (tenkan, kijun, senkouA, senkouB float64) {
+	// the last n highs and lows, each held twice so that any window is a slice
+	n := maxInt(1, maxInt(convPeriod, maxInt(basePeriod, spanBPeriod)))
+	highs, lows := make([]float64, 2*n), make([]float64, 2*n)
+	count := 0
+	mid := func(p int) float64 {
+		if count < p {
+			return math.NaN()
+		}
+		end := count%n + n
+		h, l := highs[end-1], lows[end-1]
+		for j := end - p; j < end; j++ {
+			if highs[j] > h {
+				h = highs[j]
 			}
-			tenkan[i] = (h + l) / 2
-		} else {
-			tenkan[i] = math.NaN()
-		}
-		// Kijun
-		if i+1 >= basePeriod {
-			h := bars[i].High
-			l := bars[i].Low
-			for j := i - basePeriod + 1; j <= i; j++ {
-				if bars[j].High > h {
-					h = bars[j].High
-				}
-				if bars[j].Low < l {
-					l = bars[j].Low
-				}
+			if lows[j] < l {
+				l = lows[j]
 			}
-			kijun[i] = (h + l) / 2
-		} else {
-			kijun[i] = math.NaN()
 		}
+		return (h + l) / 2
+	}
+	return func(b domain.Candle) (tenkan, kijun, senkouA, senkouB float64) {
+		k := count % n
+		highs[k], highs[k+n], lows[k], lows[k+n] = b.High, b.High, b.Low, b.Low
+		count++
+		tenkan, kijun, senkouB = mid(convPeriod), mid(basePeriod), mid(spanBPeriod)
 		// Senkou A/B (placed forward by displacement; caller can handle indexing)
-		if !math.IsNaN(tenkan[i]) && !math.IsNaN(kijun[i]) {
-			senkouA[i] = (tenkan[i] + kijun[i]) / 2
-		} else {
-			senkouA[i] = math.NaN()
-		}
-		if i+1 >= spanBPeriod {
-			h := bars[i].High
-			l := bars[i].Low
-			for j := i - spanBPeriod + 1; j <= i; j++ {
-				if bars[j].High > h {
-					h = bars[j].High
-				}
-				if bars[j].Low < l {
-					l = bars[j].Low
-				}
-			}
-			senkouB[i] = (h + l) / 2
-		} else {
-			senkouB[i] = math.NaN()
+		senkouA = math.NaN()
+		if !math.IsNaN(tenkan) && !math.IsNaN(kijun) {
+			senkouA = (tenkan + kijun) / 2
 		}
-		// Chikou lagging span
-		chikou[i] = bars[i].Close // user can shift back by displacement when plotting
+		return
 	}
-	return
 }
 
 // PSAR Parabolic SAR (Wilder). af=acceleration factor, inc=increment, max=maximum
@@ -935,9 +957,24 @@ func MedianPrice(bars []domain.Candle) []float64 {
 }
 func MedianPriceMA(bars []domain.Candle, p int) []float64 { return SMA(MedianPrice(bars), p) }
 
-// Pivot variants (Camarilla, CPR) left as TODOs with signatures
+// Camarilla pivots: close -/+ range*1.1/12, /6, /4, /2.
 func CamarillaPivots(prevHigh, prevLow, prevClose float64) (h1, h2, h3, h4, l1, l2, l3, l4 float64) {
-	// TODO: implement exact Camarilla formula used in your platform if required.
+	r := (prevHigh - prevLow) * 1.1
+	h1, l1 = prevClose+r/12, prevClose-r/12
+	h2, l2 = prevClose+r/6, prevClose-r/6
+	h3, l3 = prevClose+r/4, prevClose-r/4
+	h4, l4 = prevClose+r/2, prevClose-r/2
+	return
+}
+
+// Central Pivot Range: pivot, top and bottom central levels (tc >= bc).
+func CentralPivotRange(prevHigh, prevLow, prevClose float64) (pivot, tc, bc float64) {
+	pivot = (prevHigh + prevLow + prevClose) / 3
+	bc = (prevHigh + prevLow) / 2
+	tc = 2*pivot - bc
+	if tc < bc {
+		tc, bc = bc, tc
+	}
 	return
 }
 
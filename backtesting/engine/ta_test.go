@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+func TestMovingAveragesSkipLeadingNaN(t *testing.T) {
+	closes := candleSeries(streamCandles(300))["close"]
+	shifted, err := shiftBack(closes, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsi := RSI(closes, 14)
+	for _, tc := range []struct {
+		name      string
+		got, want []float64
+		lead      int // leading NaNs of the input
+	}{
+		{"SMA of a shifted series", SMA(shifted, 5), SMA(closes[:299], 5), 1},
+		{"EMA of a shifted series", EMA(shifted, 5), EMA(closes[:299], 5), 1},
+		{"RSI of a shifted series", RSI(shifted, 14), RSI(closes[:299], 14), 1},
+		{"SMA of RSI", SMA(rsi, 9), SMA(rsi[14:], 9), 14},
+		{"EMA of RSI", EMA(rsi, 9), EMA(rsi[14:], 9), 14},
+	} {
+		for i, v := range tc.got {
+			want := math.NaN()
+			if i >= tc.lead {
+				want = tc.want[i-tc.lead]
+			}
+			if !sameFloat(v, want) {
+				t.Errorf("%s at %d: %v, want %v", tc.name, i, v, want)
+				break
+			}
+		}
+		if math.IsNaN(tc.got[len(tc.got)-1]) {
+			t.Errorf("%s: NaN on the last bar", tc.name)
+		}
+	}
+}
+
+func TestConditionsOnShiftedAverages(t *testing.T) {
+	series := candleSeries(streamCandles(300))
+	for _, c := range []string{
+		"SMA(Close[-1], 5) > 0",
+		"EMA(Close[-1], 5) > 0",
+		"RSI(Close[-1], 14) >= 0",
+		"EMA(RSI(Close, 14), 9) >= 0",
+		"SMA(RSI(Close, 14), 9) >= 0",
+	} {
+		pl := planText(t, c)
+		ctx := NewEvalCtx("X", "5m", nil, BuildRegistry())
+		ctx.SetCache(series)
+		root, err := NewRuntime(ctx).ExecPlan(pl)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if !root[len(root)-1] {
+			t.Errorf("%s: false on the last bar", c)
+		}
+	}
+}
+
+// tfData serves its candles for any timeframe.
+type tfData []domain.Candle
+
+func (d tfData) LoadOHLCV(string, domain.Timeframe) ([]domain.Candle, error) { return d, nil }
+
+func (d tfData) AlignTo(_ domain.Timeframe, s Series, _ domain.Timeframe) (Series, error) {
+	return s, nil
+}
+
+func (d tfData) Expiries(string) ([]time.Time, error) { return nil, nil }
+
+// TestSpecsLoadOtherTimeframes evaluates bar-based specs on a timeframe other
+// than the base one and checks they read that timeframe's candles.
+func TestSpecsLoadOtherTimeframes(t *testing.T) {
+	base, hourly := streamCandles(120), streamCandles(300)
+	reg := BuildRegistry()
+	ctx := NewEvalCtx("X", "5m", tfData(hourly), reg)
+	ctx.SetCache(candleSeries(base))
+	for _, name := range []string{"Doji", "InsideBar", "FirstMinutes", "ExpiryDay"} {
+		bs, err := reg.Predicates[name].Eval(ctx, "1h", nil, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(bs) != len(hourly) {
+			t.Errorf("%s on 1h: %d values, want %d", name, len(bs), len(hourly))
+		}
+	}
+	body, err := CompileScript(Script{Name: "Body", Source: "def compute(bars, params):\n" +
+		"    return [bars.close[i] - bars.open[i] for i in range(bars.len)]\n"}, DefaultScriptLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err = reg.WithScripts(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Body", "VWAP", "AVWAP", "PrevDayHigh", "Pivot", "ORHigh", "DayOfWeek", "DaysToExpiry", "HV", "YangZhangVol", "IchimokuKijun"} {
+		s, err := reg.Indicators[name].Eval(ctx, "1h", map[string]float64{"period": 20}, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(s) != len(hourly) {
+			t.Errorf("%s on 1h: %d values, want %d", name, len(s), len(hourly))
+		}
+	}
+}
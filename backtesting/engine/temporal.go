@@ -0,0 +1,108 @@
+// engine/temporal.go
+package engine
+
+import "math"
+
+// HeldFor is true where pred has been true for the last n consecutive bars
+// (including the current one).
+func HeldFor(pred BoolSeries, n int) BoolSeries {
+	out := make(BoolSeries, len(pred))
+	run := 0
+	for i, v := range pred {
+		if v {
+			run++
+		} else {
+			run = 0
+		}
+		out[i] = run >= n
+	}
+	return out
+}
+
+// Within is true where pred was true at least once in the last n bars
+// (including the current one).
+func Within(pred BoolSeries, n int) BoolSeries {
+	out := make(BoolSeries, len(pred))
+	last := -1
+	for i, v := range pred {
+		if v {
+			last = i
+		}
+		out[i] = last >= 0 && i-last < n
+	}
+	return out
+}
+
+// BarsSince counts bars since pred was last true: 0 on a true bar, NaN
+// until it has been true once.
+func BarsSince(pred BoolSeries) Series {
+	out := make(Series, len(pred))
+	last := -1
+	for i, v := range pred {
+		if v {
+			last = i
+		}
+		if last < 0 {
+			out[i] = math.NaN()
+		} else {
+			out[i] = float64(i - last)
+		}
+	}
+	return out
+}
+
+// CountTrue counts true bars in a rolling window of n bars; NaN until the
+// window is full, matching SMA.
+func CountTrue(pred BoolSeries, n int) Series {
+	out := make(Series, len(pred))
+	count := 0
+	for i, v := range pred {
+		if v {
+			count++
+		}
+		if i >= n && pred[i-n] {
+			count--
+		}
+		if i+1 >= n {
+			out[i] = float64(count)
+		} else {
+			out[i] = math.NaN()
+		}
+	}
+	return out
+}
+
+// Sequence is true on the bar where the final step completes. Each step must
+// fire on a bar after the previous step and, when its gap is non-zero, no
+// more than gap bars later; otherwise the attempt is abandoned. A true reset
+// bar abandons the attempt too. With restart, the first step firing again
+// mid-sequence re-anchors the attempt at that bar.
+func Sequence(steps []BoolSeries, gaps []int, reset BoolSeries, restart bool) BoolSeries {
+	if len(steps) == 0 {
+		return nil
+	}
+	out := make(BoolSeries, len(steps[0]))
+	stage, last := 0, -1
+	for i := range out {
+		if reset != nil && reset[i] {
+			stage = 0
+			continue
+		}
+		if stage > 0 && gaps[stage] > 0 && i-last > gaps[stage] {
+			stage = 0
+		}
+		switch {
+		case stage > 0 && steps[stage][i]:
+			stage++
+			last = i
+		case steps[0][i] && (stage == 0 || restart):
+			stage = 1
+			last = i
+		}
+		if stage == len(steps) {
+			out[i] = true
+			stage = 0
+		}
+	}
+	return out
+}
@@ -31,11 +31,26 @@ func isLogical(op string) bool {
 
 type Parser struct {
 	Reg *Registry
+	// NoFutureRef rejects specs marked FutureRef; set it for conditions that
+	// drive trades so look-ahead cannot creep into a backtest.
+	NoFutureRef bool
+	// Custom holds the caller's saved indicators, expanded where called.
+	Custom map[string]CustomIndicator
+
+	// expanding lists the custom indicators being expanded, for cycles.
+	expanding []string
+	// expanded counts the tokens custom indicators expanded to in this parse.
+	expanded *int
+	// diags collects problems instead of failing on the first; see Validate.
+	diags *Diagnostics
 }
 
+// ValidateCondition returns nil for a valid condition and Diagnostics otherwise.
 func (p *Parser) ValidateCondition(c domain.Condition) error {
-	_, err := p.ParsePredicate(c.Tokens)
-	return err
+	if ds := p.Validate(c.Tokens); len(ds) > 0 {
+		return ds
+	}
+	return nil
 }
 
 func (p *Parser) ParsePredicate(ts []domain.Token) (domain.PredNode, error) {
@@ -66,7 +81,10 @@ func (p *Parser) ParsePredicate(ts []domain.Token) (domain.PredNode, error) {
 				// mark next chunk negated
 				if len(cur) != 0 {
 					// "X NOT Y" without separator is invalid
-					return nil, errors.New("NOT must appear before a comparison or group")
+					if err := p.fail(diag(t, DiagSyntax, "NOT must appear before a comparison or group")); err != nil {
+						return nil, err
+					}
+					continue
 				}
 				pendingNeg = !pendingNeg
 				continue
@@ -77,13 +95,18 @@ func (p *Parser) ParsePredicate(ts []domain.Token) (domain.PredNode, error) {
 				chunks = append(chunks, chunk{toks: []domain.Token{t}})
 				continue
 			}
-			return nil, fmt.Errorf("unknown logical operator %q", op)
+			d := diag(t, DiagUnknownOperator, fmt.Sprintf("unknown logical operator %q", op))
+			d.Fix = "use AND, OR or NOT"
+			if err := p.fail(d); err != nil {
+				return nil, err
+			}
+			continue
 		}
 		cur = append(cur, t)
 	}
 	flush()
 	if len(chunks) == 0 {
-		return nil, errors.New("empty condition")
+		return nil, &Diagnostic{Code: DiagEmpty, Message: "empty condition"}
 	}
 
 	// Now chunks are like: [cmpChunk] [AND] [cmpChunk] [OR] [cmpChunk] ...
@@ -96,19 +119,25 @@ func (p *Parser) ParsePredicate(ts []domain.Token) (domain.PredNode, error) {
 		// Operator?
 		if len(ch.toks) == 1 && ch.toks[0].Type == domain.TokenLogical {
 			if !expectOp {
-				return nil, errors.New("unexpected logical operator")
+				if err := p.fail(diag(ch.toks[0], DiagSyntax, "unexpected logical operator")); err != nil {
+					return nil, err
+				}
+				continue
 			}
 			lastOp = ch.toks[0].Operator
 			expectOp = false
 			continue
 		}
 
-		// Comparison chunk → must parse into a CompareNode
-		cmp, err := p.parseComparison(ch.toks)
+		// Clause chunk → a CompareNode or a boolean temporal operator
+		node, err := p.parseClause(ch.toks)
 		if err != nil {
-			return nil, err
+			if err := p.record(err, ch.toks[0].ID); err != nil {
+				return nil, err
+			}
+			// keep going so later clauses are checked too
+			node = domain.CompareNode{}
 		}
-		var node domain.PredNode = cmp
 		if ch.negated {
 			node = domain.LogicalNode{Op: "NOT", Lhs: node}
 		}
@@ -117,43 +146,260 @@ func (p *Parser) ParsePredicate(ts []domain.Token) (domain.PredNode, error) {
 			pred = node
 		} else {
 			if lastOp == "" {
-				return nil, errors.New("missing logical operator between comparisons")
+				d := diag(ch.toks[0], DiagSyntax, "missing logical operator between comparisons")
+				d.Fix = "insert AND or OR before this clause"
+				if err := p.fail(d); err != nil {
+					return nil, err
+				}
+				lastOp = "AND"
 			}
 			pred = domain.LogicalNode{Op: lastOp, Lhs: pred, Rhs: node}
 			lastOp = ""
 		}
 		expectOp = true
 	}
+	if pred == nil {
+		return nil, &Diagnostic{TokenID: ts[len(ts)-1].ID, Code: DiagSyntax, Message: "condition has no clauses"}
+	}
 	return pred, nil
 }
 
+// temporalOps maps each temporal operator to whether it yields a predicate
+// (true) or a numeric series (false), and whether it needs an "n" param.
+var temporalOps = map[string]struct{ isPred, needsN bool }{
+	"held_for":   {true, true},
+	"within":     {true, true},
+	"bars_since": {false, false},
+	"count_true": {false, true},
+	"sequence":   {true, false}, // parsed by parseSequence
+}
+
+func (p *Parser) parseClause(ts []domain.Token) (domain.PredNode, error) {
+	if len(ts) == 1 && ts[0].Type == domain.TokenGroup {
+		if len(ts[0].Args) == 0 {
+			return nil, diag(ts[0], DiagEmpty, "empty group")
+		}
+		return p.ParsePredicate(ts[0].Args)
+	}
+	if len(ts) == 1 && ts[0].Type == domain.TokenPredicate {
+		return p.parsePattern(ts[0])
+	}
+	if len(ts) == 1 && ts[0].Type == domain.TokenTemporal && ts[0].Function == "sequence" {
+		return p.parseSequence(ts[0])
+	}
+	if len(ts) == 1 && ts[0].Type == domain.TokenTemporal {
+		if op, ok := temporalOps[ts[0].Function]; ok && op.isPred {
+			inner, n, err := p.parseTemporal(ts[0])
+			if err != nil {
+				return nil, err
+			}
+			return domain.TemporalNode{Op: ts[0].Function, Pred: inner, N: n}, nil
+		}
+	}
+	return p.parseComparison(ts)
+}
+
+// parsePattern parses a predicate token, checked like an indicator token.
+func (p *Parser) parsePattern(t domain.Token) (domain.PredNode, error) {
+	if _, ok := domain.AllowedTF[t.Timeframe]; !ok {
+		if err := p.fail(invalidTimeframe(t)); err != nil {
+			return nil, err
+		}
+	}
+	params, err := coerceNumMap(t.Params)
+	if err != nil {
+		if err := p.fail(diag(t, DiagInvalidParam, fmt.Sprintf("predicate %s params: %v", t.Function, err))); err != nil {
+			return nil, err
+		}
+	}
+	spec, ok := p.Reg.Predicates[t.Function]
+	if !ok {
+		if err := p.fail(p.unknownName(t, "predicate", t.Function)); err != nil {
+			return nil, err
+		}
+	} else if err := p.checkParams(t, "predicate "+t.Function, spec.Params, slices.Collect(maps.Keys(params))); err != nil {
+		return nil, err
+	}
+	if t.Offset < 0 {
+		if err := p.fail(diag(t, DiagInvalidParam, fmt.Sprintf("predicate %s: offset must not be negative", t.Function))); err != nil {
+			return nil, err
+		}
+	}
+	if len(t.Args) > 0 {
+		if err := p.fail(diag(t, DiagSyntax, fmt.Sprintf("predicate %s takes no args", t.Function))); err != nil {
+			return nil, err
+		}
+	}
+	return domain.PatternNode{Name: t.Function, Timeframe: t.Timeframe, Params: params, Offset: t.Offset}, nil
+}
+
+// maxTemporalWindow bounds n of held_for, within and count_true, which sizes
+// the history loaded ahead of the run.
+const maxTemporalWindow = 10000
+
+// parseTemporal parses the nested condition and window of a temporal token.
+func (p *Parser) parseTemporal(t domain.Token) (domain.PredNode, int, error) {
+	op, ok := temporalOps[t.Function]
+	if !ok {
+		return nil, 0, diag(t, DiagUnknownOperator, fmt.Sprintf("unknown temporal operator %q", t.Function))
+	}
+	params, err := coerceNumMap(t.Params)
+	if err != nil {
+		return nil, 0, diag(t, DiagInvalidParam, fmt.Sprintf("%s params: %v", t.Function, err))
+	}
+	spec := []ArgSpec{{Name: "n", Type: "int", Req: op.needsN}}
+	if err := p.checkParams(t, t.Function, spec, slices.Collect(maps.Keys(params))); err != nil {
+		return nil, 0, err
+	}
+	n := int(params["n"])
+	if _, set := params["n"]; set && op.needsN && (n < 1 || n > maxTemporalWindow || float64(n) != params["n"]) {
+		if err := p.fail(diag(t, DiagInvalidParam,
+			fmt.Sprintf("%s: n must be an integer from 1 to %d", t.Function, maxTemporalWindow))); err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(t.Args) == 0 {
+		return nil, 0, diag(t, DiagEmpty, fmt.Sprintf("%s requires a condition", t.Function))
+	}
+	inner, err := p.ParsePredicate(t.Args)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", t.Function, err)
+	}
+	return inner, n, nil
+}
+
+// parseSequence parses a "sequence" token: Args are group steps in order,
+// plus an optional group with Function "reset".
+func (p *Parser) parseSequence(t domain.Token) (domain.PredNode, error) {
+	raw := map[string]any{}
+	if t.Params != nil {
+		m, ok := t.Params.(map[string]any)
+		if !ok {
+			return nil, diag(t, DiagInvalidParam, "sequence params must be object")
+		}
+		raw = m
+	}
+	spec := []ArgSpec{{Name: "max_gap", Type: "int"}, {Name: "restart", Type: "bool"}}
+	if err := p.checkParams(t, "sequence", spec, slices.Collect(maps.Keys(raw))); err != nil {
+		return nil, err
+	}
+	defaultGap, err := gapParam(raw)
+	if err != nil {
+		if err := p.fail(diag(t, DiagInvalidParam, "sequence: "+err.Error())); err != nil {
+			return nil, err
+		}
+	}
+	seq := domain.SequenceNode{Restart: true}
+	if v, ok := raw["restart"]; ok {
+		b, ok := v.(bool)
+		if !ok {
+			if err := p.fail(diag(t, DiagInvalidParam, "sequence: restart must be boolean")); err != nil {
+				return nil, err
+			}
+		}
+		seq.Restart = b
+	}
+
+	for i, step := range t.Args {
+		if step.Type != domain.TokenGroup {
+			if err := p.fail(diag(step, DiagSyntax, fmt.Sprintf("sequence step %d must be a group", i+1))); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		pred, err := p.ParsePredicate(step.Args)
+		if err != nil {
+			if err := p.record(fmt.Errorf("sequence step %d: %w", i+1, err), step.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if step.Function == "reset" {
+			if seq.Reset != nil {
+				if err := p.fail(diag(step, DiagSyntax, "sequence: only one reset condition allowed")); err != nil {
+					return nil, err
+				}
+			}
+			seq.Reset = pred
+			continue
+		}
+		gap := defaultGap
+		if m, ok := step.Params.(map[string]any); ok {
+			if _, set := m["max_gap"]; set {
+				if gap, err = gapParam(m); err != nil {
+					if err := p.fail(diag(step, DiagInvalidParam, fmt.Sprintf("sequence step %d: %v", i+1, err))); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		seq.Steps = append(seq.Steps, domain.SequenceStep{Pred: pred, MaxGap: gap})
+	}
+	// count steps as written so a step that failed to parse is not reported twice
+	steps := 0
+	for _, step := range t.Args {
+		if step.Function != "reset" {
+			steps++
+		}
+	}
+	if steps < 2 {
+		if err := p.fail(diag(t, DiagSyntax, "sequence needs at least two steps")); err != nil {
+			return nil, err
+		}
+	}
+	return seq, nil
+}
+
+func gapParam(m map[string]any) (int, error) {
+	v, ok := m["max_gap"]
+	if !ok {
+		return 0, nil
+	}
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != float64(int(f)) {
+		return 0, errors.New("max_gap must be a non-negative integer")
+	}
+	return int(f), nil
+}
+
 func (p *Parser) parseComparison(ts []domain.Token) (domain.CompareNode, error) {
 	// Find the main comparison operator (there should be exactly one)
 	idx := -1
 	for i, t := range ts {
 		if t.Type == domain.TokenOperator && isCompare(t.Operator) {
 			if idx != -1 {
-				return domain.CompareNode{}, errors.New("multiple comparison operators in one clause")
+				d := diag(t, DiagSyntax, "multiple comparison operators in one clause")
+				d.Fix = "join the comparisons with AND or OR"
+				return domain.CompareNode{}, d
 			}
 			idx = i
 		}
 	}
 	if idx == -1 {
-		return domain.CompareNode{}, errors.New("missing comparison operator")
+		d := diag(ts[0], DiagSyntax, "missing comparison operator")
+		d.Fix = "compare with >, <, crosses_above, ..."
+		return domain.CompareNode{}, d
 	}
 	leftTs := ts[:idx]
 	rightTs := ts[idx+1:]
 	if len(leftTs) == 0 || len(rightTs) == 0 {
-		return domain.CompareNode{}, errors.New("incomplete comparison")
+		return domain.CompareNode{}, diag(ts[idx], DiagSyntax, "incomplete comparison")
 	}
 
-	leftExpr, err := p.parseExpr(leftTs)
-	if err != nil {
-		return domain.CompareNode{}, fmt.Errorf("left side: %w", err)
+	// parse both sides before giving up so each gets its diagnostics
+	leftExpr, lerr := p.parseExpr(leftTs)
+	if lerr != nil {
+		lerr = fmt.Errorf("left side: %w", lerr)
+		if err := p.record(lerr, leftTs[0].ID); err != nil {
+			return domain.CompareNode{}, err
+		}
 	}
-	rightExpr, err := p.parseExpr(rightTs)
-	if err != nil {
-		return domain.CompareNode{}, fmt.Errorf("right side: %w", err)
+	rightExpr, rerr := p.parseExpr(rightTs)
+	if rerr != nil {
+		rerr = fmt.Errorf("right side: %w", rerr)
+		if err := p.record(rerr, rightTs[0].ID); err != nil {
+			return domain.CompareNode{}, err
+		}
 	}
 
 	return domain.CompareNode{
@@ -164,7 +410,7 @@ func (p *Parser) parseComparison(ts []domain.Token) (domain.CompareNode, error)
 }
 func (p *Parser) parseExprTokens(ts []domain.Token) (domain.ExprNode, error) {
 	if len(ts) == 0 {
-		return nil, errors.New("empty expression tokens")
+		return nil, &Diagnostic{Code: DiagEmpty, Message: "empty expression tokens"}
 	}
 	return p.parseExpr(ts)
 }
@@ -176,7 +422,7 @@ func (p *Parser) parseExpr(ts []domain.Token) (domain.ExprNode, error) {
 
 	emitOp := func(op string) error {
 		if len(out) < 2 {
-			return errors.New("malformed expression")
+			return diag(ts[0], DiagSyntax, fmt.Sprintf("operator %q is missing an operand", op))
 		}
 		r := out[len(out)-1]
 		out = out[:len(out)-1]
@@ -194,29 +440,47 @@ func (p *Parser) parseExpr(ts []domain.Token) (domain.ExprNode, error) {
 			out = append(out, domain.NumberNode{Value: t.Value})
 
 		case domain.TokenIndicator:
+			_, builtin := p.Reg.Indicators[t.Indicator]
+			if ci, ok := p.Custom[t.Indicator]; ok && !builtin {
+				node, err := p.expandCustom(t, ci)
+				if err != nil {
+					if err := p.fail(err); err != nil {
+						return nil, err
+					}
+					// stand-in so the rest of the expression still parses
+					node = domain.NumberNode{}
+				}
+				out = append(out, node)
+				break
+			}
 			if _, ok := domain.AllowedTF[t.Timeframe]; !ok {
-				return nil, fmt.Errorf("invalid timeframe %q", t.Timeframe)
+				if err := p.fail(invalidTimeframe(t)); err != nil {
+					return nil, err
+				}
 			}
 			params, err := coerceNumMap(t.Params)
 			if err != nil {
-				return nil, fmt.Errorf("indicator %s params: %w", t.Indicator, err)
+				if err := p.fail(diag(t, DiagInvalidParam, fmt.Sprintf("indicator %s params: %v", t.Indicator, err))); err != nil {
+					return nil, err
+				}
 			}
 			spec, ok := p.Reg.Indicators[t.Indicator]
 			if !ok {
-				return nil, fmt.Errorf("unknown indicator %q", t.Indicator)
-			}
-			if err := checkArgs(spec.Params, params); err != nil {
-				return nil, fmt.Errorf("indicator %s: %w", t.Indicator, err)
+				if err := p.fail(p.unknownName(t, "indicator", t.Indicator)); err != nil {
+					return nil, err
+				}
+			} else {
+				if err := p.checkParams(t, "indicator "+t.Indicator, spec.Params, slices.Collect(maps.Keys(params))); err != nil {
+					return nil, err
+				}
+				if err := p.futureRef(t, t.Indicator, spec.FutureRef); err != nil {
+					return nil, err
+				}
 			}
 
-			// ✅ Parse nested args
-			var argNodes []domain.ExprNode
-			for _, argTok := range t.Args {
-				node, err := p.parseExprTokens([]domain.Token{argTok})
-				if err != nil {
-					return nil, fmt.Errorf("indicator %s arg: %w", t.Indicator, err)
-				}
-				argNodes = append(argNodes, node)
+			argNodes, err := p.parseArgs("indicator "+t.Indicator, t.Args)
+			if err != nil {
+				return nil, err
 			}
 
 			out = append(out, domain.IndicatorNode{
@@ -230,29 +494,29 @@ func (p *Parser) parseExpr(ts []domain.Token) (domain.ExprNode, error) {
 		case domain.TokenFunction:
 			spec, ok := p.Reg.Functions[t.Function]
 			if !ok {
-				return nil, fmt.Errorf("unknown function %q", t.Function)
-			}
-			raw, ok := t.Params.(map[string]any)
-			if !ok {
-				return nil, fmt.Errorf("function %s params must be object", t.Function)
+				if err := p.fail(p.unknownName(t, "function", t.Function)); err != nil {
+					return nil, err
+				}
 			}
-			if err := checkFuncArgs(spec.Params, raw); err != nil {
-				return nil, fmt.Errorf("function %s: %w", t.Function, err)
+			raw, isMap := t.Params.(map[string]any)
+			if !isMap {
+				d := diag(t, DiagInvalidParam, fmt.Sprintf("function %s params must be object", t.Function))
+				if err := p.fail(d); err != nil {
+					return nil, err
+				}
 			}
-
-			// ✅ Parse nested args
-			var argNodes []domain.ExprNode
-			for _, argTok := range t.Args {
-				node, err := p.parseExprTokens([]domain.Token{argTok})
-				if err != nil {
-					return nil, fmt.Errorf("indicator %s arg: %w", t.Indicator, err)
+			if ok {
+				if err := p.checkParams(t, "function "+t.Function, spec.Params, slices.Collect(maps.Keys(raw))); err != nil {
+					return nil, err
+				}
+				if err := p.futureRef(t, t.Function, spec.FutureRef); err != nil {
+					return nil, err
 				}
-				argNodes = append(argNodes, node)
 			}
 
-			// Validate params
-			if err := checkFuncArgs(spec.Params, raw); err != nil {
-				return nil, fmt.Errorf("function %s: %w", t.Function, err)
+			argNodes, err := p.parseArgs("function "+t.Function, t.Args)
+			if err != nil {
+				return nil, err
 			}
 
 			out = append(out, domain.FunctionNode{
@@ -261,10 +525,34 @@ func (p *Parser) parseExpr(ts []domain.Token) (domain.ExprNode, error) {
 				Args:   argNodes,
 			})
 
+		case domain.TokenGroup:
+			// parenthesized sub-expression
+			node, err := p.parseExprTokens(t.Args)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, node)
+
+		case domain.TokenPredicate:
+			return nil, diag(t, DiagSyntax, fmt.Sprintf("%s is a condition and cannot be used as a value", t.Function))
+
+		case domain.TokenParam:
+			return nil, diag(t, DiagSyntax, fmt.Sprintf("$%s can only be used inside a custom indicator", t.Function))
+
+		case domain.TokenTemporal:
+			if op, ok := temporalOps[t.Function]; ok && op.isPred {
+				return nil, diag(t, DiagSyntax, fmt.Sprintf("%s is a condition and cannot be used as a value", t.Function))
+			}
+			inner, n, err := p.parseTemporal(t)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, domain.PredSeriesNode{Op: t.Function, Pred: inner, N: n})
+
 		case domain.TokenOperator:
 			op := t.Operator
 			if !isMath(op) {
-				return nil, fmt.Errorf("unexpected operator %q in expression", op)
+				return nil, diag(t, DiagUnknownOperator, fmt.Sprintf("unexpected operator %q in expression", op))
 			}
 			// shunting-yard precedence
 			for len(opst) > 0 && mathPrecedence[opst[len(opst)-1]] >= mathPrecedence[op] {
@@ -276,7 +564,7 @@ func (p *Parser) parseExpr(ts []domain.Token) (domain.ExprNode, error) {
 			opst = append(opst, op)
 
 		default:
-			return nil, fmt.Errorf("unexpected token type %s in expression", t.Type)
+			return nil, diag(t, DiagSyntax, fmt.Sprintf("unexpected token type %s in expression", t.Type))
 		}
 		i++
 	}
@@ -289,11 +577,28 @@ func (p *Parser) parseExpr(ts []domain.Token) (domain.ExprNode, error) {
 	}
 
 	if len(out) != 1 {
-		return nil, errors.New("malformed expression (extra values/operators)")
+		return nil, diag(ts[len(ts)-1], DiagSyntax, "malformed expression (extra values/operators)")
 	}
 	return out[0], nil
 }
 
+// parseArgs parses each nested argument of an indicator or function token.
+// When collecting diagnostics a bad argument is recorded and skipped.
+func (p *Parser) parseArgs(owner string, args []domain.Token) ([]domain.ExprNode, error) {
+	var nodes []domain.ExprNode
+	for _, argTok := range args {
+		node, err := p.parseExprTokens([]domain.Token{argTok})
+		if err != nil {
+			if err := p.record(fmt.Errorf("%s arg: %w", owner, err), argTok.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
 func coerceNumMap(v any) (map[string]float64, error) {
 	if v == nil {
 		return map[string]float64{}, nil
@@ -332,49 +637,3 @@ func toF64(v any) float64 {
 	}
 	return v.(float64)
 }
-
-func checkArgs(spec []ArgSpec, provided map[string]float64) error {
-	req := map[string]bool{}
-	for _, a := range spec {
-		if a.Req {
-			req[a.Name] = true
-		}
-	}
-	for k := range provided {
-		if !slices.ContainsFunc(spec, func(a ArgSpec) bool { return a.Name == k }) {
-			return fmt.Errorf("unknown param %q", k)
-		}
-		delete(req, k)
-	}
-	if len(req) > 0 {
-		return fmt.Errorf("missing params: %v", maps.Keys(req))
-	}
-	return nil
-}
-func checkFuncArgs(spec []ArgSpec, provided map[string]any) error {
-	req := map[string]bool{}
-	for _, a := range spec {
-		if a.Req {
-			req[a.Name] = true
-		}
-	}
-	for k, v := range provided {
-		if !slices.ContainsFunc(spec, func(a ArgSpec) bool { return a.Name == k }) {
-			return fmt.Errorf("unknown param %q", k)
-		}
-		// very light type check
-		// You can extend to allow nested expression structure here
-		switch v.(type) {
-		case float64, int, int32, int64, uint, uint64, float32:
-		case map[string]any, []any:
-			// acceptable if expression is nested
-		default:
-			// allow 0/1 sentinel etc.
-		}
-		delete(req, k)
-	}
-	if len(req) > 0 {
-		return fmt.Errorf("missing params: %v", maps.Keys(req))
-	}
-	return nil
-}
@@ -0,0 +1,312 @@
+// engine/volatility.go
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+)
+
+// Realized volatility estimators. Each returns annualized volatility over a
+// rolling window of p bars (NaN until the window is full), as a decimal:
+// 0.2 is 20%. barsPerYear scales per-bar variance to a year.
+
+// BarsPerYear is the annualization factor for bars of tf: 252 sessions a
+// year, each SessionMinutes long.
+func BarsPerYear(tf domain.Timeframe) float64 {
+	switch tf {
+	case "1W":
+		return 52
+	case "1M":
+		return 12
+	}
+	if m := domain.TimeframeToMinutes[tf]; m > 0 && m <= SessionMinutes {
+		return 252 * float64(SessionMinutes) / float64(m)
+	}
+	return 252
+}
+
+// A volStep is an estimator fed one bar at a time, as a Stream does; the
+// batch estimators run the same steps over the bars.
+type volStep func(b domain.Candle) float64
+
+func runVol(step volStep, bars []domain.Candle) []float64 {
+	out := make([]float64, len(bars))
+	for i, b := range bars {
+		out[i] = step(b)
+	}
+	return out
+}
+
+// meanVolStep annualizes the rolling mean of per-bar variance terms.
+// Terms that are NaN (e.g. the first bar of a return) make the window NaN.
+func meanVolStep(p int, barsPerYear float64, term func(b domain.Candle) float64) volStep {
+	ring := make([]float64, maxInt(p, 1))
+	sum, bad, i := 0.0, 0, 0
+	return func(b domain.Candle) float64 {
+		v := term(b)
+		if math.IsNaN(v) {
+			bad++
+		} else {
+			sum += v
+		}
+		if i >= p {
+			if old := ring[i%p]; math.IsNaN(old) {
+				bad--
+			} else {
+				sum -= old
+			}
+		}
+		if p > 0 {
+			ring[i%p] = v
+		}
+		i++
+		if i < p || bad > 0 || p <= 0 {
+			return math.NaN()
+		}
+		return math.Sqrt(math.Max(0, sum/float64(p)) * barsPerYear)
+	}
+}
+
+// varianceStep is the sample variance of the last p values.
+func varianceStep(p int) func(v float64) float64 {
+	if p < 2 {
+		return func(float64) float64 { return math.NaN() }
+	}
+	step := windowStep(p, 1, func(w ...[]float64) float64 { return sampleVariance(w[0]) })
+	vals := make([]float64, 1)
+	return func(v float64) float64 {
+		vals[0] = v
+		return step(vals)
+	}
+}
+
+func sampleVariance(w []float64) float64 {
+	var mean float64
+	for _, v := range w {
+		mean += v
+	}
+	mean /= float64(len(w))
+	var ss float64
+	for _, v := range w {
+		ss += (v - mean) * (v - mean)
+	}
+	return ss / float64(len(w)-1)
+}
+
+// prevClose returns f of each bar and the close before it, NaN on the first.
+func prevClose(f func(b domain.Candle, prev float64) float64) func(b domain.Candle) float64 {
+	prev := math.NaN()
+	return func(b domain.Candle) float64 {
+		v := f(b, prev)
+		prev = b.Close
+		return v
+	}
+}
+
+// CloseToCloseVol is the sample standard deviation of log returns.
+func CloseToCloseVol(bars []domain.Candle, p int, barsPerYear float64) []float64 {
+	return runVol(closeToCloseStep(p, barsPerYear), bars)
+}
+
+func closeToCloseStep(p int, barsPerYear float64) volStep {
+	ret := prevClose(func(b domain.Candle, prev float64) float64 { return math.Log(b.Close / prev) })
+	variance := varianceStep(p)
+	return func(b domain.Candle) float64 { return math.Sqrt(variance(ret(b)) * barsPerYear) }
+}
+
+// ParkinsonVol uses the high-low range: var = ln(H/L)^2 / (4 ln 2).
+func ParkinsonVol(bars []domain.Candle, p int, barsPerYear float64) []float64 {
+	return runVol(parkinsonStep(p, barsPerYear), bars)
+}
+
+func parkinsonStep(p int, barsPerYear float64) volStep {
+	return meanVolStep(p, barsPerYear, func(b domain.Candle) float64 {
+		hl := math.Log(b.High / b.Low)
+		return hl * hl / (4 * math.Ln2)
+	})
+}
+
+// GarmanKlassVol adds open and close: 0.5 ln(H/L)^2 - (2 ln 2 - 1) ln(C/O)^2.
+func GarmanKlassVol(bars []domain.Candle, p int, barsPerYear float64) []float64 {
+	return runVol(garmanKlassStep(p, barsPerYear), bars)
+}
+
+func garmanKlassStep(p int, barsPerYear float64) volStep {
+	return meanVolStep(p, barsPerYear, func(b domain.Candle) float64 {
+		hl, co := math.Log(b.High/b.Low), math.Log(b.Close/b.Open)
+		return 0.5*hl*hl - (2*math.Ln2-1)*co*co
+	})
+}
+
+// RogersSatchellVol allows for drift: ln(H/C) ln(H/O) + ln(L/C) ln(L/O).
+func RogersSatchellVol(bars []domain.Candle, p int, barsPerYear float64) []float64 {
+	return runVol(rogersSatchellStep(p, barsPerYear), bars)
+}
+
+func rogersSatchellStep(p int, barsPerYear float64) volStep {
+	return meanVolStep(p, barsPerYear, rogersSatchellTerm)
+}
+
+func rogersSatchellTerm(b domain.Candle) float64 {
+	return math.Log(b.High/b.Close)*math.Log(b.High/b.Open) + math.Log(b.Low/b.Close)*math.Log(b.Low/b.Open)
+}
+
+// YangZhangVol combines overnight (open vs previous close), open-to-close
+// and Rogers-Satchell variance: var = var_o + k var_c + (1-k) var_rs with
+// k = 0.34 / (1.34 + (p+1)/(p-1)).
+func YangZhangVol(bars []domain.Candle, p int, barsPerYear float64) []float64 {
+	return runVol(yangZhangStep(p, barsPerYear), bars)
+}
+
+func yangZhangStep(p int, barsPerYear float64) volStep {
+	overnight := prevClose(func(b domain.Candle, prev float64) float64 { return math.Log(b.Open / prev) })
+	vo, vc := varianceStep(p), varianceStep(p)
+	k := 0.34 / (1.34 + float64(p+1)/float64(p-1))
+	ring := make([]float64, maxInt(p, 1))
+	var rsSum float64
+	i := 0
+	return func(b domain.Candle) float64 {
+		o, c := vo(overnight(b)), vc(math.Log(b.Close/b.Open))
+		rs := rogersSatchellTerm(b)
+		rsSum += rs
+		if i >= p && p > 0 {
+			rsSum -= ring[i%p]
+		}
+		if p > 0 {
+			ring[i%p] = rs
+		}
+		i++
+		if i <= p || p < 2 {
+			return math.NaN()
+		}
+		v := o + k*c + (1-k)*rsSum/float64(p)
+		return math.Sqrt(math.Max(0, v) * barsPerYear)
+	}
+}
+
+// VolEstimators maps estimator names to their functions.
+var VolEstimators = map[string]func(bars []domain.Candle, p int, barsPerYear float64) []float64{
+	"close_to_close":  CloseToCloseVol,
+	"parkinson":       ParkinsonVol,
+	"garman_klass":    GarmanKlassVol,
+	"rogers_satchell": RogersSatchellVol,
+	"yang_zhang":      YangZhangVol,
+}
+
+// volSteps are the estimators' steps by name.
+var volSteps = map[string]func(p int, barsPerYear float64) volStep{
+	"close_to_close":  closeToCloseStep,
+	"parkinson":       parkinsonStep,
+	"garman_klass":    garmanKlassStep,
+	"rogers_satchell": rogersSatchellStep,
+	"yang_zhang":      yangZhangStep,
+}
+
+// VolConeWindow is the distribution of one estimator over one lookback.
+type VolConeWindow struct {
+	Window      int                `json:"window"`
+	Percentiles map[string]float64 `json:"percentiles"` // "min", "p10", ..., "max"
+	Current     float64            `json:"current"`     // latest value; 0 without samples
+	Samples     int                `json:"samples"`
+}
+
+var volConeLevels = []struct {
+	name string
+	q    float64
+}{{"min", 0}, {"p10", 0.1}, {"p25", 0.25}, {"p50", 0.5}, {"p75", 0.75}, {"p90", 0.9}, {"max", 1}}
+
+// VolCone computes the realized volatility distribution for each window.
+func VolCone(bars []domain.Candle, estimator string, windows []int, barsPerYear float64) ([]VolConeWindow, error) {
+	est, ok := VolEstimators[estimator]
+	if !ok {
+		return nil, fmt.Errorf("unknown estimator %q", estimator)
+	}
+	out := make([]VolConeWindow, 0, len(windows))
+	for _, w := range windows {
+		if w < 2 {
+			return nil, fmt.Errorf("window %d is too short", w)
+		}
+		var xs []float64
+		for _, v := range est(bars, w, barsPerYear) {
+			if !math.IsNaN(v) {
+				xs = append(xs, v)
+			}
+		}
+		cw := VolConeWindow{Window: w, Percentiles: map[string]float64{}, Samples: len(xs)}
+		if len(xs) > 0 {
+			cw.Current = xs[len(xs)-1]
+			sort.Float64s(xs)
+			for _, l := range volConeLevels {
+				cw.Percentiles[l.name] = quantile(xs, l.q)
+			}
+		}
+		out = append(out, cw)
+	}
+	return out, nil
+}
+
+// quantile of sorted xs with linear interpolation.
+func quantile(xs []float64, q float64) float64 {
+	pos := q * float64(len(xs)-1)
+	lo := int(math.Floor(pos))
+	if lo+1 >= len(xs) {
+		return xs[len(xs)-1]
+	}
+	return xs[lo] + (pos-float64(lo))*(xs[lo+1]-xs[lo])
+}
+
+// PercentRank is the share of xs at or below v, in [0, 1].
+func PercentRank(xs []float64, v float64) float64 {
+	if len(xs) == 0 {
+		return math.NaN()
+	}
+	n := 0
+	for _, x := range xs {
+		if x <= v {
+			n++
+		}
+	}
+	return float64(n) / float64(len(xs))
+}
+
+func registerVolatility(reg *Registry) {
+	vol := func(name, estimator, desc string, lookback func(p int) int) IndicatorSpec {
+		est, step := VolEstimators[estimator], volSteps[estimator]
+		return IndicatorSpec{
+			Category:    "Volatility",
+			Description: desc,
+			Params:      []ArgSpec{{Name: "period", Type: "int", Req: true}},
+			Eval: func(ctx *EvalCtx, tf domain.Timeframe,
+				params map[string]float64, offset int, args ...Series) ([]float64, error) {
+				p := int(params["period"])
+				if p < 2 {
+					return nil, fmt.Errorf("%s period must be at least 2", name)
+				}
+				bars, err := barsOn(ctx, tf)
+				if err != nil {
+					return nil, err
+				}
+				return shiftBack(est(bars, p, BarsPerYear(tf)), offset)
+			},
+			Lookback: func(params map[string]float64) int { return lookback(int(params["period"])) },
+			Stream: func(ctx *EvalCtx, params map[string]float64) IndicatorStep {
+				p := int(params["period"])
+				if p < 2 {
+					return nil
+				}
+				vs := step(p, BarsPerYear(ctx.BaseTF))
+				return func(bar domain.Candle, _ []float64) float64 { return vs(bar) }
+			},
+		}
+	}
+	withPrev := func(p int) int { return p }
+	within := func(p int) int { return p - 1 }
+	reg.Indicators["HV"] = vol("HV", "close_to_close", "Close-to-close historical volatility, annualized", withPrev)
+	reg.Indicators["ParkinsonVol"] = vol("ParkinsonVol", "parkinson", "Parkinson high-low volatility, annualized", within)
+	reg.Indicators["GarmanKlassVol"] = vol("GarmanKlassVol", "garman_klass", "Garman-Klass OHLC volatility, annualized", within)
+	reg.Indicators["RogersSatchellVol"] = vol("RogersSatchellVol", "rogers_satchell", "Rogers-Satchell drift-independent volatility, annualized", within)
+	reg.Indicators["YangZhangVol"] = vol("YangZhangVol", "yang_zhang", "Yang-Zhang volatility with overnight gaps, annualized", withPrev)
+}
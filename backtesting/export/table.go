@@ -0,0 +1,123 @@
+// export/table.go
+package export
+
+import (
+	"math"
+	"time"
+
+	"github.com/gulll/deepmarket/backtesting/domain"
+	"github.com/gulll/deepmarket/backtesting/engine"
+)
+
+// Table is a rectangular view over backtest output. Cells hold time.Time,
+// float64, int, bool or string; the writers decide how to render each one.
+type Table struct {
+	Header []string
+	Rows   [][]any
+}
+
+// Column is a named per-bar series (indicator values, signals, ...).
+type Column struct {
+	Name   string
+	Values []any
+}
+
+// TradesTable lays out one row per closed trade.
+func TradesTable(trades []domain.TradeLog) Table {
+	t := Table{Header: []string{
+		"direction", "entry_time", "entry_price", "exit_time", "exit_price",
+		"exit_reason", "qty", "pnl", "holding_bars",
+	}}
+	for _, tr := range trades {
+		t.Rows = append(t.Rows, []any{
+			tr.Direction, tr.EntryTime, tr.EntryPrice, tr.ExitTime, tr.ExitPrice,
+			tr.ExitReason, tr.Qty, tr.PnL, tr.HoldingBars,
+		})
+	}
+	return t
+}
+
+// EquityTable lays out the per-bar equity curve together with its drawdown
+// from the running peak.
+func EquityTable(candles []domain.Candle, equity []float64) Table {
+	t := Table{Header: []string{"time", "close", "equity", "drawdown"}}
+	peak := math.Inf(-1)
+	for i, eq := range equity {
+		if i >= len(candles) {
+			break
+		}
+		if eq > peak {
+			peak = eq
+		}
+		dd := 0.0
+		if peak > 0 {
+			dd = (eq - peak) / peak
+		}
+		t.Rows = append(t.Rows, []any{candles[i].Time, candles[i].Close, eq, dd})
+	}
+	return t
+}
+
+// BarsTable lays out OHLCV for every bar followed by the extra columns.
+func BarsTable(candles []domain.Candle, cols []Column) Table {
+	t := Table{Header: []string{"time", "open", "high", "low", "close", "volume"}}
+	for _, c := range cols {
+		t.Header = append(t.Header, c.Name)
+	}
+	for i, c := range candles {
+		row := []any{c.Time, c.Open, c.High, c.Low, c.Close, c.Volume}
+		for _, col := range cols {
+			if i < len(col.Values) {
+				row = append(row, col.Values[i])
+			} else {
+				row = append(row, nil)
+			}
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+// PlanColumns collects the indicator and function series computed for a plan,
+// in evaluation order. Names are the node labels, prefixed to keep entry and
+// exit plans apart.
+func PlanColumns(ctx *engine.EvalCtx, pl *engine.Plan, prefix string) []Column {
+	if pl == nil {
+		return nil
+	}
+	var cols []Column
+	for _, n := range pl.Order {
+		if n.Op != "indicator" && n.Op != "function" {
+			continue
+		}
+		ser, ok := ctx.SeriesOf(n.ID)
+		if !ok {
+			continue
+		}
+		vals := make([]any, len(ser))
+		for i, v := range ser {
+			vals[i] = v
+		}
+		cols = append(cols, Column{Name: prefix + n.Label(), Values: vals})
+	}
+	return cols
+}
+
+// SignalColumn wraps the root boolean series of a plan.
+func SignalColumn(ctx *engine.EvalCtx, pl *engine.Plan, name string) (Column, bool) {
+	if pl == nil || len(pl.Roots) == 0 {
+		return Column{}, false
+	}
+	bs, ok := ctx.BoolOf(pl.Roots[0].ID)
+	if !ok {
+		return Column{}, false
+	}
+	vals := make([]any, len(bs))
+	for i, v := range bs {
+		vals[i] = v
+	}
+	return Column{Name: name, Values: vals}, true
+}
+
+// timeLayout is used by every writer unless the format overrides it.
+const timeLayout = time.RFC3339
@@ -0,0 +1,133 @@
+// export/writers.go
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+	// FormatExcel is CSV tuned for spreadsheets: UTF-8 BOM, CRLF line endings
+	// and "YYYY-MM-DD HH:MM:SS" timestamps that Excel recognises as dates.
+	FormatExcel Format = "excel"
+)
+
+// ContentType returns the MIME type for the format.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatJSONL:
+		return "application/x-ndjson"
+	case FormatExcel:
+		return "text/csv; charset=utf-8"
+	}
+	return "text/csv"
+}
+
+// Ext returns the file extension used for downloads.
+func (f Format) Ext() string {
+	if f == FormatJSONL {
+		return "jsonl"
+	}
+	return "csv"
+}
+
+// Write renders the table in the requested format.
+func Write(w io.Writer, t Table, f Format) error {
+	switch f {
+	case FormatCSV:
+		return writeCSV(w, t, timeLayout, false)
+	case FormatExcel:
+		return writeCSV(w, t, "2006-01-02 15:04:05", true)
+	case FormatJSONL:
+		return writeJSONL(w, t)
+	}
+	return fmt.Errorf("unsupported export format %q", f)
+}
+
+func writeCSV(w io.Writer, t Table, layout string, excel bool) error {
+	if excel {
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+	}
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = excel
+	if err := cw.Write(t.Header); err != nil {
+		return err
+	}
+	rec := make([]string, len(t.Header))
+	for _, row := range t.Rows {
+		for i := range rec {
+			rec[i] = ""
+			if i < len(row) {
+				rec[i] = csvCell(row[i], layout)
+			}
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvCell(v any, layout string) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return x.Format(layout)
+	case float64:
+		// empty cells load as NaN in pandas and blanks in spreadsheets
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return ""
+		}
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		if x {
+			return "1"
+		}
+		return "0"
+	}
+	return fmt.Sprint(v)
+}
+
+func writeJSONL(w io.Writer, t Table) error {
+	enc := json.NewEncoder(w)
+	for _, row := range t.Rows {
+		obj := make(map[string]any, len(t.Header))
+		for i, name := range t.Header {
+			if i >= len(row) {
+				obj[name] = nil
+				continue
+			}
+			obj[name] = jsonCell(row[i])
+		}
+		if err := enc.Encode(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func jsonCell(v any) any {
+	switch x := v.(type) {
+	case time.Time:
+		return x.Format(timeLayout)
+	case float64:
+		// encoding/json rejects NaN/Inf
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil
+		}
+	}
+	return v
+}
@@ -0,0 +1,162 @@
+// report/report.go
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+
+	"github.com/gulll/deepmarket/backtesting/domain"
+	"github.com/gulll/deepmarket/backtesting/engine"
+)
+
+// Input is everything the tearsheet needs from a finished backtest.
+type Input struct {
+	Req         domain.BacktestReq
+	Summary     domain.BacktestSummary
+	Trades      []domain.TradeLog
+	Candles     []domain.Candle
+	Equity      []float64
+	GeneratedAt time.Time
+}
+
+type MonthlyReturn struct {
+	Year   int
+	Month  int
+	Return float64
+}
+
+type metric struct {
+	Name  string
+	Value string
+}
+
+type view struct {
+	Title      string
+	Meta       []metric
+	Entry      string
+	Exit       string
+	Metrics    [][]metric
+	EquitySVG  template.HTML
+	DrawSVG    template.HTML
+	HeatSVG    template.HTML
+	TradeSVG   template.HTML
+	Trades     []domain.TradeLog
+	TradeLimit int
+}
+
+// MonthlyReturns compounds the per-bar equity curve into calendar-month returns.
+func MonthlyReturns(candles []domain.Candle, equity []float64) []MonthlyReturn {
+	var out []MonthlyReturn
+	n := min(len(candles), len(equity))
+	if n == 0 {
+		return out
+	}
+	start := equity[0]
+	for i := 0; i < n; i++ {
+		t := candles[i].Time
+		last := i == n-1 || candles[i+1].Time.Month() != t.Month() || candles[i+1].Time.Year() != t.Year()
+		if !last {
+			continue
+		}
+		r := 0.0
+		if start != 0 {
+			r = equity[i]/start - 1
+		}
+		out = append(out, MonthlyReturn{Year: t.Year(), Month: int(t.Month()), Return: r})
+		start = equity[i]
+	}
+	return out
+}
+
+// Drawdowns returns the fractional drawdown from the running peak for each bar.
+func Drawdowns(equity []float64) []float64 {
+	out := make([]float64, len(equity))
+	peak := 0.0
+	for i, v := range equity {
+		if i == 0 || v > peak {
+			peak = v
+		}
+		if peak > 0 {
+			out[i] = (v - peak) / peak
+		}
+	}
+	return out
+}
+
+// Render writes a self-contained HTML tearsheet (no external assets).
+func Render(w io.Writer, in Input) error {
+	req := in.Req
+	xl := [2]string{"", ""}
+	if len(in.Candles) > 0 {
+		xl = [2]string{
+			in.Candles[0].Time.Format("2006-01-02"),
+			in.Candles[len(in.Candles)-1].Time.Format("2006-01-02"),
+		}
+	}
+
+	pnls := make([]float64, len(in.Trades))
+	for i, t := range in.Trades {
+		pnls[i] = t.PnL
+	}
+
+	v := view{
+		Title: fmt.Sprintf("%s %s backtest", req.Symbol, req.BaseTF),
+		Meta: []metric{
+			{"Symbol", req.Symbol},
+			{"Timeframe", string(req.BaseTF)},
+			{"Direction", req.Direction},
+			{"Quantity", fmt.Sprint(req.Quantity)},
+			{"Capital", fmt.Sprintf("%.2f", req.Capital)},
+			{"Stop loss", pct(req.StopLoss / 100)},
+			{"Take profit", pct(req.TakeProfit / 100)},
+			{"Trailing SL", pct(req.TrailingSL / 100)},
+			{"Period", xl[0] + " to " + xl[1]},
+			{"Bars", fmt.Sprint(len(in.Candles))},
+			{"Generated", in.GeneratedAt.Format(time.RFC1123)},
+		},
+		Entry:      engine.FormatTokens(req.EntryConditions.Tokens),
+		EquitySVG:  LineChart(in.Equity, xl, "eq"),
+		DrawSVG:    UnderwaterChart(Drawdowns(in.Equity), xl),
+		HeatSVG:    Heatmap(MonthlyReturns(in.Candles, in.Equity)),
+		TradeSVG:   Histogram(pnls, 30),
+		Trades:     in.Trades,
+		TradeLimit: 500,
+	}
+	if req.ExitConditions != nil {
+		v.Exit = engine.FormatTokens(req.ExitConditions.Tokens)
+	}
+
+	s := in.Summary
+	v.Metrics = [][]metric{
+		{
+			{"Net profit", fmt.Sprintf("%.2f", s.NetProfit)},
+			{"Total trades", fmt.Sprint(s.TotalTrades)},
+			{"Win rate", pct(s.WinRate)},
+			{"Profit factor", fmt.Sprintf("%.2f", s.ProfitFactor)},
+			{"Expectancy", fmt.Sprintf("%.2f", s.Expectancy)},
+			{"Avg win / loss", fmt.Sprintf("%.2f / %.2f", s.AvgWin, s.AvgLoss)},
+		},
+		{
+			{"CAGR", pct(s.CAGR)},
+			{"Sharpe", fmt.Sprintf("%.2f", s.SharpeRatio)},
+			{"Sortino", fmt.Sprintf("%.2f", s.SortinoRatio)},
+			{"Calmar", fmt.Sprintf("%.2f", s.CalmarRatio)},
+			{"Omega", fmt.Sprintf("%.2f", s.OmegaRatio)},
+			{"Volatility", pct(s.EquityVolatility)},
+		},
+		{
+			{"Max drawdown", pct(s.MaxDrawdown)},
+			{"Avg drawdown", pct(s.AvgDrawdown)},
+			{"Ulcer index", fmt.Sprintf("%.4f", s.UlcerIndex)},
+			{"Recovery factor", fmt.Sprintf("%.2f", s.RecoveryFactor)},
+			{"Max consec. wins / losses", fmt.Sprintf("%d / %d", s.MaxConsecWins, s.MaxConsecLosses)},
+			{"Exposure", pct(s.ExposureRatio)},
+		},
+	}
+
+	return page.Execute(w, v)
+}
+
+func pct(v float64) string { return fmt.Sprintf("%.2f%%", v*100) }
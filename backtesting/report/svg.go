@@ -0,0 +1,190 @@
+// report/svg.go
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"slices"
+	"strings"
+)
+
+const (
+	chartW = 900
+	chartH = 240
+	padL   = 60
+	padR   = 10
+	padT   = 10
+	padB   = 24
+)
+
+// bounds returns min/max ignoring NaN, widened when flat so scaling never divides by zero.
+func bounds(vals []float64) (lo, hi float64) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+	for _, v := range vals {
+		if math.IsNaN(v) {
+			continue
+		}
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+	if math.IsInf(lo, 1) {
+		return 0, 1
+	}
+	if lo == hi {
+		lo, hi = lo-1, hi+1
+	}
+	return lo, hi
+}
+
+func scaleX(i, n int) float64 {
+	if n <= 1 {
+		return padL
+	}
+	return padL + float64(i)*float64(chartW-padL-padR)/float64(n-1)
+}
+
+func scaleY(v, lo, hi float64) float64 {
+	return padT + (hi-v)*float64(chartH-padT-padB)/(hi-lo)
+}
+
+func axes(b *strings.Builder, lo, hi float64, xLabels [2]string) {
+	fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" class="axis"/>`, padL, padT, padL, chartH-padB)
+	fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" class="axis"/>`, padL, chartH-padB, chartW-padR, chartH-padB)
+	fmt.Fprintf(b, `<text x="%d" y="%d" class="lbl" text-anchor="end">%s</text>`, padL-4, padT+10, fmtNum(hi))
+	fmt.Fprintf(b, `<text x="%d" y="%d" class="lbl" text-anchor="end">%s</text>`, padL-4, chartH-padB, fmtNum(lo))
+	fmt.Fprintf(b, `<text x="%d" y="%d" class="lbl">%s</text>`, padL, chartH-6, template.HTMLEscapeString(xLabels[0]))
+	fmt.Fprintf(b, `<text x="%d" y="%d" class="lbl" text-anchor="end">%s</text>`, chartW-padR, chartH-6, template.HTMLEscapeString(xLabels[1]))
+}
+
+// LineChart draws a single polyline series.
+func LineChart(vals []float64, xLabels [2]string, class string) template.HTML {
+	lo, hi := bounds(vals)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %d %d" class="chart">`, chartW, chartH)
+	axes(&b, lo, hi, xLabels)
+	fmt.Fprintf(&b, `<polyline class="%s" points="`, class)
+	for i, v := range vals {
+		if math.IsNaN(v) {
+			continue
+		}
+		fmt.Fprintf(&b, "%.1f,%.1f ", scaleX(i, len(vals)), scaleY(v, lo, hi))
+	}
+	b.WriteString(`"/></svg>`)
+	return template.HTML(b.String())
+}
+
+// UnderwaterChart draws drawdown (<= 0) as a filled area hanging from zero.
+func UnderwaterChart(dd []float64, xLabels [2]string) template.HTML {
+	lo, _ := bounds(dd)
+	if lo >= 0 {
+		lo = -0.01
+	}
+	hi := 0.0
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %d %d" class="chart">`, chartW, chartH)
+	axes(&b, lo*100, hi, xLabels)
+	fmt.Fprintf(&b, `<polygon class="dd" points="%.1f,%.1f `, scaleX(0, len(dd)), scaleY(0, lo, hi))
+	for i, v := range dd {
+		fmt.Fprintf(&b, "%.1f,%.1f ", scaleX(i, len(dd)), scaleY(v, lo, hi))
+	}
+	fmt.Fprintf(&b, `%.1f,%.1f"/></svg>`, scaleX(len(dd)-1, len(dd)), scaleY(0, lo, hi))
+	return template.HTML(b.String())
+}
+
+// Histogram draws the distribution of values across the given number of bins,
+// colouring bins left of zero as losses.
+func Histogram(vals []float64, bins int) template.HTML {
+	// like bounds, skip values that cannot be placed on the axis
+	vals = slices.DeleteFunc(slices.Clone(vals), func(v float64) bool { return math.IsNaN(v) || math.IsInf(v, 0) })
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %d %d" class="chart">`, chartW, chartH)
+	if len(vals) == 0 || bins <= 0 {
+		b.WriteString(`<text x="50%" y="50%" class="lbl" text-anchor="middle">no trades</text></svg>`)
+		return template.HTML(b.String())
+	}
+	lo, hi := bounds(vals)
+	counts := make([]int, bins)
+	width := (hi - lo) / float64(bins)
+	maxCount := 0
+	for _, v := range vals {
+		k := int((v - lo) / width)
+		if k >= bins {
+			k = bins - 1
+		}
+		counts[k]++
+		if counts[k] > maxCount {
+			maxCount = counts[k]
+		}
+	}
+	axes(&b, 0, float64(maxCount), [2]string{fmtNum(lo), fmtNum(hi)})
+	bw := float64(chartW-padL-padR) / float64(bins)
+	for k, c := range counts {
+		x := padL + float64(k)*bw
+		y := scaleY(float64(c), 0, float64(maxCount))
+		class := "win"
+		if lo+float64(k+1)*width <= 0 {
+			class = "loss"
+		}
+		fmt.Fprintf(&b, `<rect class="%s" x="%.1f" y="%.1f" width="%.1f" height="%.1f"><title>%s..%s: %d</title></rect>`,
+			class, x+1, y, bw-2, float64(chartH-padB)-y, fmtNum(lo+float64(k)*width), fmtNum(lo+float64(k+1)*width), c)
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// Heatmap draws monthly returns as a year x month grid.
+func Heatmap(m []MonthlyReturn) template.HTML {
+	const cell, rowH, left, top = 60, 26, 50, 20
+	years := []int{}
+	byKey := map[[2]int]float64{}
+	maxAbs := 0.0
+	for _, r := range m {
+		if len(years) == 0 || years[len(years)-1] != r.Year {
+			years = append(years, r.Year)
+		}
+		byKey[[2]int{r.Year, r.Month}] = r.Return
+		maxAbs = math.Max(maxAbs, math.Abs(r.Return))
+	}
+	if maxAbs == 0 {
+		maxAbs = 1
+	}
+	months := [...]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %d %d" class="heat">`, left+12*cell, top+len(years)*rowH)
+	for i, name := range months {
+		fmt.Fprintf(&b, `<text x="%d" y="14" class="lbl" text-anchor="middle">%s</text>`, left+i*cell+cell/2, name)
+	}
+	for row, y := range years {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" class="lbl" text-anchor="end">%d</text>`, left-6, top+row*rowH+17, y)
+		for mo := 1; mo <= 12; mo++ {
+			v, ok := byKey[[2]int{y, mo}]
+			if !ok {
+				continue
+			}
+			// green for gains, red for losses, opacity by magnitude
+			color := "46,160,67"
+			if v < 0 {
+				color = "218,54,51"
+			}
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="rgba(%s,%.2f)"/>`,
+				left+(mo-1)*cell+1, top+row*rowH+1, cell-2, rowH-2, color, 0.15+0.85*math.Abs(v)/maxAbs)
+			fmt.Fprintf(&b, `<text x="%d" y="%d" class="lbl" text-anchor="middle">%.1f%%</text>`,
+				left+(mo-1)*cell+cell/2, top+row*rowH+17, v*100)
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+func fmtNum(v float64) string {
+	switch {
+	case math.Abs(v) >= 1e6:
+		return fmt.Sprintf("%.2fM", v/1e6)
+	case math.Abs(v) >= 1e4:
+		return fmt.Sprintf("%.1fk", v/1e3)
+	case math.Abs(v) >= 100:
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.2f", v)
+}
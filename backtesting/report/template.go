@@ -0,0 +1,57 @@
+// report/template.go
+package report
+
+import (
+	"html/template"
+	"time"
+)
+
+var page = template.Must(template.New("tearsheet").Funcs(template.FuncMap{
+	"num":  func(v float64) string { return fmtNum(v) },
+	"time": func(v time.Time) string { return v.Format("2006-01-02 15:04") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body{font-family:-apple-system,Segoe UI,Roboto,sans-serif;margin:24px;color:#1f2328;background:#fff}
+h1{font-size:22px;margin:0 0 12px}h2{font-size:16px;margin:24px 0 8px;border-bottom:1px solid #d0d7de;padding-bottom:4px}
+table{border-collapse:collapse;font-size:13px}td,th{padding:4px 10px;border-bottom:1px solid #eaeef2;text-align:left}
+th{background:#f6f8fa}.grid{display:flex;gap:24px;flex-wrap:wrap}
+pre{background:#f6f8fa;padding:10px;white-space:pre-wrap;font-size:13px}
+.chart{width:100%;max-width:900px;height:auto}.heat{max-width:780px;width:100%;height:auto}
+.axis{stroke:#8c959f;stroke-width:1}.lbl{font-size:11px;fill:#57606a}
+.eq{fill:none;stroke:#0969da;stroke-width:1.5}.dd{fill:rgba(218,54,51,.35);stroke:#da3633;stroke-width:1}
+.win{fill:#2da44e}.loss{fill:#da3633}.neg{color:#da3633}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="grid">
+<table>{{range .Meta}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>{{end}}</table>
+{{range .Metrics}}<table>{{range .}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>{{end}}</table>{{end}}
+</div>
+
+<h2>Strategy</h2>
+<pre>ENTRY: {{.Entry}}{{if .Exit}}
+EXIT:  {{.Exit}}{{end}}</pre>
+
+<h2>Equity</h2>
+{{.EquitySVG}}
+<h2>Drawdown (%)</h2>
+{{.DrawSVG}}
+<h2>Monthly returns</h2>
+{{.HeatSVG}}
+<h2>Trade PnL distribution</h2>
+{{.TradeSVG}}
+
+<h2>Trades</h2>
+<table>
+<tr><th>#</th><th>Side</th><th>Entry</th><th>Entry px</th><th>Exit</th><th>Exit px</th><th>Reason</th><th>Qty</th><th>PnL</th></tr>
+{{$limit := .TradeLimit}}{{range $i, $t := .Trades}}{{if lt $i $limit}}<tr><td>{{$i}}</td><td>{{$t.Direction}}</td><td>{{time $t.EntryTime}}</td><td>{{num $t.EntryPrice}}</td><td>{{time $t.ExitTime}}</td><td>{{num $t.ExitPrice}}</td><td>{{$t.ExitReason}}</td><td>{{$t.Qty}}</td><td{{if lt $t.PnL 0.0}} class="neg"{{end}}>{{num $t.PnL}}</td></tr>
+{{end}}{{end}}</table>
+{{if gt (len .Trades) .TradeLimit}}<p>Showing first {{.TradeLimit}} of {{len .Trades}} trades.</p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,84 @@
+// Command alertsink is a stand-in webhook receiver for testing alert
+// delivery. It checks each request's signature against the subscription
+// secret and logs the alert:
+//
+//	go run ./cmd/alertsink -secret <subscription secret>
+//
+// then save a subscription with webhook_url http://localhost:9090/. The
+// server only delivers to public addresses unless the host is listed in
+// WEBHOOK_ALLOWED_HOSTS, so run it with WEBHOOK_ALLOWED_HOSTS=localhost
+// (in the environment or .env.local).
+// -fail N answers the first N requests with 500 to exercise retries.
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	secret := flag.String("secret", "", "subscription secret; signatures are not checked when empty")
+	fail := flag.Int64("fail", 0, "answer this many first requests with 500")
+	flag.Parse()
+
+	var seen atomic.Int64
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n := seen.Add(1)
+		if n <= *fail {
+			log.Printf("#%d alert %s: failing on purpose", n, r.Header.Get("X-Alert-Id"))
+			http.Error(w, "failing on purpose", http.StatusInternalServerError)
+			return
+		}
+		if *secret != "" {
+			if err := verify(*secret, r.Header, body); err != "" {
+				log.Printf("#%d alert %s: rejected: %s", n, r.Header.Get("X-Alert-Id"), err)
+				http.Error(w, err, http.StatusUnauthorized)
+				return
+			}
+		}
+		log.Printf("#%d alert %s: %s", n, r.Header.Get("X-Alert-Id"), body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// verify checks X-Alert-Signature, the hex HMAC-SHA256 of the timestamp, a
+// dot and the body, and refuses timestamps over five minutes old.
+func verify(secret string, h http.Header, body []byte) string {
+	ts := h.Get("X-Alert-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "bad timestamp"
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > 5*time.Minute || d < -5*time.Minute {
+		return "stale timestamp"
+	}
+	got, ok := strings.CutPrefix(h.Get("X-Alert-Signature"), "sha256=")
+	if !ok {
+		return "missing signature"
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(got), []byte(want)) {
+		return "bad signature"
+	}
+	return ""
+}
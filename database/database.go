@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/gulll/deepmarket/models"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -38,5 +39,10 @@ func Init() {
 		log.Fatalf("Failed to connect to DB: %v", err)
 	}
 
+	if err := DB.AutoMigrate(&models.CustomIndicator{}, &models.Script{},
+		&models.AlertSubscription{}, &models.Alert{}); err != nil {
+		log.Fatalf("Failed to migrate tables: %v", err)
+	}
+
 	log.Println("Connected to DB and migrated tables!")
 }
@@ -10,6 +10,7 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.2.3
 	github.com/google/uuid v1.6.0
 	github.com/spf13/viper v1.20.1
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09
 	gorm.io/driver/postgres v1.6.0
 	gorm.io/gorm v1.30.0
 )
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gulll/deepmarket/database"
+	"github.com/gulll/deepmarket/models"
+)
+
+// AlertPayload is what an alert delivers over /ws and to webhooks.
+type AlertPayload struct {
+	Type           string    `json:"type"` // always "alert"
+	ID             uint      `json:"id"`
+	SubscriptionID uint      `json:"subscription_id"`
+	Subscription   string    `json:"subscription"`
+	Symbol         string    `json:"symbol"`
+	Timeframe      string    `json:"timeframe"`
+	BarTime        time.Time `json:"bar_time"`
+	Close          float64   `json:"close"`
+	TriggeredAt    time.Time `json:"triggered_at"`
+}
+
+func alertPayload(a models.Alert) AlertPayload {
+	return AlertPayload{
+		Type: "alert", ID: a.ID, SubscriptionID: a.SubscriptionID, Subscription: a.Name,
+		Symbol: a.Symbol, Timeframe: a.Timeframe, BarTime: a.BarTime, Close: a.Close, TriggeredAt: a.CreatedAt,
+	}
+}
+
+// wsClient serialises writes to a websocket, which the alert hub and the
+// connection's own handler both do.
+type wsClient struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func (w *wsClient) WriteJSON(v any) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.conn.WriteJSON(v)
+}
+
+func (w *wsClient) WriteMessage(kind int, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.conn.WriteMessage(kind, data)
+}
+
+// alertHub holds the /ws connections that asked for the alerts of a user.
+type alertHub struct {
+	mu    sync.Mutex
+	conns map[uint]map[*wsClient]struct{}
+}
+
+var alertClients = &alertHub{conns: map[uint]map[*wsClient]struct{}{}}
+
+func (h *alertHub) add(userID uint, w *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conns[userID] == nil {
+		h.conns[userID] = map[*wsClient]struct{}{}
+	}
+	h.conns[userID][w] = struct{}{}
+}
+
+// remove drops w from every user it was added for.
+func (h *alertHub) remove(w *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, set := range h.conns {
+		delete(set, w)
+		if len(set) == 0 {
+			delete(h.conns, id)
+		}
+	}
+}
+
+func (h *alertHub) send(userID uint, p AlertPayload) {
+	h.mu.Lock()
+	conns := make([]*wsClient, 0, len(h.conns[userID]))
+	for w := range h.conns[userID] {
+		conns = append(conns, w)
+	}
+	h.mu.Unlock()
+	for _, w := range conns {
+		if err := w.WriteJSON(p); err != nil {
+			log.Printf("alert %d: websocket write: %v", p.ID, err)
+		}
+	}
+}
+
+// Webhook retries: attempts in all, waiting backoff, 2*backoff, ... between.
+const (
+	webhookAttempts = 5
+	webhookBackoff  = 2 * time.Second
+)
+
+// webhookClient only connects to public addresses, checked on every dial
+// after DNS resolution and redirects alike, so that a webhook URL cannot
+// reach the server's loopback or private network. Hosts listed in
+// WEBHOOK_ALLOWED_HOSTS are exempt; see webhookHostAllowed.
+var webhookClient = &http.Client{Timeout: 10 * time.Second, Transport: publicTransport()}
+
+func publicTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed in place of the webhook host
+	t.Proxy = nil
+	public := &net.Dialer{Timeout: 5 * time.Second, Control: dialPublic}
+	allowed := &net.Dialer{Timeout: 5 * time.Second}
+	t.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(address); err == nil && webhookHostAllowed(host) {
+			return allowed.DialContext(ctx, network, address)
+		}
+		return public.DialContext(ctx, network, address)
+	}
+	return t
+}
+
+var errNotPublic = errors.New("webhook address is not public")
+
+// webhookHostAllowed reports whether host is listed in the comma-separated
+// WEBHOOK_ALLOWED_HOSTS, which webhooks may reach whatever they resolve
+// to. It is empty by default; set it to "localhost" to deliver to a local
+// cmd/alertsink.
+func webhookHostAllowed(host string) bool {
+	for _, h := range strings.Split(os.Getenv("WEBHOOK_ALLOWED_HOSTS"), ",") {
+		if h = strings.TrimSpace(h); h != "" && strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublic is false for loopback, private, link-local, multicast and
+// unspecified addresses.
+func isPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublic(ap.Addr()) {
+		return fmt.Errorf("%w: %s", errNotPublic, ap.Addr().Unmap())
+	}
+	return nil
+}
+
+// checkWebhookHost resolves host and refuses it when any of its addresses
+// is not public, unless it is allowed. Delivery checks again on every dial,
+// since what a name resolves to can change after it is saved.
+func checkWebhookHost(host string) error {
+	if webhookHostAllowed(host) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("webhook_url host %s cannot be resolved", host)
+	}
+	for _, ip := range ips {
+		if !isPublic(ip) {
+			return fmt.Errorf("webhook_url host %s is not a public address", host)
+		}
+	}
+	return nil
+}
+
+// signPayload is the hex HMAC-SHA256, keyed with the subscription secret,
+// of the timestamp, a dot and the body. Receivers recompute it from the
+// X-Alert-Timestamp header and the raw body and compare it with
+// X-Alert-Signature (after "sha256="); the timestamp lets them refuse
+// replays.
+func signPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook posts the alert to url, retrying network errors, 5xx, 408
+// and 429 with exponential backoff, and records the outcome on the alert.
+// Every attempt is signed afresh with the current time.
+func deliverWebhook(a models.Alert, url, secret string) {
+	body, _ := json.Marshal(alertPayload(a))
+	wait := webhookBackoff
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		var retry bool
+		retry, err = postWebhook(a.ID, url, secret, body)
+		if err == nil || !retry || attempt == webhookAttempts {
+			break
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+	update := map[string]any{"webhook_attempts": attempt, "webhook_status": "delivered", "webhook_error": ""}
+	if err != nil {
+		update["webhook_status"], update["webhook_error"] = "failed", err.Error()
+		log.Printf("alert %d: webhook failed after %d attempts: %v", a.ID, attempt, err)
+	}
+	if err := database.DB.Model(&models.Alert{}).Where("id = ?", a.ID).Updates(update).Error; err != nil {
+		log.Printf("alert %d: %v", a.ID, err)
+	}
+}
+
+// postWebhook makes one delivery attempt and reports whether a failure is
+// worth retrying.
+func postWebhook(id uint, url, secret string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Alert-Id", strconv.FormatUint(uint64(id), 10))
+	req.Header.Set("X-Alert-Timestamp", ts)
+	req.Header.Set("X-Alert-Signature", "sha256="+signPayload(secret, ts, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return !errors.Is(err, errNotPublic), err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
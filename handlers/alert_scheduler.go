@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/database"
+	"github.com/gulll/deepmarket/models"
+
+	"gorm.io/gorm/clause"
+)
+
+// alertPoll is how often the scheduler looks for new candles.
+const alertPoll = 15 * time.Second
+
+// liveStream evaluates one subscription on one symbol and timeframe.
+type liveStream struct {
+	// key identifies the plan and registry; the stream is rebuilt when the
+	// condition, a custom indicator or a script it uses changes
+	key    string
+	stream *engine.Stream
+	last   time.Time // start of the last bar pushed
+	seen   time.Time // newest 1m candle when last advanced
+	on     bool      // the condition on the last bar
+	failed bool      // stopped after an error until key changes
+	primed int       // bars pushed when the stream was last primed
+}
+
+// Streams with nodes evaluated over the history keep every bar pushed. Such
+// a stream is primed again from recent history, see rebuild, once it holds
+// more than twice the bars it was primed with plus rebuildMargin, so that
+// its memory and cost per bar stay bounded.
+const rebuildMargin = 500
+
+type alertScheduler struct {
+	reg     *engine.Registry
+	dp      *engine.PGProvider
+	streams map[string]*liveStream // by subscription ID, symbol and timeframe
+}
+
+// StartAlertScheduler evaluates the active alert subscriptions in the
+// background. Every alertPoll it checks each subscribed symbol for new 1m
+// candles in ohlc_data_nse_eq and pushes the bars they complete through a
+// Stream per subscription, symbol and timeframe. A new stream is first fed
+// recent history without alerting, so bars that closed while the server was
+// down do not alert.
+func StartAlertScheduler(reg *engine.Registry) {
+	s := &alertScheduler{reg: reg, dp: engine.NewPGProvider(database.DB), streams: map[string]*liveStream{}}
+	go func() {
+		for range time.Tick(alertPoll) {
+			s.tick()
+		}
+	}()
+}
+
+func (s *alertScheduler) tick() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("alerts: tick panicked: %v", r)
+		}
+	}()
+	var rows []models.AlertSubscription
+	if err := database.DB.Where("active").Find(&rows).Error; err != nil {
+		log.Printf("alerts: %v", err)
+		return
+	}
+	parsers := map[uint]*engine.Parser{}
+	latest := map[string]time.Time{}
+	live := map[string]bool{}
+	for _, row := range rows {
+		sub, err := alertSubFromRow(row)
+		if err != nil {
+			log.Printf("alerts: subscription %d: %v", row.ID, err)
+			continue
+		}
+		parser, ok := parsers[sub.UserID]
+		if !ok {
+			if parser, err = parserFor(sub.UserID, &engine.Parser{Reg: s.reg}); err != nil {
+				log.Printf("alerts: user %d: %v", sub.UserID, err)
+				continue
+			}
+			parsers[sub.UserID] = parser
+		}
+		for _, tf := range sub.TFs {
+			pl, err := alertPlan(parser, sub.Cond, tf)
+			for _, sym := range sub.Syms {
+				id := fmt.Sprintf("%d|%s|%s", sub.ID, sym, tf)
+				live[id] = true
+				if _, ok := latest[sym]; !ok {
+					t, err := s.dp.LatestTime(sym)
+					if err != nil {
+						log.Printf("alerts: %s: %v", sym, err)
+					}
+					latest[sym] = t
+				}
+				s.advance(id, sub, parser.Reg, pl, err, sym, tf, latest[sym])
+			}
+		}
+	}
+	for id := range s.streams {
+		if !live[id] {
+			delete(s.streams, id)
+		}
+	}
+}
+
+// advance pushes the bars of sym completed since the last call and alerts
+// on those the condition fires on.
+func (s *alertScheduler) advance(id string, sub alertSub, reg *engine.Registry,
+	pl *engine.Plan, planErr error, sym string, tf domain.Timeframe, latest time.Time) {
+
+	key := "error: " + fmt.Sprint(planErr)
+	if planErr == nil {
+		key = reg.Key + "|" + pl.Roots[0].ID
+	}
+	ls := s.streams[id]
+	if ls == nil || ls.key != key {
+		ls = &liveStream{key: key}
+		s.streams[id] = ls
+		var err error
+		if ls.stream, err = s.newStream(pl, planErr, reg, sym, tf); err != nil {
+			ls.failed = true
+			log.Printf("alerts: %s (%s): %v", sub.Name, id, err)
+		}
+	}
+	if ls.failed || latest.IsZero() || !latest.After(ls.seen) {
+		return
+	}
+
+	from, priming := ls.last, ls.last.IsZero()
+	if priming {
+		from = latest.AddDate(0, 0, -historyDays(pl.Warmup, tf))
+	}
+	bars, err := s.dp.LoadSince(sym, tf, from)
+	if err != nil {
+		log.Printf("alerts: %s (%s): %v", sub.Name, id, err)
+		return
+	}
+	ls.seen = latest
+	for _, bar := range closedBars(bars, tf, latest) {
+		if !bar.Time.After(ls.last) {
+			continue
+		}
+		on, err := ls.stream.Push(bar)
+		if err != nil {
+			ls.failed = true
+			log.Printf("alerts: %s (%s): %v", sub.Name, id, err)
+			return
+		}
+		fire := on && (sub.Repeat || !ls.on)
+		ls.on, ls.last = on, bar.Time
+		if fire && !priming {
+			fireAlert(sub, sym, tf, bar)
+		}
+	}
+	if priming {
+		ls.primed = ls.stream.Len()
+	}
+	if ls.stream.Len() > 2*ls.primed+rebuildMargin && len(ls.stream.Fallbacks()) > 0 {
+		if err := s.rebuild(ls, pl, reg, sym, tf); err != nil {
+			log.Printf("alerts: %s (%s): rebuild: %v", sub.Name, id, err)
+		}
+	}
+}
+
+// rebuild replaces the stream of ls with a new one primed with the history
+// up to its last bar. The state that decides alerts (last, on) carries over.
+func (s *alertScheduler) rebuild(ls *liveStream, pl *engine.Plan, reg *engine.Registry,
+	sym string, tf domain.Timeframe) error {
+	bars, err := s.dp.LoadSince(sym, tf, ls.last.AddDate(0, 0, -historyDays(pl.Warmup, tf)))
+	if err != nil {
+		return err
+	}
+	st, err := s.newStream(pl, nil, reg, sym, tf)
+	if err != nil {
+		return err
+	}
+	for _, bar := range bars {
+		if bar.Time.After(ls.last) {
+			break
+		}
+		if _, err := st.Push(bar); err != nil {
+			return err
+		}
+	}
+	ls.stream, ls.primed = st, st.Len()
+	return nil
+}
+
+func (s *alertScheduler) newStream(pl *engine.Plan, planErr error, reg *engine.Registry,
+	sym string, tf domain.Timeframe) (*engine.Stream, error) {
+	if planErr != nil {
+		return nil, planErr
+	}
+	return engine.NewStream(engine.NewEvalCtx(sym, tf, s.dp, reg), pl)
+}
+
+// historyDays is the calendar span loaded to prime a stream: a few times
+// the plan's warm-up so recursive indicators such as EMA settle.
+func historyDays(warmup int, tf domain.Timeframe) int {
+	bars := max(3*warmup, 100)
+	sessions := bars*domain.TimeframeToMinutes[tf]/engine.SessionMinutes + 1
+	return min(sessions*7/5+7, 730)
+}
+
+// closedBars drops the last bar while it is still forming, i.e. until the
+// 1m candle of its final minute is stored. The session close cuts the
+// last bar of a day short.
+func closedBars(bars []domain.Candle, tf domain.Timeframe, latest time.Time) []domain.Candle {
+	if len(bars) == 0 {
+		return bars
+	}
+	last := bars[len(bars)-1].Time
+	end := last.Add(time.Duration(domain.TimeframeToMinutes[tf]) * time.Minute)
+	if cl, err := time.Parse("15:04", engine.SessionClose); err == nil {
+		y, m, d := last.Date()
+		// the close minute itself is still loaded
+		if sessEnd := time.Date(y, m, d, cl.Hour(), cl.Minute()+1, 0, 0, last.Location()); sessEnd.Before(end) {
+			end = sessEnd
+		}
+	}
+	if latest.Before(end.Add(-time.Minute)) {
+		return bars[:len(bars)-1]
+	}
+	return bars
+}
+
+// fireAlert stores the alert and delivers it, unless the bar has already
+// alerted for the subscription.
+func fireAlert(sub alertSub, sym string, tf domain.Timeframe, bar domain.Candle) {
+	a := models.Alert{
+		SubscriptionID: sub.ID, UserID: sub.UserID, Name: sub.Name,
+		Symbol: sym, Timeframe: string(tf), BarTime: bar.Time, Close: bar.Close,
+	}
+	if sub.WebhookURL != "" {
+		a.WebhookStatus = "pending"
+	}
+	res := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&a)
+	if res.Error != nil {
+		log.Printf("alerts: %s: %v", sub.Name, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		return
+	}
+	alertClients.send(sub.UserID, alertPayload(a))
+	if sub.WebhookURL != "" {
+		go deliverWebhook(a, sub.WebhookURL, sub.Secret)
+	}
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/database"
+	"github.com/gulll/deepmarket/middleware"
+	"github.com/gulll/deepmarket/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type AlertSubscriptionReq struct {
+	Name       string             `json:"name"`
+	Condition  domain.Condition   `json:"condition"`
+	Symbols    []string           `json:"symbols"`
+	Timeframes []domain.Timeframe `json:"timeframes"`
+	Repeat     bool               `json:"repeat"`
+	Active     *bool              `json:"active,omitempty"` // default true
+	WebhookURL string             `json:"webhook_url,omitempty"`
+}
+
+type AlertSubscriptionResp struct {
+	ID         uint               `json:"id"`
+	Name       string             `json:"name"`
+	Condition  domain.Condition   `json:"condition"`
+	Symbols    []string           `json:"symbols"`
+	Timeframes []domain.Timeframe `json:"timeframes"`
+	Repeat     bool               `json:"repeat"`
+	Active     bool               `json:"active"`
+	WebhookURL string             `json:"webhook_url,omitempty"`
+	// Secret verifies webhook signatures, see signPayload.
+	Secret    string    `json:"secret"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// alertSub is a subscription row with its JSON columns decoded.
+type alertSub struct {
+	models.AlertSubscription
+	Cond domain.Condition
+	Syms []string
+	TFs  []domain.Timeframe
+}
+
+func alertSubFromRow(row models.AlertSubscription) (alertSub, error) {
+	s := alertSub{AlertSubscription: row}
+	if err := json.Unmarshal([]byte(row.Condition), &s.Cond); err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal([]byte(row.Symbols), &s.Syms); err != nil {
+		return s, err
+	}
+	err := json.Unmarshal([]byte(row.Timeframes), &s.TFs)
+	return s, err
+}
+
+func (s alertSub) resp() AlertSubscriptionResp {
+	return AlertSubscriptionResp{
+		ID: s.ID, Name: s.Name, Condition: s.Cond, Symbols: s.Syms, Timeframes: s.TFs,
+		Repeat: s.Repeat, Active: s.Active, WebhookURL: s.WebhookURL, Secret: s.Secret, UpdatedAt: s.UpdatedAt,
+	}
+}
+
+// alertPlan plans cond on tf for live evaluation, where future bars are
+// never available.
+func alertPlan(parser *engine.Parser, cond domain.Condition, tf domain.Timeframe) (*engine.Plan, error) {
+	live := *parser
+	live.NoFutureRef = true
+	pred, err := live.ParsePredicate(cond.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	return engine.NewPlanner(tf, parser.Reg).Build(pred)
+}
+
+func checkAlertSubscription(parser *engine.Parser, req AlertSubscriptionReq) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return errors.New("name is required")
+	}
+	if len(req.Symbols) == 0 || len(req.Timeframes) == 0 {
+		return errors.New("at least one symbol and timeframe is required")
+	}
+	for _, s := range req.Symbols {
+		if strings.TrimSpace(s) == "" {
+			return errors.New("empty symbol")
+		}
+	}
+	for _, tf := range req.Timeframes {
+		if _, ok := domain.AllowedTF[tf]; !ok {
+			return errors.New("Invalid Timeframe " + string(tf))
+		}
+		if _, err := alertPlan(parser, req.Condition, tf); err != nil {
+			return err
+		}
+	}
+	if req.WebhookURL != "" {
+		u, err := url.Parse(req.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("webhook_url must be an http(s) URL")
+		}
+		if err := checkWebhookHost(u.Hostname()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListAlertSubscriptionsHandler returns the caller's alert subscriptions.
+func ListAlertSubscriptionsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, ok := middleware.UserID(c)
+		if !ok {
+			return loginRequired(c)
+		}
+		var rows []models.AlertSubscription
+		if err := database.DB.Where("user_id = ?", id).Order("name").Find(&rows).Error; err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		out := make([]AlertSubscriptionResp, 0, len(rows))
+		for _, row := range rows {
+			s, err := alertSubFromRow(row)
+			if err != nil {
+				return c.Status(500).JSON(models.APIResponse{
+					Success: false,
+					Message: err.Error(),
+				})
+			}
+			out = append(out, s.resp())
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Alert subscriptions fetched",
+			Data:    out,
+		})
+	}
+}
+
+// SaveAlertSubscriptionHandler creates or replaces an alert subscription of
+// the caller by name after checking that its condition plans on every
+// timeframe. A new subscription gets a random webhook secret that is kept
+// on later saves.
+func SaveAlertSubscriptionHandler(reg *engine.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, ok := middleware.UserID(c)
+		if !ok {
+			return loginRequired(c)
+		}
+		var req AlertSubscriptionReq
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format " + err.Error(),
+			})
+		}
+		parser, err := parserFor(id, &engine.Parser{Reg: reg})
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		if err := checkAlertSubscription(parser, req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		var row models.AlertSubscription
+		err = database.DB.Where("user_id = ? AND name = ?", id, req.Name).First(&row).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		if row.Secret == "" {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				return c.Status(500).JSON(models.APIResponse{
+					Success: false,
+					Message: err.Error(),
+				})
+			}
+			row.Secret = hex.EncodeToString(b)
+		}
+		cond, _ := json.Marshal(req.Condition)
+		syms, _ := json.Marshal(req.Symbols)
+		tfs, _ := json.Marshal(req.Timeframes)
+		row.UserID, row.Name = id, req.Name
+		row.Condition, row.Symbols, row.Timeframes = string(cond), string(syms), string(tfs)
+		row.Repeat, row.Active, row.WebhookURL = req.Repeat, req.Active == nil || *req.Active, req.WebhookURL
+		if err := database.DB.Save(&row).Error; err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Alert subscription saved",
+			Data: alertSub{
+				AlertSubscription: row, Cond: req.Condition, Syms: req.Symbols, TFs: req.Timeframes,
+			}.resp(),
+		})
+	}
+}
+
+// DeleteAlertSubscriptionHandler removes an alert subscription of the
+// caller. Its alert history is kept.
+func DeleteAlertSubscriptionHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, ok := middleware.UserID(c)
+		if !ok {
+			return loginRequired(c)
+		}
+		res := database.DB.Where("user_id = ? AND name = ?", id, c.Params("name")).Delete(&models.AlertSubscription{})
+		if res.Error != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: res.Error.Error(),
+			})
+		}
+		if res.RowsAffected == 0 {
+			return c.Status(404).JSON(models.APIResponse{
+				Success: false,
+				Message: "Alert subscription not found",
+			})
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Alert subscription deleted",
+		})
+	}
+}
+
+// ListAlertsHandler returns the caller's alert history, newest first.
+// Optional query params: subscription (name), symbol, from and to (epoch
+// seconds or millis of the bar) and limit (default 100, at most 1000).
+func ListAlertsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, ok := middleware.UserID(c)
+		if !ok {
+			return loginRequired(c)
+		}
+		q := database.DB.Where("user_id = ?", id)
+		if name := c.Query("subscription"); name != "" {
+			q = q.Where("name = ?", name)
+		}
+		if sym := c.Query("symbol"); sym != "" {
+			q = q.Where("symbol = ?", sym)
+		}
+		if from := c.QueryInt("from"); from > 0 {
+			q = q.Where("bar_time >= ?", parseEpoch(int64(from)))
+		}
+		if to := c.QueryInt("to"); to > 0 {
+			q = q.Where("bar_time <= ?", parseEpoch(int64(to)))
+		}
+		limit := c.QueryInt("limit", 100)
+		if limit <= 0 || limit > 1000 {
+			limit = 1000
+		}
+		var rows []models.Alert
+		if err := q.Order("bar_time DESC, id DESC").Limit(limit).Find(&rows).Error; err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Alerts fetched",
+			Data:    rows,
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"errors"
+
 	"github.com/gulll/deepmarket/backtesting/adapters"
 	"github.com/gulll/deepmarket/backtesting/controller"
 	domain "github.com/gulll/deepmarket/backtesting/domain"
@@ -10,95 +12,229 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-func BacktestRunHandler(reg *engine.Registry, dp engine.DataProvider) fiber.Handler {
-	parser := &engine.Parser{Reg: reg}
+// backtestRun holds everything produced by a single backtest execution so
+// that handlers can shape the response (summary, exports, ...) as they need.
+type backtestRun struct {
+	Req     domain.BacktestReq
+	Candles []domain.Candle
+	// Fills holds the real candle each bar completed on; it is Candles
+	// unless an alternative bar type was requested.
+	Fills     []domain.Candle
+	Ctx       *engine.EvalCtx
+	EntryPlan *engine.Plan
+	ExitPlan  *engine.Plan
+	Trades    []domain.TradeLog
+	Signal    []bool
+	Equity    []float64
+	Warmup    int // leading bars before the plans are warm
+	// Pre is the number of warm-up bars loaded before the requested range.
+	// They are cut from the per-bar outputs above but not from Ctx.
+	Pre int
+}
 
-	return func(c *fiber.Ctx) error {
-		var req domain.BacktestReq
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(400).JSON(models.APIResponse{
-				Success: false,
-				Message: "Invalid Request format " + err.Error(),
-			})
-		}
+func (r *backtestRun) symbolResult() domain.BacktestSymbolResult {
+	res := controller.BuildSymbolResult(r.Req.Symbol, r.Fills, r.Trades, r.Signal)
+	if r.Req.Bars != nil {
+		res.Candles = r.Candles
+		res.Bars = r.Req.Bars.Type
+	}
+	if r.Req.Pair != nil {
+		res.Pair = r.Req.Pair.Symbol
+	}
+	res.WarmupBars = min(r.Warmup, len(r.Candles))
+	return res
+}
 
-		if _, ok := domain.AllowedTF[req.BaseTF]; !ok {
-			return c.Status(400).JSON(models.APIResponse{
-				Success: false,
-				Message: "Invalid Base Timeframe",
-			})
+// executeBacktest parses, plans and runs the request with parser.Reg. On
+// failure it returns the HTTP status that should be reported along with the
+// error.
+func executeBacktest(parser *engine.Parser, dp engine.DataProvider,
+	req domain.BacktestReq) (*backtestRun, int, error) {
+
+	if _, ok := domain.AllowedTF[req.BaseTF]; !ok {
+		return nil, 400, errors.New("Invalid Base Timeframe")
+	}
+	if req.Bars != nil {
+		if err := engine.ValidateBarSpec(*req.Bars); err != nil {
+			return nil, 400, err
 		}
+	}
+	if req.Pair != nil {
+		switch {
+		case req.Pair.Symbol == "" || req.Pair.Symbol == req.Symbol:
+			return nil, 400, errors.New("pair symbol must be set and differ from symbol")
+		case req.Bars != nil:
+			return nil, 400, errors.New("pair backtests need time bars")
+		}
+	}
+
+	// --- ENTRY PLAN ---
+	// entries must not see future bars
+	entryParser := *parser
+	entryParser.NoFutureRef = true
+	entryPred, err := entryParser.ParsePredicate(req.EntryConditions.Tokens)
+	if err != nil {
+		return nil, 400, err
+	}
+
+	planner := engine.NewPlanner(req.BaseTF, parser.Reg)
+	entryPlan, err := planner.Build(entryPred)
+	if err != nil {
+		return nil, 400, err
+	}
 
-		// --- ENTRY PLAN ---
-		entryPred, err := parser.ParsePredicate(req.EntryConditions.Tokens)
+	// --- EXIT PLAN (optional) ---
+	var exitPlan *engine.Plan // default nil
+	if req.ExitConditions != nil && len(req.ExitConditions.Tokens) > 0 {
+		exitPred, err := parser.ParsePredicate(req.ExitConditions.Tokens)
 		if err != nil {
-			return c.Status(400).JSON(models.APIResponse{
-				Success: false,
-				Message: err.Error(),
-			})
+			return nil, 400, err
 		}
 
-		planner := engine.NewPlanner(req.BaseTF)
-		entryPlan, err := planner.Build(entryPred)
+		planner = engine.NewPlanner(req.BaseTF, parser.Reg)
+		exitPlan, err = planner.Build(exitPred)
 		if err != nil {
-			return c.Status(400).JSON(models.APIResponse{
-				Success: false,
-				Message: err.Error(),
-			})
+			return nil, 400, err
 		}
+	}
 
-		// --- EXIT PLAN (optional) ---
-		var exitPlan *engine.Plan // default nil
-		if req.ExitConditions != nil && len(req.ExitConditions.Tokens) > 0 {
-			exitPred, err := parser.ParsePredicate(req.ExitConditions.Tokens)
-			if err != nil {
-				return c.Status(400).JSON(models.APIResponse{
-					Success: false,
-					Message: err.Error(),
-				})
-			}
-
-			planner = engine.NewPlanner(req.BaseTF)
-			exitPlan, err = planner.Build(exitPred)
-			if err != nil {
-				return c.Status(400).JSON(models.APIResponse{
-					Success: false,
-					Message: err.Error(),
-				})
-			}
+	// --- DATA LOADING ---
+	ctx := engine.NewEvalCtx(req.Symbol, req.BaseTF, dp, parser.Reg)
+	// fetch enough history before the range for the conditions to be warm
+	warmup := entryPlan.Warmup
+	if exitPlan != nil {
+		warmup = max(warmup, exitPlan.Warmup)
+	}
+	if req.Pair != nil {
+		// and for the hedge ratio that sizes the second leg
+		warmup = max(warmup, controller.HedgeWindow(*req.Pair)-1)
+	}
+	ohlc, fills, pre, err := loadBacktestBars(dp, req, warmup)
+	if err != nil {
+		return nil, 500, err
+	}
+
+	ctx.SetCache(adapters.CandlesToSeries(ohlc))
+	var pairCloses []float64
+	if req.Pair != nil {
+		pairCloses, err = engine.LoadPairCloses(dp, req.Pair.Symbol, req.BaseTF, warmup, ohlc)
+		if err != nil {
+			return nil, 500, err
 		}
+		ctx.SetPair(req.Pair.Symbol, pairCloses)
+	}
+	if cp, ok := dp.(*engine.CachedProvider); ok {
+		if req.Bars != nil {
+			cp.AttachBars(ctx, ohlc, *req.Bars)
+		} else {
+			cp.Attach(ctx, ohlc)
+		}
+	}
+	rt := engine.NewRuntime(ctx)
+
+	// --- RUN BACKTEST ---
+	// conditions run on the bars, fills on the real candles behind them
+	var trades []domain.TradeLog
+	var signal []bool
+	var equity []float64
+	if req.Pair != nil {
+		trades, signal, equity, err = controller.RunPairBacktest(
+			req, rt, entryPlan, exitPlan, fills, pairCloses, pre,
+		)
+	} else {
+		trades, signal, equity, err = controller.RunBacktest(
+			req, req.Symbol, ctx, rt, entryPlan, exitPlan, fills, pre,
+		)
+	}
+	if err != nil {
+		return nil, 500, err
+	}
+	// report the requested range only; no trade was entered before it
+	ohlc, fills, signal, equity = ohlc[pre:], fills[pre:], signal[pre:], equity[pre:]
 
-		// --- DATA LOADING ---
-		ctx := engine.NewEvalCtx(req.Symbol, req.BaseTF, dp, reg)
-		ohlc, err := dp.LoadOHLCV(req.Symbol, req.BaseTF)
+	return &backtestRun{
+		Req:       req,
+		Candles:   ohlc,
+		Fills:     fills,
+		Ctx:       ctx,
+		EntryPlan: entryPlan,
+		ExitPlan:  exitPlan,
+		Trades:    trades,
+		Signal:    signal,
+		Equity:    equity,
+		Warmup:    max(warmup-pre, 0),
+		Pre:       pre,
+	}, 200, nil
+}
+
+// loadBacktestBars loads the base series of req: time candles, or the
+// requested bar type with the real candle each bar completed on, and how
+// many warm-up bars precede the requested range. Warm-up history is only
+// prepended where bars map one to one onto base candles.
+func loadBacktestBars(dp engine.DataProvider, req domain.BacktestReq, warmup int) ([]domain.Candle, []domain.Candle, int, error) {
+	tf := req.BaseTF
+	if req.Bars != nil {
+		tf = engine.BarSourceTF(*req.Bars, req.BaseTF)
+	}
+	var src []domain.Candle
+	var pre int
+	var err error
+	if wl, ok := dp.(engine.WarmupLoader); ok && tf == req.BaseTF {
+		src, pre, err = wl.LoadOHLCVWithWarmup(req.Symbol, tf, warmup)
+	} else {
+		src, err = dp.LoadOHLCV(req.Symbol, tf)
+	}
+	if err != nil || req.Bars == nil {
+		return src, src, pre, err
+	}
+	bars, err := engine.BuildBars(*req.Bars, src)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	// pre is only set for the bar types built one to one from base candles
+	return bars.Bars, bars.Real, pre, nil
+}
+
+func BacktestRunHandler(reg *engine.Registry, dp engine.DataProvider) fiber.Handler {
+	parser := &engine.Parser{Reg: reg}
+
+	return func(c *fiber.Ctx) error {
+		parser, err := userParser(c, parser)
 		if err != nil {
 			return c.Status(500).JSON(models.APIResponse{
 				Success: false,
 				Message: err.Error(),
 			})
 		}
+		var req domain.BacktestReq
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format " + err.Error(),
+			})
+		}
 
-		ctx.SetCache(adapters.CandlesToSeries(ohlc))
-		rt := engine.NewRuntime(ctx)
-
-		// --- RUN BACKTEST ---
-		trades, _, equity, err := controller.RunBacktest(
-			req, req.Symbol, ctx, rt, entryPlan, exitPlan, ohlc,
-		)
+		run, status, err := executeBacktest(parser, dp, req)
 		if err != nil {
-			return c.Status(500).JSON(models.APIResponse{
+			return c.Status(status).JSON(models.APIResponse{
 				Success: false,
 				Message: err.Error(),
 			})
 		}
 
 		// --- SUMMARY ---
-		summary := controller.ComputeSummary(trades, equity, float64(req.Capital))
+		summary := controller.ComputeSummary(run.Trades, run.Equity, float64(req.Capital))
 
 		return c.JSON(models.APIResponse{
 			Success: true,
 			Message: "Backtest completed",
-			Data:    summary,
+			Data: domain.BacktestResp{
+				BaseTF: req.BaseTF,
+				Results: []domain.BacktestSymbolResult{
+					run.symbolResult(),
+				},
+				Summary: summary,
+			},
 		})
 	}
 }
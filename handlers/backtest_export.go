@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strings"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/backtesting/export"
+	"github.com/gulll/deepmarket/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// attachmentName joins parts into a download file name, replacing anything
+// but letters, digits, '.', '-' and '_' so that a symbol cannot break out of
+// the quoted Content-Disposition filename.
+func attachmentName(ext string, parts ...string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, strings.Join(parts, "_"))
+	return name + "." + ext
+}
+
+// exportFormat picks the format from ?format= and falls back to the Accept header.
+func exportFormat(c *fiber.Ctx) (export.Format, bool) {
+	switch f := export.Format(c.Query("format")); f {
+	case export.FormatCSV, export.FormatJSONL, export.FormatExcel:
+		return f, true
+	case "":
+	default:
+		return "", false
+	}
+	switch c.Accepts("text/csv", "application/x-ndjson", "application/jsonl") {
+	case "application/x-ndjson", "application/jsonl":
+		return export.FormatJSONL, true
+	}
+	return export.FormatCSV, true
+}
+
+// BacktestExportHandler runs a backtest and streams one of its outputs:
+//
+//	POST /backtest/export/trades   one row per trade
+//	POST /backtest/export/equity   per-bar equity curve and drawdown
+//	POST /backtest/export/signals  per-bar OHLCV, indicator values and entry/exit signals
+//
+// The format is chosen with ?format=csv|jsonl|excel or the Accept header.
+func BacktestExportHandler(reg *engine.Registry, dp engine.DataProvider) fiber.Handler {
+	parser := &engine.Parser{Reg: reg}
+
+	return func(c *fiber.Ctx) error {
+		parser, err := userParser(c, parser)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		kind := c.Params("kind")
+		if kind != "trades" && kind != "equity" && kind != "signals" {
+			return c.Status(404).JSON(models.APIResponse{
+				Success: false,
+				Message: "Unknown export " + kind,
+			})
+		}
+		format, ok := exportFormat(c)
+		if !ok {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Unsupported format " + c.Query("format"),
+			})
+		}
+
+		var req domain.BacktestReq
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format " + err.Error(),
+			})
+		}
+
+		run, status, err := executeBacktest(parser, dp, req)
+		if err != nil {
+			return c.Status(status).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		var table export.Table
+		switch kind {
+		case "trades":
+			table = export.TradesTable(run.Trades)
+		case "equity":
+			table = export.EquityTable(run.Candles, run.Equity)
+		case "signals":
+			cols := export.PlanColumns(run.Ctx, run.EntryPlan, "entry:")
+			cols = append(cols, export.PlanColumns(run.Ctx, run.ExitPlan, "exit:")...)
+			if col, ok := export.SignalColumn(run.Ctx, run.EntryPlan, "entry_signal"); ok {
+				cols = append(cols, col)
+			}
+			if col, ok := export.SignalColumn(run.Ctx, run.ExitPlan, "exit_signal"); ok {
+				cols = append(cols, col)
+			}
+			// plan series still cover the warm-up bars cut from Candles
+			for i := range cols {
+				cols[i].Values = cols[i].Values[min(run.Pre, len(cols[i].Values)):]
+			}
+			table = export.BarsTable(run.Candles, cols)
+		}
+
+		c.Set(fiber.HeaderContentType, format.ContentType())
+		c.Set(fiber.HeaderContentDisposition,
+			fmt.Sprintf(`attachment; filename="%s"`, attachmentName(format.Ext(), req.Symbol, kind)))
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := export.Write(w, table, format); err != nil {
+				log.Printf("export %s for %s failed: %v", kind, req.Symbol, err)
+			}
+			w.Flush()
+		})
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/gulll/deepmarket/backtesting/controller"
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/backtesting/report"
+	"github.com/gulll/deepmarket/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BacktestReportHandler runs a backtest and returns a self-contained HTML
+// tearsheet that can be shared without an account.
+func BacktestReportHandler(reg *engine.Registry, dp engine.DataProvider) fiber.Handler {
+	parser := &engine.Parser{Reg: reg}
+
+	return func(c *fiber.Ctx) error {
+		parser, err := userParser(c, parser)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		var req domain.BacktestReq
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format " + err.Error(),
+			})
+		}
+
+		run, status, err := executeBacktest(parser, dp, req)
+		if err != nil {
+			return c.Status(status).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		var buf bytes.Buffer
+		err = report.Render(&buf, report.Input{
+			Req:         req,
+			Summary:     controller.ComputeSummary(run.Trades, run.Equity, float64(req.Capital)),
+			Trades:      run.Trades,
+			Candles:     run.Candles,
+			Equity:      run.Equity,
+			GeneratedAt: time.Now(),
+		})
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		if c.QueryBool("download") {
+			c.Set(fiber.HeaderContentDisposition,
+				fmt.Sprintf(`attachment; filename="%s"`, attachmentName("html", req.Symbol, string(req.BaseTF), "report")))
+		}
+		return c.Send(buf.Bytes())
+	}
+}
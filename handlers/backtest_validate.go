@@ -11,15 +11,27 @@ import (
 
 type ValidateReq struct {
 	Condition domain.Condition `json:"condition"`
+	// Entry validates the condition for use as an entry, which also rejects
+	// indicators that read future bars.
+	Entry bool `json:"entry,omitempty"`
 }
 type ValidateResp struct {
 	Valid  bool   `json:"valid"`
 	Reason string `json:"reason,omitempty"`
+	// Diagnostics lists every problem found, each tied to a token ID.
+	Diagnostics engine.Diagnostics `json:"diagnostics,omitempty"`
 }
 
 func ValidateConditionHandler(reg *engine.Registry) fiber.Handler {
 	parser := &engine.Parser{Reg: reg}
 	return func(c *fiber.Ctx) error {
+		parser, err := userParser(c, parser)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
 		var req ValidateReq
 		if err := c.BodyParser(&req); err != nil {
 			return c.Status(400).JSON(models.APIResponse{
@@ -27,10 +39,13 @@ func ValidateConditionHandler(reg *engine.Registry) fiber.Handler {
 				Message: "Invalid Request format",
 			})
 		}
-		if err := parser.ValidateCondition(req.Condition); err != nil {
+		p := *parser
+		p.NoFutureRef = req.Entry
+		if ds := p.Validate(req.Condition.Tokens); len(ds) > 0 {
 			return c.Status(200).JSON(models.APIResponse{
 				Success: false,
-				Message: err.Error(),
+				Message: ds.Error(),
+				Data:    ValidateResp{Valid: false, Reason: ds[0].Message, Diagnostics: ds},
 			})
 		}
 		r := models.APIResponse{
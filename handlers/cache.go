@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CacheStatsHandler reports hit/miss/eviction counters of the shared backtest caches.
+func CacheStatsHandler(cp *engine.CachedProvider) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Cache stats",
+			Data:    cp.Stats(),
+		})
+	}
+}
+
+// CacheInvalidateHandler drops cached candles and series for ?symbol=.
+func CacheInvalidateHandler(cp *engine.CachedProvider) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		symbol := c.Query("symbol")
+		if symbol == "" {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "symbol query parameter is required",
+			})
+		}
+		cp.Invalidate(symbol)
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Cache invalidated for " + symbol,
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"github.com/gulll/deepmarket/backtesting/adapters"
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxAuditCheckpoints caps how many prefix re-runs one audit may request.
+const maxAuditCheckpoints = 200
+
+type AuditReq struct {
+	Condition   domain.Condition `json:"condition"`
+	Symbol      string           `json:"symbol"`
+	Timeframe   domain.Timeframe `json:"timeframe"`
+	Checkpoints int              `json:"checkpoints,omitempty"` // default 20
+}
+
+// AuditConditionHandler checks a condition for look-ahead bias by re-running
+// it on truncated history and reporting nodes whose past values change.
+func AuditConditionHandler(reg *engine.Registry, dp engine.DataProvider) fiber.Handler {
+	parser := &engine.Parser{Reg: reg}
+
+	return func(c *fiber.Ctx) error {
+		parser, err := userParser(c, parser)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		var req AuditReq
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format " + err.Error(),
+			})
+		}
+		if _, ok := domain.AllowedTF[req.Timeframe]; !ok {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Timeframe",
+			})
+		}
+		if req.Checkpoints > maxAuditCheckpoints {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Too many checkpoints",
+			})
+		}
+
+		pred, err := parser.ParsePredicate(req.Condition.Tokens)
+		if err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		plan, err := engine.NewPlanner(req.Timeframe, parser.Reg).Build(pred)
+		if err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		ohlc, err := dp.LoadOHLCV(req.Symbol, req.Timeframe)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		ctx := engine.NewEvalCtx(req.Symbol, req.Timeframe, dp, parser.Reg)
+		ctx.SetCache(adapters.CandlesToSeries(ohlc))
+		report, err := engine.AuditLookahead(ctx, plan, req.Checkpoints)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		msg := "No look-ahead found"
+		if !report.Clean {
+			msg = "Look-ahead found"
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: msg,
+			Data:    report,
+		})
+	}
+}
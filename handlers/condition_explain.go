@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/gulll/deepmarket/backtesting/adapters"
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxExplainBars caps how many bars a single explain call returns.
+const maxExplainBars = 500
+
+type ExplainReq struct {
+	Condition domain.Condition `json:"condition"`
+	Symbol    string           `json:"symbol"`
+	Timeframe domain.Timeframe `json:"timeframe"`
+	// Either Timestamp (the bar at or just before it) or a Start/End range.
+	Timestamp *string `json:"timestamp,omitempty"`
+	Start     *string `json:"start,omitempty"`
+	End       *string `json:"end,omitempty"`
+}
+
+type ExplainBar struct {
+	Index int       `json:"index"`
+	Time  time.Time `json:"time"`
+}
+
+type ExplainResp struct {
+	Bars []ExplainBar        `json:"bars"`
+	Tree *engine.ExplainNode `json:"tree"`
+}
+
+var explainTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+func parseExplainTime(s string) (time.Time, error) {
+	for _, layout := range explainTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid time " + s)
+}
+
+// explainBars resolves the requested timestamp or range to candle indices.
+func explainBars(req ExplainReq, candles []domain.Candle) ([]int, error) {
+	if req.Timestamp != nil {
+		ts, err := parseExplainTime(*req.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		// last bar starting at or before ts
+		i := sort.Search(len(candles), func(i int) bool { return candles[i].Time.After(ts) }) - 1
+		if i < 0 {
+			return nil, errors.New("timestamp is before the first bar")
+		}
+		return []int{i}, nil
+	}
+	if req.Start == nil || req.End == nil {
+		return nil, errors.New("timestamp or start/end is required")
+	}
+	start, err := parseExplainTime(*req.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseExplainTime(*req.End)
+	if err != nil {
+		return nil, err
+	}
+	var bars []int
+	for i, c := range candles {
+		if c.Time.Before(start) || c.Time.After(end) {
+			continue
+		}
+		if len(bars) == maxExplainBars {
+			return nil, errors.New("range covers too many bars")
+		}
+		bars = append(bars, i)
+	}
+	if len(bars) == 0 {
+		return nil, errors.New("no bars in range")
+	}
+	return bars, nil
+}
+
+// ExplainConditionHandler evaluates a condition and returns the value of every
+// node of its plan at the requested bars, shaped like the condition AST.
+func ExplainConditionHandler(reg *engine.Registry, dp engine.DataProvider) fiber.Handler {
+	parser := &engine.Parser{Reg: reg}
+
+	return func(c *fiber.Ctx) error {
+		parser, err := userParser(c, parser)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		var req ExplainReq
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format " + err.Error(),
+			})
+		}
+		if _, ok := domain.AllowedTF[req.Timeframe]; !ok {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Timeframe",
+			})
+		}
+
+		pred, err := parser.ParsePredicate(req.Condition.Tokens)
+		if err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		plan, err := engine.NewPlanner(req.Timeframe, parser.Reg).Build(pred)
+		if err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		ohlc, err := dp.LoadOHLCV(req.Symbol, req.Timeframe)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		bars, err := explainBars(req, ohlc)
+		if err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		ctx := engine.NewEvalCtx(req.Symbol, req.Timeframe, dp, parser.Reg)
+		ctx.SetCache(adapters.CandlesToSeries(ohlc))
+		if cp, ok := dp.(*engine.CachedProvider); ok {
+			cp.Attach(ctx, ohlc)
+		}
+		tree, err := engine.NewRuntime(ctx).Explain(plan, bars)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		resp := ExplainResp{Tree: tree}
+		for _, i := range bars {
+			resp.Bars = append(resp.Bars, ExplainBar{Index: i, Time: ohlc[i].Time})
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Condition explained",
+			Data:    resp,
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ParseReq struct {
+	Text string `json:"text"`
+	// Timeframe is used for indicators written without one, e.g. "RSI(Close, 14)".
+	Timeframe domain.Timeframe `json:"timeframe"`
+}
+
+type ParseResp struct {
+	Tokens []domain.Token         `json:"tokens,omitempty"`
+	Spans  map[string]engine.Span `json:"spans,omitempty"`
+	Text   string                 `json:"text,omitempty"` // canonical formatting
+	Errors []*engine.DSLError     `json:"errors,omitempty"`
+	// Diagnostics from validating the parsed tokens; locate them with Spans.
+	Diagnostics engine.Diagnostics `json:"diagnostics,omitempty"`
+}
+
+// ParseConditionHandler turns condition text into tokens. Syntax errors are
+// reported with their line and column; the tokens are also run through the
+// regular validator so unknown params and the like surface here too, as
+// diagnostics whose token IDs index Spans.
+func ParseConditionHandler(reg *engine.Registry) fiber.Handler {
+	parser := &engine.Parser{Reg: reg}
+	return func(c *fiber.Ctx) error {
+		parser, err := userParser(c, parser)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		var req ParseReq
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format",
+			})
+		}
+		if _, ok := domain.AllowedTF[req.Timeframe]; !ok {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Timeframe",
+			})
+		}
+
+		dsl := &engine.DSLParser{Reg: parser.Reg, DefaultTF: req.Timeframe, Custom: parser.Custom}
+		tokens, spans, err := dsl.Parse(req.Text)
+		if err != nil {
+			resp := ParseResp{}
+			if de, ok := err.(*engine.DSLError); ok {
+				resp.Errors = append(resp.Errors, de)
+			}
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+				Data:    resp,
+			})
+		}
+
+		resp := ParseResp{Tokens: tokens, Spans: spans}
+		if ds := parser.Validate(tokens); len(ds) > 0 {
+			resp.Diagnostics = ds
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: ds.Error(),
+				Data:    resp,
+			})
+		}
+		pred, err := parser.ParsePredicate(tokens)
+		if err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+				Data:    resp,
+			})
+		}
+		resp.Text = engine.FormatPred(pred)
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Condition parsed",
+			Data:    resp,
+		})
+	}
+}
+
+type FormatReq struct {
+	Condition domain.Condition `json:"condition"`
+}
+
+// FormatConditionHandler renders a token condition as DSL text.
+func FormatConditionHandler(reg *engine.Registry) fiber.Handler {
+	parser := &engine.Parser{Reg: reg}
+	return func(c *fiber.Ctx) error {
+		parser, err := userParser(c, parser)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		var req FormatReq
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format",
+			})
+		}
+		pred, err := parser.ParsePredicate(req.Condition.Tokens)
+		if err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Condition formatted",
+			Data:    fiber.Map{"text": engine.FormatPred(pred)},
+		})
+	}
+}
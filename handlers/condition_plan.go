@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type PlanReq struct {
+	Condition domain.Condition `json:"condition"`
+	Timeframe domain.Timeframe `json:"timeframe"`
+}
+
+// PlanConditionHandler returns the planned DAG for a condition, as JSON by
+// default or as Graphviz DOT with ?format=dot.
+func PlanConditionHandler(reg *engine.Registry) fiber.Handler {
+	parser := &engine.Parser{Reg: reg}
+	return func(c *fiber.Ctx) error {
+		parser, err := userParser(c, parser)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		var req PlanReq
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format",
+			})
+		}
+		if _, ok := domain.AllowedTF[req.Timeframe]; !ok {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Timeframe",
+			})
+		}
+		pred, err := parser.ParsePredicate(req.Condition.Tokens)
+		if err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		plan, err := engine.NewPlanner(req.Timeframe, parser.Reg).Build(pred)
+		if err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		info := engine.InspectPlan(plan)
+		if c.Query("format") == "dot" {
+			c.Set(fiber.HeaderContentType, "text/vnd.graphviz")
+			return c.SendString(info.DOT())
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Plan built",
+			Data:    info,
+		})
+	}
+}
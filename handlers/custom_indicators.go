@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	domain "github.com/gulll/deepmarket/backtesting/domain"
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/database"
+	"github.com/gulll/deepmarket/middleware"
+	"github.com/gulll/deepmarket/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type CustomIndicatorReq struct {
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Params      []engine.CustomParam `json:"params"`
+	// The expression as DSL text, e.g. "(EMA(Close, $fast) - EMA(Close, $slow)) / ATR(14)",
+	// or as tokens; Text wins when both are set.
+	Text   string         `json:"text,omitempty"`
+	Tokens []domain.Token `json:"tokens,omitempty"`
+}
+
+type CustomIndicatorResp struct {
+	ID          uint                 `json:"id"`
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Params      []engine.CustomParam `json:"params"`
+	Tokens      []domain.Token       `json:"tokens"`
+	Text        string               `json:"text"`
+	UpdatedAt   time.Time            `json:"updated_at"`
+}
+
+func customFromRow(row models.CustomIndicator) (engine.CustomIndicator, error) {
+	ci := engine.CustomIndicator{Name: row.Name, Description: row.Description}
+	if err := json.Unmarshal([]byte(row.Params), &ci.Params); err != nil {
+		return ci, err
+	}
+	err := json.Unmarshal([]byte(row.Body), &ci.Body)
+	return ci, err
+}
+
+// loadCustomIndicators returns the saved indicators of a user by name.
+func loadCustomIndicators(userID uint) (map[string]engine.CustomIndicator, error) {
+	var rows []models.CustomIndicator
+	if err := database.DB.Where("user_id = ?", userID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[string]engine.CustomIndicator, len(rows))
+	for _, row := range rows {
+		ci, err := customFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		out[ci.Name] = ci
+	}
+	return out, nil
+}
+
+// userParser returns parser with the caller's saved indicators and a
+// registry holding their scripts, or parser itself for anonymous requests.
+// Plans and runtimes of the request must use its Reg.
+func userParser(c *fiber.Ctx, parser *engine.Parser) (*engine.Parser, error) {
+	id, ok := middleware.UserID(c)
+	if !ok {
+		return parser, nil
+	}
+	return parserFor(id, parser)
+}
+
+// parserFor is userParser for a known user.
+func parserFor(userID uint, parser *engine.Parser) (*engine.Parser, error) {
+	custom, err := loadCustomIndicators(userID)
+	if err != nil {
+		return nil, err
+	}
+	scripts, err := loadScripts(userID)
+	if err != nil {
+		return nil, err
+	}
+	reg, err := parser.Reg.WithScripts(scripts...)
+	if err != nil {
+		return nil, err
+	}
+	p := *parser
+	p.Reg, p.Custom = reg, custom
+	return &p, nil
+}
+
+func loginRequired(c *fiber.Ctx) error {
+	return c.Status(401).JSON(models.APIResponse{
+		Success: false,
+		Message: "Login required",
+	})
+}
+
+// ListCustomIndicatorsHandler returns the caller's saved indicators.
+func ListCustomIndicatorsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, ok := middleware.UserID(c)
+		if !ok {
+			return loginRequired(c)
+		}
+		var rows []models.CustomIndicator
+		if err := database.DB.Where("user_id = ?", id).Order("name").Find(&rows).Error; err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		out := make([]CustomIndicatorResp, 0, len(rows))
+		for _, row := range rows {
+			ci, err := customFromRow(row)
+			if err != nil {
+				return c.Status(500).JSON(models.APIResponse{
+					Success: false,
+					Message: err.Error(),
+				})
+			}
+			out = append(out, CustomIndicatorResp{
+				ID: row.ID, Name: ci.Name, Description: ci.Description, Params: ci.Params,
+				Tokens: ci.Body, Text: engine.FormatTokens(ci.Body), UpdatedAt: row.UpdatedAt,
+			})
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Custom indicators fetched",
+			Data:    out,
+		})
+	}
+}
+
+// SaveCustomIndicatorHandler creates or replaces a saved indicator of the
+// caller after checking that its expression parses and has no cycles. The
+// expression may call the caller's scripts and other custom indicators.
+func SaveCustomIndicatorHandler(reg *engine.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, ok := middleware.UserID(c)
+		if !ok {
+			return loginRequired(c)
+		}
+		var req CustomIndicatorReq
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format " + err.Error(),
+			})
+		}
+		parser, err := userParser(c, &engine.Parser{Reg: reg})
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		ci := engine.CustomIndicator{Name: req.Name, Description: req.Description, Params: req.Params, Body: req.Tokens}
+		if req.Text != "" {
+			// no default timeframe: unqualified indicators follow the call's
+			dsl := &engine.DSLParser{Reg: parser.Reg, Custom: parser.Custom}
+			if ci.Body, _, err = dsl.ParseExpr(req.Text); err != nil {
+				return c.Status(400).JSON(models.APIResponse{
+					Success: false,
+					Message: err.Error(),
+				})
+			}
+		}
+		if len(ci.Body) == 0 {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "text or tokens is required",
+			})
+		}
+		if err := parser.CheckCustom(ci); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+
+		params, _ := json.Marshal(ci.Params)
+		body, _ := json.Marshal(ci.Body)
+		var row models.CustomIndicator
+		err = database.DB.Where("user_id = ? AND name = ?", id, ci.Name).First(&row).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		row.UserID, row.Name, row.Description = id, ci.Name, ci.Description
+		row.Params, row.Body = string(params), string(body)
+		if err := database.DB.Save(&row).Error; err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Custom indicator saved",
+			Data: CustomIndicatorResp{
+				ID: row.ID, Name: ci.Name, Description: ci.Description, Params: ci.Params,
+				Tokens: ci.Body, Text: engine.FormatTokens(ci.Body), UpdatedAt: row.UpdatedAt,
+			},
+		})
+	}
+}
+
+// DeleteCustomIndicatorHandler removes a saved indicator of the caller.
+// Conditions and other custom indicators still calling it stop parsing.
+func DeleteCustomIndicatorHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, ok := middleware.UserID(c)
+		if !ok {
+			return loginRequired(c)
+		}
+		res := database.DB.Where("user_id = ? AND name = ?", id, c.Params("name")).Delete(&models.CustomIndicator{})
+		if res.Error != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: res.Error.Error(),
+			})
+		}
+		if res.RowsAffected == 0 {
+			return c.Status(404).JSON(models.APIResponse{
+				Success: false,
+				Message: "Custom indicator not found",
+			})
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Custom indicator deleted",
+		})
+	}
+}
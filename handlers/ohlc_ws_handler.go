@@ -11,6 +11,7 @@ import (
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gulll/deepmarket/database"
+	"github.com/gulll/deepmarket/middleware"
 	"github.com/gulll/deepmarket/models"
 )
 
@@ -26,7 +27,10 @@ type OHLC struct {
 }
 
 type InitOrRangeRequest struct {
-	Type      string `json:"type"` // "init" or "range"
+	// "init" or "range", or "alerts" to receive the alerts of the user
+	// Token was issued to on this connection
+	Type      string `json:"type"`
+	Token     string `json:"token,omitempty"`
 	Symbol    string `json:"symbol"`
 	StartTime int64  `json:"startTime"`
 	EndTime   int64  `json:"endTime"`
@@ -136,6 +140,8 @@ func streamOHLC(conn *websocket.Conn, symbol string, expiry time.Time, strike fl
 
 func WsHandler(c *websocket.Conn) {
 	defer c.Close()
+	client := &wsClient{conn: c}
+	defer alertClients.remove(client)
 
 	for {
 		_, msg, err := c.ReadMessage()
@@ -150,6 +156,17 @@ func WsHandler(c *websocket.Conn) {
 			continue
 		}
 
+		if req.Type == "alerts" {
+			id, err := middleware.ParseToken(req.Token)
+			if err != nil {
+				client.WriteJSON(fiber.Map{"type": "alerts", "error": "Invalid token"})
+				continue
+			}
+			alertClients.add(id, client)
+			client.WriteJSON(fiber.Map{"type": "alerts", "message": "Subscribed to alerts"})
+			continue
+		}
+
 		log.Println("Received request:", req)
 
 		start := parseEpoch(req.StartTime)
@@ -184,7 +201,7 @@ func WsHandler(c *websocket.Conn) {
 
 		if err != nil {
 			log.Println("DB query failed:", err)
-			c.WriteJSON(fiber.Map{"error": "Database error"})
+			client.WriteJSON(fiber.Map{"error": "Database error"})
 			continue
 		}
 		defer rows.Close()
@@ -205,7 +222,7 @@ func WsHandler(c *websocket.Conn) {
 
 		if raw, err := json.Marshal(ohlc); err == nil {
 			if compressed, err := gzipCompress(raw); err == nil {
-				c.WriteMessage(websocket.BinaryMessage, compressed)
+				client.WriteMessage(websocket.BinaryMessage, compressed)
 			}
 		}
 
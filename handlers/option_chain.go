@@ -59,13 +59,7 @@ func FetchOptionChain(c *fiber.Ctx) error {
 		})
 	}
 
-	dbTable := "option_stock_ohlc"
-
-	if ticker == "NIFTY" || ticker == "BANKNIFTY" {
-		dbTable = "option_nifty_ohlc"
-	}
-
-	err = database.DB.Table(dbTable).
+	err = database.DB.Table(optionTable(ticker)).
 		Select("strike_price, option_type, oi, open").
 		Where("symbol = ? AND expiry_date = ? AND candle_time = ?", ticker, expiry, currentTime).
 		Scan(&optionChain).Error
@@ -161,6 +155,14 @@ func FetchOptionChain(c *fiber.Ctx) error {
 	return c.JSON(finalOptions)
 }
 
+// optionTable is the table holding option candles for ticker.
+func optionTable(ticker string) string {
+	if ticker == "NIFTY" || ticker == "BANKNIFTY" {
+		return "option_nifty_ohlc"
+	}
+	return "option_stock_ohlc"
+}
+
 func formatNumber(f float64) string {
 	return humanize.CommafWithDigits(f, 2)
 }
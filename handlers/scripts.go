@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/database"
+	"github.com/gulll/deepmarket/middleware"
+	"github.com/gulll/deepmarket/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ScriptResp struct {
+	ID          uint                 `json:"id"`
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Params      []engine.CustomParam `json:"params"`
+	Warmup      int                  `json:"warmup"`
+	Source      string               `json:"source"`
+	UpdatedAt   time.Time            `json:"updated_at"`
+}
+
+// compiledScripts caches the compiled script of each row ID, so a script is
+// only compiled again after it is saved. Saving or deleting a script drops
+// its entry, and loading replaces one older than the row.
+var compiledScripts sync.Map
+
+type compiledScript struct {
+	updatedAt time.Time
+	cs        *engine.CompiledScript
+}
+
+func scriptFromRow(row models.Script) (engine.Script, error) {
+	s := engine.Script{Name: row.Name, Description: row.Description, Warmup: row.Warmup, Source: row.Source}
+	err := json.Unmarshal([]byte(row.Params), &s.Params)
+	return s, err
+}
+
+// loadScripts returns the compiled scripts of a user, ordered by name.
+func loadScripts(userID uint) ([]*engine.CompiledScript, error) {
+	var rows []models.Script
+	if err := database.DB.Where("user_id = ?", userID).Order("name").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make([]*engine.CompiledScript, 0, len(rows))
+	for _, row := range rows {
+		if c, ok := compiledScripts.Load(row.ID); ok && c.(compiledScript).updatedAt.Equal(row.UpdatedAt) {
+			out = append(out, c.(compiledScript).cs)
+			continue
+		}
+		s, err := scriptFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		cs, err := engine.CompileScript(s, engine.DefaultScriptLimits)
+		if err != nil {
+			return nil, err
+		}
+		compiledScripts.Store(row.ID, compiledScript{row.UpdatedAt, cs})
+		out = append(out, cs)
+	}
+	return out, nil
+}
+
+// ListScriptsHandler returns the caller's saved scripts.
+func ListScriptsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, ok := middleware.UserID(c)
+		if !ok {
+			return loginRequired(c)
+		}
+		var rows []models.Script
+		if err := database.DB.Where("user_id = ?", id).Order("name").Find(&rows).Error; err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		out := make([]ScriptResp, 0, len(rows))
+		for _, row := range rows {
+			s, err := scriptFromRow(row)
+			if err != nil {
+				return c.Status(500).JSON(models.APIResponse{
+					Success: false,
+					Message: err.Error(),
+				})
+			}
+			out = append(out, ScriptResp{
+				ID: row.ID, Name: s.Name, Description: s.Description, Params: s.Params,
+				Warmup: s.Warmup, Source: s.Source, UpdatedAt: row.UpdatedAt,
+			})
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Scripts fetched",
+			Data:    out,
+		})
+	}
+}
+
+// SaveScriptHandler creates or replaces a script of the caller after
+// compiling it. Its name must not clash with a built-in or one of the
+// caller's custom indicators.
+func SaveScriptHandler(reg *engine.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, ok := middleware.UserID(c)
+		if !ok {
+			return loginRequired(c)
+		}
+		var req engine.Script
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format " + err.Error(),
+			})
+		}
+		cs, err := engine.CompileScript(req, engine.DefaultScriptLimits)
+		if err == nil {
+			_, err = reg.WithScripts(cs)
+		}
+		if err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		custom, err := loadCustomIndicators(id)
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		if _, ok := custom[req.Name]; ok {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("%s is already a custom indicator", req.Name),
+			})
+		}
+
+		params, _ := json.Marshal(req.Params)
+		var row models.Script
+		err = database.DB.Where("user_id = ? AND name = ?", id, req.Name).First(&row).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		row.UserID, row.Name, row.Description = id, req.Name, req.Description
+		row.Params, row.Warmup, row.Source = string(params), req.Warmup, req.Source
+		if err := database.DB.Save(&row).Error; err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		compiledScripts.Delete(row.ID)
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Script saved",
+			Data: ScriptResp{
+				ID: row.ID, Name: req.Name, Description: req.Description, Params: req.Params,
+				Warmup: req.Warmup, Source: req.Source, UpdatedAt: row.UpdatedAt,
+			},
+		})
+	}
+}
+
+// DeleteScriptHandler removes a script of the caller.
+func DeleteScriptHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, ok := middleware.UserID(c)
+		if !ok {
+			return loginRequired(c)
+		}
+		var gone []models.Script
+		res := database.DB.Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}}}).
+			Where("user_id = ? AND name = ?", id, c.Params("name")).Delete(&gone)
+		if res.Error != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: res.Error.Error(),
+			})
+		}
+		if res.RowsAffected == 0 {
+			return c.Status(404).JSON(models.APIResponse{
+				Success: false,
+				Message: "Script not found",
+			})
+		}
+		for _, row := range gone {
+			compiledScripts.Delete(row.ID)
+		}
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Script deleted",
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	engine "github.com/gulll/deepmarket/backtesting/engine"
+	"github.com/gulll/deepmarket/database"
+	"github.com/gulll/deepmarket/models"
+	"github.com/gulll/deepmarket/utils/options"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultConeWindows are the cone lookbacks in trading days.
+var defaultConeWindows = []int{10, 20, 30, 60, 90, 120}
+
+type VolConeReq struct {
+	Symbol    string `json:"symbol"`
+	Estimator string `json:"estimator"` // see engine.VolEstimators, default close_to_close
+	Windows   []int  `json:"windows"`   // trading days
+	// Expiry (2006-01-02) and Time (2006-01-02 15:04:05) pick the option
+	// chain snapshot whose ATM implied vol is overlaid on the cone.
+	Expiry string `json:"expiry,omitempty"`
+	Time   string `json:"time,omitempty"`
+}
+
+// ImpliedVolOverlay is the ATM implied vol set against the realized cone
+// window closest to the option's remaining life.
+type ImpliedVolOverlay struct {
+	Expiry       string  `json:"expiry"`
+	Time         string  `json:"time"`
+	Spot         float64 `json:"spot"`
+	Strike       float64 `json:"strike"`
+	CallIV       float64 `json:"call_iv,omitempty"`
+	PutIV        float64 `json:"put_iv,omitempty"`
+	IV           float64 `json:"iv"` // mean of the call and put IV
+	DaysToExpiry float64 `json:"days_to_expiry"`
+	Window       int     `json:"window"`
+	PercentRank  float64 `json:"percent_rank"` // share of the window's realized vols <= IV
+}
+
+type VolConeResp struct {
+	Symbol    string                 `json:"symbol"`
+	Estimator string                 `json:"estimator"`
+	From      time.Time              `json:"from"`
+	To        time.Time              `json:"to"`
+	Cone      []engine.VolConeWindow `json:"cone"`
+	Implied   *ImpliedVolOverlay     `json:"implied,omitempty"`
+}
+
+// atmImpliedVol prices the strike nearest spot in the option chain at the
+// given time and returns the average of its call and put implied vols.
+func atmImpliedVol(symbol, expiry, at string) (*ImpliedVolOverlay, error) {
+	expiryDate, err := time.Parse("2006-01-02", expiry)
+	if err != nil {
+		return nil, errors.New("invalid expiry date format")
+	}
+	atTime, err := time.Parse("2006-01-02 15:04:05", at)
+	if err != nil {
+		return nil, errors.New("invalid time format")
+	}
+	days := expiryDate.Sub(atTime).Hours() / 24
+	if days <= 0 {
+		return nil, errors.New("expiry is not after time")
+	}
+
+	var spot []float64
+	err = database.DB.Table("ohlc_data_nse_eq").
+		Select("close").
+		Where(`ticker = ? AND "time" <= ?`, symbol, at).
+		Order(`"time" DESC`).Limit(1).
+		Scan(&spot).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(spot) == 0 {
+		return nil, errors.New("spot price not found")
+	}
+
+	var chain []struct {
+		StrikePrice float64
+		OptionType  string
+		Open        float64
+	}
+	err = database.DB.Table(optionTable(symbol)).
+		Select("strike_price, option_type, open").
+		Where("symbol = ? AND expiry_date = ? AND candle_time = ?", symbol, expiry, at).
+		Scan(&chain).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("option chain not found")
+	}
+
+	S := spot[0]
+	K := chain[0].StrikePrice
+	for _, row := range chain {
+		if math.Abs(row.StrikePrice-S) < math.Abs(K-S) {
+			K = row.StrikePrice
+		}
+	}
+	ov := &ImpliedVolOverlay{Expiry: expiry, Time: at, Spot: S, Strike: K, DaysToExpiry: days}
+	T, r := days/365.0, 0.065
+	var sum float64
+	var n int
+	for _, row := range chain {
+		if row.StrikePrice != K {
+			continue
+		}
+		switch row.OptionType {
+		case "CE":
+			if iv := options.ImpliedVolatility(row.Open, S, K, T, r, options.Call); !math.IsNaN(iv) {
+				ov.CallIV = iv
+				sum, n = sum+iv, n+1
+			}
+		case "PE":
+			if iv := options.ImpliedVolatility(row.Open, S, K, T, r, options.Put); !math.IsNaN(iv) {
+				ov.PutIV = iv
+				sum, n = sum+iv, n+1
+			}
+		}
+	}
+	if n == 0 {
+		return nil, errors.New("implied volatility did not converge")
+	}
+	ov.IV = sum / float64(n)
+	return ov, nil
+}
+
+// VolConeHandler returns the realized volatility cone of a symbol's daily
+// bars and, given an expiry and time, the ATM implied vol ranked against it.
+func VolConeHandler(dp engine.DataProvider) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req VolConeReq
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "Invalid Request format " + err.Error(),
+			})
+		}
+		if req.Symbol == "" {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "symbol is required",
+			})
+		}
+		if req.Estimator == "" {
+			req.Estimator = "close_to_close"
+		}
+		if len(req.Windows) == 0 {
+			req.Windows = defaultConeWindows
+		}
+		if (req.Expiry == "") != (req.Time == "") {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: "expiry and time must be given together",
+			})
+		}
+
+		bars, err := dp.LoadOHLCV(req.Symbol, "1D")
+		if err != nil {
+			return c.Status(500).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		if len(bars) == 0 {
+			return c.Status(404).JSON(models.APIResponse{
+				Success: false,
+				Message: "no candles for " + req.Symbol,
+			})
+		}
+		cone, err := engine.VolCone(bars, req.Estimator, req.Windows, engine.BarsPerYear("1D"))
+		if err != nil {
+			return c.Status(400).JSON(models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		resp := VolConeResp{
+			Symbol:    req.Symbol,
+			Estimator: req.Estimator,
+			From:      bars[0].Time,
+			To:        bars[len(bars)-1].Time,
+			Cone:      cone,
+		}
+
+		if req.Expiry != "" {
+			ov, err := atmImpliedVol(req.Symbol, req.Expiry, req.Time)
+			if err != nil {
+				return c.Status(400).JSON(models.APIResponse{
+					Success: false,
+					Message: err.Error(),
+				})
+			}
+			// compare with the window nearest the option's life in trading days
+			life := ov.DaysToExpiry * 252 / 365
+			best := 0
+			for i, w := range req.Windows {
+				if math.Abs(float64(w)-life) < math.Abs(float64(req.Windows[best])-life) {
+					best = i
+				}
+			}
+			ov.Window = req.Windows[best]
+			var realized []float64
+			for _, v := range engine.VolEstimators[req.Estimator](bars, ov.Window, engine.BarsPerYear("1D")) {
+				if !math.IsNaN(v) {
+					realized = append(realized, v)
+				}
+			}
+			if len(realized) > 0 {
+				ov.PercentRank = engine.PercentRank(realized, ov.IV)
+			}
+			resp.Implied = ov
+		}
+
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Volatility cone computed",
+			Data:    resp,
+		})
+	}
+}
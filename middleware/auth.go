@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Auth reads a "Bearer <token>" Authorization header issued by the login
+// handlers and stores the user ID in c.Locals("user_id"). Requests without
+// the header, or with a stale or invalid token, pass through anonymously so
+// public endpoints keep working; handlers that need a user check UserID.
+func Auth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		raw, ok := strings.CutPrefix(c.Get("Authorization"), "Bearer ")
+		if !ok || raw == "" {
+			return c.Next()
+		}
+		if id, err := ParseToken(raw); err == nil {
+			c.Locals("user_id", id)
+		}
+		return c.Next()
+	}
+}
+
+// ParseToken checks a token issued by the login handlers and returns its
+// user ID. It is for connections that cannot send the Authorization header,
+// such as websockets.
+func ParseToken(raw string) (uint, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return 0, err
+	}
+	id, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, errors.New("token has no user_id")
+	}
+	return uint(id), nil
+}
+
+// UserID returns the ID stored by Auth, if the request carried a token.
+func UserID(c *fiber.Ctx) (uint, bool) {
+	id, ok := c.Locals("user_id").(uint)
+	return id, ok
+}
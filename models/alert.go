@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// AlertSubscription evaluates a condition on every new bar of its symbols
+// and timeframes and alerts its owner when it fires. Condition, Symbols and
+// Timeframes hold JSON.
+type AlertSubscription struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	UserID     uint   `gorm:"not null;uniqueIndex:idx_alert_subscriptions_user_name" json:"-"`
+	Name       string `gorm:"not null;uniqueIndex:idx_alert_subscriptions_user_name" json:"name"`
+	Condition  string `gorm:"type:jsonb;not null" json:"-"`
+	Symbols    string `gorm:"type:jsonb;not null" json:"-"`
+	Timeframes string `gorm:"type:jsonb;not null" json:"-"`
+	// Repeat alerts on every bar the condition holds, not only on the bar
+	// it becomes true.
+	Repeat     bool   `json:"repeat"`
+	Active     bool   `json:"active"`
+	WebhookURL string `json:"webhook_url"`
+	// Secret is the HMAC key webhook payloads are signed with.
+	Secret    string    `gorm:"not null" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Alert is one firing of a subscription. The unique index lets a bar alert
+// at most once per subscription, symbol and timeframe.
+type Alert struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	SubscriptionID uint      `gorm:"not null;uniqueIndex:idx_alerts_bar" json:"subscription_id"`
+	UserID         uint      `gorm:"not null;index" json:"-"`
+	Name           string    `json:"name"` // subscription name when it fired
+	Symbol         string    `gorm:"not null;uniqueIndex:idx_alerts_bar" json:"symbol"`
+	Timeframe      string    `gorm:"not null;uniqueIndex:idx_alerts_bar" json:"timeframe"`
+	BarTime        time.Time `gorm:"not null;uniqueIndex:idx_alerts_bar" json:"bar_time"`
+	Close          float64   `json:"close"`
+	// WebhookStatus is "" without a webhook, else pending, delivered or failed.
+	WebhookStatus   string    `json:"webhook_status,omitempty"`
+	WebhookAttempts int       `json:"webhook_attempts,omitempty"`
+	WebhookError    string    `json:"webhook_error,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// CustomIndicator is an expression indicator saved by a user. Params and Body
+// hold the JSON of its params and expression tokens.
+type CustomIndicator struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_custom_indicators_user_name" json:"-"`
+	Name        string    `gorm:"not null;uniqueIndex:idx_custom_indicators_user_name" json:"name"`
+	Description string    `json:"description"`
+	Params      string    `gorm:"type:jsonb;not null" json:"-"`
+	Body        string    `gorm:"type:jsonb;not null" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Script is a Starlark indicator saved by a user. Params holds the JSON of
+// its params.
+type Script struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_scripts_user_name" json:"-"`
+	Name        string    `gorm:"not null;uniqueIndex:idx_scripts_user_name" json:"name"`
+	Description string    `json:"description"`
+	Params      string    `gorm:"type:jsonb;not null" json:"-"`
+	Warmup      int       `json:"warmup"`
+	Source      string    `gorm:"type:text;not null" json:"source"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
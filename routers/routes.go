@@ -28,19 +28,42 @@ func Setup(app *fiber.App) {
 	})
 
 	e := engine.BuildRegistry()
+	// shared across requests so reruns reuse candles and computed series
+	dp := engine.NewCachedProvider(engine.NewPGProvider(database.DB), 512<<20)
+	handlers.StartAlertScheduler(e)
 
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
 	}))
 
-	api := app.Group("/api/v1")
+	api := app.Group("/api/v1", middleware.Auth())
 
 	api.Get("/tickers", handlers.GetTickers)
 	api.Get("/ticker/bags", handlers.GetTickerBags)
 	api.Get("/expiries", handlers.GetTickerExpiries())
 	api.Get("/option_chain", handlers.FetchOptionChain)
 	api.Post("/condition/validate", handlers.ValidateConditionHandler(e))
-	api.Post("/backtest", handlers.BacktestRunHandler(e, engine.NewPGProvider(database.DB)))
+	api.Post("/condition/plan", handlers.PlanConditionHandler(e))
+	api.Post("/condition/parse", handlers.ParseConditionHandler(e))
+	api.Post("/condition/format", handlers.FormatConditionHandler(e))
+	api.Post("/condition/explain", handlers.ExplainConditionHandler(e, dp))
+	api.Post("/condition/audit", handlers.AuditConditionHandler(e, dp))
+	api.Get("/cache/stats", handlers.CacheStatsHandler(dp))
+	api.Post("/cache/invalidate", handlers.CacheInvalidateHandler(dp))
+	api.Post("/backtest", handlers.BacktestRunHandler(e, dp))
+	api.Post("/backtest/report", handlers.BacktestReportHandler(e, dp))
+	api.Post("/backtest/export/:kind", handlers.BacktestExportHandler(e, dp))
+	api.Post("/volatility/cone", handlers.VolConeHandler(dp))
+	api.Get("/indicators/custom", handlers.ListCustomIndicatorsHandler())
+	api.Post("/indicators/custom", handlers.SaveCustomIndicatorHandler(e))
+	api.Delete("/indicators/custom/:name", handlers.DeleteCustomIndicatorHandler())
+	api.Get("/indicators/scripts", handlers.ListScriptsHandler())
+	api.Post("/indicators/scripts", handlers.SaveScriptHandler(e))
+	api.Delete("/indicators/scripts/:name", handlers.DeleteScriptHandler())
+	api.Get("/alerts/subscriptions", handlers.ListAlertSubscriptionsHandler())
+	api.Post("/alerts/subscriptions", handlers.SaveAlertSubscriptionHandler(e))
+	api.Delete("/alerts/subscriptions/:name", handlers.DeleteAlertSubscriptionHandler())
+	api.Get("/alerts", handlers.ListAlertsHandler())
 
 	app.Get("/news", handlers.GetNewsList)
 